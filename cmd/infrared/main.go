@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"os"
 	"os/signal"
@@ -19,10 +20,11 @@ const (
 )
 
 var (
-	configPath = "config.yml"
-	workingDir = "."
-	proxiesDir = "./proxies"
-	logLevel   = "info"
+	configPath      = "config.yml"
+	workingDir      = "."
+	proxiesDir      = "./proxies"
+	logLevel        = "info"
+	shutdownTimeout = 30 * time.Second
 )
 
 func envVarString(p *string, name string) {
@@ -34,11 +36,30 @@ func envVarString(p *string, name string) {
 	*p = v
 }
 
+func envVarDuration(p *time.Duration, name string) {
+	key := envVarPrefix + "_" + name
+	v := os.Getenv(key)
+	if v == "" {
+		return
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Warn().
+			Str("key", key).
+			Str("value", v).
+			Msg("Invalid duration; using default")
+		return
+	}
+	*p = d
+}
+
 func initEnvVars() {
 	envVarString(&configPath, "CONFIG")
 	envVarString(&workingDir, "WORKING_DIR")
 	envVarString(&proxiesDir, "PROXIES_DIR")
 	envVarString(&logLevel, "LOG_LEVEL")
+	envVarDuration(&shutdownTimeout, "SHUTDOWN_TIMEOUT")
 }
 
 func initFlags() {
@@ -46,6 +67,7 @@ func initFlags() {
 	pflag.StringVarP(&workingDir, "working-dir", "w", workingDir, "changes the current working directory")
 	pflag.StringVarP(&proxiesDir, "proxies-dir", "p", proxiesDir, "path to the proxies directory")
 	pflag.StringVarP(&logLevel, "log-level", "l", logLevel, "log level [debug, info, warn, error]")
+	pflag.DurationVar(&shutdownTimeout, "shutdown-timeout", shutdownTimeout, "maximum duration to wait for players to disconnect on shutdown")
 	pflag.Parse()
 }
 
@@ -100,10 +122,12 @@ func run() error {
 		return err
 	}
 
-	srv := ir.NewWithConfigProvider(config.FileProvider{
+	prv := config.FileProvider{
 		ConfigPath:  configPath,
 		ProxiesPath: proxiesDir,
-	})
+	}
+
+	srv := ir.NewWithConfigProvider(prv)
 	srv.Logger = log.Logger
 
 	errChan := make(chan error, 1)
@@ -111,32 +135,97 @@ func run() error {
 		errChan <- srv.ListenAndServe()
 	}()
 
+	reloadChan := make(chan struct{}, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watchConfig(ctx, prv, reloadChan)
+
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
 	log.Info().Msg("System is online")
 
-	select {
-	case sig := <-sigChan:
-		log.Info().Msg("Received " + sig.String())
-	case err := <-errChan:
-		switch {
-		case errors.Is(err, ir.ErrNoServers):
-			log.Fatal().
-				Str("docs", "https://infrared.dev/config/proxies").
-				Msg("No proxy configs found; Check the docs")
-		case errors.Is(err, ir.ErrNoTrustedCIDRs):
-			log.Fatal().
-				Str("docs", "https://infrared.dev/features/proxy-protocol#receive-proxy-protocol").
-				Msg("Receive PROXY Protocol enabled, but no CIDRs specified; Check the docs")
-		default:
-			if err != nil {
-				return err
+	for {
+		select {
+		case sig := <-sigChan:
+			if sig == syscall.SIGHUP {
+				reloadConfig(srv, prv)
+				continue
+			}
+
+			log.Info().Msg("Received " + sig.String())
+			shutdown(srv)
+		case <-reloadChan:
+			reloadConfig(srv, prv)
+			continue
+		case err := <-errChan:
+			switch {
+			case errors.Is(err, ir.ErrNoServers):
+				log.Fatal().
+					Str("docs", "https://infrared.dev/config/proxies").
+					Msg("No proxy configs found; Check the docs")
+			case errors.Is(err, ir.ErrNoTrustedCIDRs):
+				log.Fatal().
+					Str("docs", "https://infrared.dev/features/proxy-protocol#receive-proxy-protocol").
+					Msg("Receive PROXY Protocol enabled, but no CIDRs specified; Check the docs")
+			default:
+				if err != nil {
+					return err
+				}
 			}
 		}
+
+		break
 	}
 
 	log.Info().Msg("Bye")
 
 	return nil
 }
+
+func shutdown(srv *ir.Infrared) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Warn().
+			Err(err).
+			Msg("Not all connections closed in time")
+	}
+}
+
+func watchConfig(ctx context.Context, prv config.FileProvider, reloadChan chan<- struct{}) {
+	err := prv.Watch(ctx, func() {
+		select {
+		case reloadChan <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Msg("Failed to watch config files; Send SIGHUP to reload the config")
+	}
+}
+
+func reloadConfig(srv *ir.Infrared, prv config.FileProvider) {
+	log.Info().Msg("Reloading config")
+
+	cfg, err := prv.Config()
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("Failed to read config; Keeping old config")
+		return
+	}
+
+	if err := srv.Reload(cfg); errors.Is(err, ir.ErrListenersNotBound) {
+		log.Error().
+			Err(err).
+			Msg("Config reloaded; Failed to bind listeners")
+	} else if err != nil {
+		log.Error().
+			Err(err).
+			Msg("Invalid config; Keeping old config")
+	}
+}
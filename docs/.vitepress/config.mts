@@ -24,7 +24,21 @@ export default defineConfig({
         text: 'Features',
         items: [
           { text: 'PROXY Protocol', link: '/features/proxy-protocol' },
+          { text: 'RealIP', link: '/features/realip' },
+          { text: 'Player Forwarding', link: '/features/player-forwarding' },
+          { text: 'Online Mode', link: '/features/online-mode' },
+          { text: 'Player Lists', link: '/features/player-lists' },
+          { text: 'Listeners', link: '/features/listeners' },
+          { text: 'Load Balancing', link: '/features/load-balancing' },
+          { text: 'Routing', link: '/features/routing' },
+          { text: 'Health Checks', link: '/features/health-checks' },
+          { text: 'Disconnect Messages', link: '/features/disconnect-messages' },
+          { text: 'Status Responses', link: '/features/status-responses' },
+          { text: 'Metrics', link: '/features/metrics' },
+          { text: 'Admin API', link: '/features/admin-api' },
           { text: 'Rate Limiter', link: '/features/rate-limiter' },
+          { text: 'IP Filter', link: '/features/ip-filter' },
+          { text: 'GeoIP Filter', link: '/features/geoip-filter' },
         ]
       },
       {
@@ -60,11 +74,25 @@ export default defineConfig({
         text: 'Features',
         items: [
           { text: 'PROXY Protocol', link: '/features/proxy-protocol' },
+          { text: 'RealIP', link: '/features/realip' },
+          { text: 'Player Forwarding', link: '/features/player-forwarding' },
+          { text: 'Online Mode', link: '/features/online-mode' },
+          { text: 'Player Lists', link: '/features/player-lists' },
+          { text: 'Listeners', link: '/features/listeners' },
+          { text: 'Load Balancing', link: '/features/load-balancing' },
+          { text: 'Routing', link: '/features/routing' },
+          { text: 'Health Checks', link: '/features/health-checks' },
+          { text: 'Disconnect Messages', link: '/features/disconnect-messages' },
+          { text: 'Status Responses', link: '/features/status-responses' },
+          { text: 'Metrics', link: '/features/metrics' },
+          { text: 'Admin API', link: '/features/admin-api' },
           {
             text: 'Filters',
             link: '/features/filters',
             items: [
               { text: 'Rate Limiter', link: '/features/rate-limiter' },
+              { text: 'IP Filter', link: '/features/ip-filter' },
+              { text: 'GeoIP Filter', link: '/features/geoip-filter' },
             ]
           }
         ]
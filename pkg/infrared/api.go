@@ -0,0 +1,224 @@
+package infrared
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	apiConnectionsPath = "/connections"
+	apiServersPath     = "/servers"
+)
+
+type APIConfig struct {
+	// Bind is the address of the HTTP server of the admin API
+	Bind string `yaml:"bind"`
+	// Token is required as a bearer token in the Authorization header if set
+	Token string `yaml:"token"`
+}
+
+type apiConnection struct {
+	ID            string       `json:"id"`
+	PlayerName    string       `json:"playerName"`
+	PlayerUUID    *uuid.UUID   `json:"playerUUID,omitempty"`
+	Domain        ServerDomain `json:"domain"`
+	ClientIP      string       `json:"clientIP"`
+	ClientAddress string       `json:"clientAddress"`
+	Server        string       `json:"server"`
+	ServerAddress string       `json:"serverAddress"`
+	ConnectedAt   time.Time    `json:"connectedAt"`
+	Duration      string       `json:"duration"`
+}
+
+type apiServer struct {
+	Name      string             `json:"name"`
+	Domains   []ServerDomain     `json:"domains"`
+	Addresses []apiServerAddress `json:"addresses"`
+}
+
+type apiServerAddress struct {
+	Address           ServerAddress `json:"address"`
+	Healthy           bool          `json:"healthy"`
+	ActiveConnections int32         `json:"activeConnections"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// startAPIServer serves the admin API on the bind address of cfg.
+// The returned function stops the server.
+func (ir *Infrared) startAPIServer(cfg *APIConfig) (func(), error) {
+	if cfg == nil {
+		return func() {}, nil
+	}
+
+	ir.Logger.Info().
+		Str("bind", cfg.Bind).
+		Msg("Starting admin API")
+
+	// The token is read from the current config, so that a reload can change it
+	// without binding the address again
+	token := func() string {
+		if api := ir.config().API; api != nil && api.Bind == cfg.Bind {
+			return api.Token
+		}
+		return cfg.Token
+	}
+
+	return ir.startHTTPServer(cfg.Bind, ir.apiHandler(token))
+}
+
+// isAPIBindEqual reports if both configs disable the admin API or serve it on the same address.
+func isAPIBindEqual(a, b *APIConfig) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Bind == b.Bind
+}
+
+// APIHandler returns the HTTP handler of the admin API.
+// If token is not empty, every request has to send it as a bearer token.
+// It can be used to serve the admin API on a custom HTTP server.
+//
+//	GET    /connections       lists all connections that are piped to a server
+//	DELETE /connections/{id}  kicks the connection with the given ID
+//	GET    /servers           lists all servers and the health of their addresses
+func (ir *Infrared) APIHandler(token string) http.Handler {
+	return ir.apiHandler(func() string {
+		return token
+	})
+}
+
+// apiHandler returns the handler of the admin API that reads the token on every request.
+func (ir *Infrared) apiHandler(token func() string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(apiConnectionsPath, ir.handleAPIConnections)
+	mux.HandleFunc(apiConnectionsPath+"/", ir.handleAPIConnection)
+	mux.HandleFunc(apiServersPath, ir.handleAPIServers)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := token()
+		if token == "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		reqToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(reqToken), []byte(token)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, apiError{Error: "invalid token"})
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (ir *Infrared) handleAPIConnections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	now := time.Now()
+	pipedConns := ir.conns.list()
+	conns := make([]apiConnection, len(pipedConns))
+	for i, pc := range pipedConns {
+		conns[i] = newAPIConnection(pc, now)
+	}
+
+	writeJSON(w, http.StatusOK, conns)
+}
+
+func (ir *Infrared) handleAPIConnection(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeMethodNotAllowed(w, http.MethodDelete)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, apiConnectionsPath+"/")
+	if !ir.conns.kick(id) {
+		writeJSON(w, http.StatusNotFound, apiError{Error: "connection not found"})
+		return
+	}
+
+	ir.Logger.Info().
+		Str("id", id).
+		Msg("Kicked connection")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (ir *Infrared) handleAPIServers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	srvs := ir.serverList()
+	apiSrvs := make([]apiServer, len(srvs))
+	for i, srv := range srvs {
+		addrs := make([]apiServerAddress, len(srv.backends))
+		for j, b := range srv.backends {
+			addrs[j] = apiServerAddress{
+				Address:           b.addr,
+				Healthy:           b.healthy.Load(),
+				ActiveConnections: b.activeConns.Load(),
+			}
+		}
+
+		apiSrvs[i] = apiServer{
+			Name:      srv.Name(),
+			Domains:   srv.cfg.Domains,
+			Addresses: addrs,
+		}
+	}
+
+	writeJSON(w, http.StatusOK, apiSrvs)
+}
+
+func newAPIConnection(pc pipedConn, now time.Time) apiConnection {
+	conn := apiConnection{
+		ID:          pc.id,
+		PlayerName:  pc.playerName,
+		Domain:      pc.domain,
+		Server:      pc.serverName,
+		ConnectedAt: pc.connectedAt,
+		Duration:    now.Sub(pc.connectedAt).Round(time.Second).String(),
+	}
+
+	if pc.playerUUID != uuid.Nil {
+		conn.PlayerUUID = &pc.playerUUID
+	}
+
+	if pc.clientAddr != nil {
+		conn.ClientAddress = pc.clientAddr.String()
+		conn.ClientIP = conn.ClientAddress
+		if host, _, err := net.SplitHostPort(conn.ClientAddress); err == nil {
+			conn.ClientIP = host
+		}
+	}
+
+	if pc.serverAddr != nil {
+		conn.ServerAddress = pc.serverAddr.String()
+	}
+
+	return conn
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "method not allowed"})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
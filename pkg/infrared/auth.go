@@ -0,0 +1,250 @@
+package infrared
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/haveachin/infrared/pkg/infrared/protocol"
+	"github.com/haveachin/infrared/pkg/infrared/protocol/login"
+)
+
+var (
+	ErrNotAuthenticated   = errors.New("not authenticated")
+	ErrInvalidVerifyToken = errors.New("invalid verify token")
+)
+
+const (
+	defaultSessionServerURL = "https://sessionserver.mojang.com"
+	defaultAuthTimeout      = 10 * time.Second
+
+	// authKeyBits is the size of the RSA key that the shared secret is encrypted with.
+	// Clients only accept 1024 bit keys.
+	authKeyBits        = 1024
+	verifyTokenLength  = 4
+	sharedSecretLength = 16
+)
+
+type OnlineModeConfig struct {
+	// SessionServerURL is the base URL of the session server that verifies the players.
+	// Defaults to the session server of Mojang.
+	SessionServerURL string `yaml:"sessionServerURL"`
+	// Timeout of the requests to the session server. Defaults to 10s.
+	Timeout time.Duration `yaml:"timeout"`
+	// PreventProxyConnections sends the address of the player to the session server,
+	// so that players have to log in from the same address that they joined with.
+	PreventProxyConnections bool `yaml:"preventProxyConnections"`
+}
+
+// gameProfile is the profile of a player that the session server returns.
+type gameProfile struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Properties []ProfileProperty `json:"properties"`
+}
+
+// authenticator performs the encryption handshake with clients
+// and verifies the players at the session server like an online-mode server.
+type authenticator struct {
+	key *rsa.PrivateKey
+	// publicKey is the DER encoded public key of key
+	publicKey               []byte
+	sessionServerURL        string
+	preventProxyConnections bool
+	client                  *http.Client
+}
+
+func newAuthenticator(cfg OnlineModeConfig) (*authenticator, error) {
+	sessionServerURL := cfg.SessionServerURL
+	if sessionServerURL == "" {
+		sessionServerURL = defaultSessionServerURL
+	}
+
+	if _, err := url.Parse(sessionServerURL); err != nil {
+		return nil, err
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultAuthTimeout
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, authKeyBits)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &authenticator{
+		key:                     key,
+		publicKey:               publicKey,
+		sessionServerURL:        strings.TrimSuffix(sessionServerURL, "/"),
+		preventProxyConnections: cfg.PreventProxyConnections,
+		client: &http.Client{
+			Timeout: timeout,
+		},
+	}, nil
+}
+
+// authenticate performs the encryption handshake with c and verifies the player at the session server.
+// Once the shared secret is known, the connection is encrypted.
+// The player of req is replaced by the verified profile.
+func (a *authenticator) authenticate(c *clientConn, req *ServerRequest) error {
+	verifyToken := make([]byte, verifyTokenLength)
+	if _, err := rand.Read(verifyToken); err != nil {
+		return err
+	}
+
+	var pk protocol.Packet
+	if err := (login.ClientBoundEncryptionRequest{
+		PublicKey:   a.publicKey,
+		VerifyToken: verifyToken,
+	}).Marshal(&pk); err != nil {
+		return err
+	}
+
+	if err := c.WritePacket(pk); err != nil {
+		return err
+	}
+
+	if err := c.ReadPacket(&pk); err != nil {
+		return err
+	}
+
+	var resp login.ServerBoundEncryptionResponse
+	if err := resp.Unmarshal(pk, req.ProtocolVersion); err != nil {
+		return err
+	}
+
+	// The client encrypts the connection right after the encryption response.
+	// Without its shared secret no packets can be sent to it anymore.
+	sharedSecret, err := rsa.DecryptPKCS1v15(rand.Reader, a.key, resp.SharedSecret)
+	if err != nil {
+		c.isSecretUnknown = true
+		return err
+	}
+
+	if len(sharedSecret) != sharedSecretLength {
+		c.isSecretUnknown = true
+		return fmt.Errorf("invalid shared secret length of %d", len(sharedSecret))
+	}
+
+	if err := c.enableEncryption(sharedSecret); err != nil {
+		c.isSecretUnknown = true
+		return err
+	}
+
+	if err := a.verifyToken(c, verifyToken, resp); err != nil {
+		return err
+	}
+
+	profile, err := a.hasJoined(req.PlayerName, login.AuthDigest("", sharedSecret, a.publicKey), req.ClientAddr)
+	if err != nil {
+		return err
+	}
+
+	id, err := uuid.Parse(profile.ID)
+	if err != nil {
+		return err
+	}
+
+	req.PlayerName = profile.Name
+	req.PlayerUUID = id
+	req.PlayerProperties = profile.Properties
+	req.IsAuthenticated = true
+
+	return nil
+}
+
+// verifyToken checks that the client answered with the verify token that it was sent.
+// Clients between 1.19 and 1.19.2 with a chat signing key sign the token with their key instead.
+func (a *authenticator) verifyToken(c *clientConn, verifyToken []byte, resp login.ServerBoundEncryptionResponse) error {
+	if resp.HasVerifyToken {
+		token, err := rsa.DecryptPKCS1v15(rand.Reader, a.key, resp.VerifyToken)
+		if err != nil {
+			return err
+		}
+
+		if subtle.ConstantTimeCompare(token, verifyToken) != 1 {
+			return ErrInvalidVerifyToken
+		}
+		return nil
+	}
+
+	if !c.loginStart.HasSignature {
+		return fmt.Errorf("%w: no chat signing key", ErrInvalidVerifyToken)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(c.loginStart.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: unsupported chat signing key", ErrInvalidVerifyToken)
+	}
+
+	salt := make([]byte, 8)
+	binary.BigEndian.PutUint64(salt, uint64(resp.Salt))
+	h := sha256.New()
+	h.Write(verifyToken)
+	h.Write(salt)
+	if err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, h.Sum(nil), resp.MessageSignature); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidVerifyToken, err)
+	}
+
+	return nil
+}
+
+// hasJoined asks the session server if the player joined with the server hash.
+func (a *authenticator) hasJoined(name, serverHash string, clientAddr net.Addr) (gameProfile, error) {
+	query := url.Values{}
+	query.Set("username", name)
+	query.Set("serverId", serverHash)
+	if a.preventProxyConnections {
+		clientIP := clientAddr.String()
+		if host, _, err := net.SplitHostPort(clientIP); err == nil {
+			clientIP = host
+		}
+		query.Set("ip", clientIP)
+	}
+
+	resp, err := a.client.Get(a.sessionServerURL + "/session/minecraft/hasJoined?" + query.Encode())
+	if err != nil {
+		return gameProfile{}, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return gameProfile{}, fmt.Errorf("player %q did not join", name)
+	default:
+		return gameProfile{}, fmt.Errorf("session server responded with status %d", resp.StatusCode)
+	}
+
+	var profile gameProfile
+	if err := json.NewDecoder(resp.Body).Decode(&profile); err != nil {
+		return gameProfile{}, err
+	}
+
+	return profile, nil
+}
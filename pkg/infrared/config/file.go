@@ -7,6 +7,7 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 
 	ir "github.com/haveachin/infrared/pkg/infrared"
 	"gopkg.in/yaml.v3"
@@ -143,5 +144,9 @@ func readAndUnmashalServerConfig(dcr decoder, path string) (ir.ServerConfig, err
 		return ir.ServerConfig{}, err
 	}
 
+	if cfg.Name == "" {
+		cfg.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
 	return cfg, nil
 }
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce is the time to wait for more changes before reporting them.
+// Editors tend to write files in multiple steps.
+const watchDebounce = 500 * time.Millisecond
+
+// Watch calls onChange every time the config file or a file in the proxies directory changes.
+// Changes that happen in quick succession are reported as a single change.
+// It blocks until ctx is done.
+func (p FileProvider) Watch(ctx context.Context, onChange func()) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	configPath, err := filepath.EvalSymlinks(p.ConfigPath)
+	if err != nil {
+		return err
+	}
+	configPath, err = filepath.Abs(configPath)
+	if err != nil {
+		return err
+	}
+
+	// Watching the directory instead of the file itself also
+	// catches editors that replace the file on save.
+	if err = w.Add(filepath.Dir(configPath)); err != nil {
+		return err
+	}
+
+	proxiesPath, err := filepath.EvalSymlinks(p.ProxiesPath)
+	if err != nil {
+		return err
+	}
+	proxiesPath, err = filepath.Abs(proxiesPath)
+	if err != nil {
+		return err
+	}
+
+	if err = addDirsRecursive(w, proxiesPath); err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(watchDebounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-w.Errors:
+			return err
+		case e := <-w.Events:
+			isConfig := e.Name == configPath
+			isProxy := isSubPath(proxiesPath, e.Name)
+			if !isConfig && !isProxy {
+				continue
+			}
+
+			if isProxy && e.Has(fsnotify.Create) {
+				// New directories need to be watched as well
+				_ = addDirsRecursive(w, e.Name)
+			}
+
+			timer.Reset(watchDebounce)
+		case <-timer.C:
+			onChange()
+		}
+	}
+}
+
+func addDirsRecursive(w *fsnotify.Watcher, path string) error {
+	return filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() {
+			return nil
+		}
+
+		return w.Add(path)
+	})
+}
+
+func isSubPath(dir, path string) bool {
+	return strings.HasPrefix(path, dir+string(filepath.Separator))
+}
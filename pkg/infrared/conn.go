@@ -2,16 +2,26 @@ package infrared
 
 import (
 	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
 	"io"
 	"net"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/haveachin/infrared/pkg/infrared/protocol"
 	"github.com/haveachin/infrared/pkg/infrared/protocol/handshaking"
 	"github.com/haveachin/infrared/pkg/infrared/protocol/login"
 )
 
+// disconnectWriteTimeout is how long sending a disconnect packet to a client may take.
+const disconnectWriteTimeout = time.Second
+
 var cliConnPool = sync.Pool{
 	New: func() any {
 		return &clientConn{
@@ -83,6 +93,26 @@ func (c *conn) WritePackets(pks ...protocol.Packet) error {
 	return nil
 }
 
+// enableEncryption encrypts everything that is read and written after it is called
+// with AES/CFB8. The shared secret is used as the key and the IV.
+func (c *conn) enableEncryption(sharedSecret []byte) error {
+	block, err := aes.NewCipher(sharedSecret)
+	if err != nil {
+		return err
+	}
+
+	c.r = bufio.NewReader(cipher.StreamReader{
+		S: protocol.NewCFB8Decrypter(block, sharedSecret),
+		R: c.r,
+	})
+	c.w = cipher.StreamWriter{
+		S: protocol.NewCFB8Encrypter(block, sharedSecret),
+		W: c.w,
+	}
+
+	return nil
+}
+
 func (c *conn) ForceClose() error {
 	if conn, ok := c.Conn.(*net.TCPConn); ok {
 		if err := conn.SetLinger(0); err != nil {
@@ -98,6 +128,18 @@ func (c *conn) Close() error {
 
 type ServerConn struct {
 	conn
+
+	onClose   func()
+	closeOnce sync.Once
+}
+
+func (c *ServerConn) Close() error {
+	c.closeOnce.Do(func() {
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return c.conn.Close()
 }
 
 func NewServerConn(c net.Conn) *ServerConn {
@@ -113,6 +155,41 @@ type clientConn struct {
 	handshake  handshaking.ServerBoundHandshake
 	loginStart login.ServerBoundLoginStart
 	reqDomain  ServerDomain
+	// isHandshakeRead is set once readPks[0] holds the decoded handshake
+	isHandshakeRead bool
+	// realIP is the address of the player if it was received via RealIP
+	realIP net.Addr
+	// geo is set by the GeoIP filter
+	geo geoLocation
+	// isSecretUnknown is set if the client encrypts the connection
+	// with a shared secret that Infrared could not decrypt
+	isSecretUnknown bool
+}
+
+// RemoteAddr returns the address of the player.
+func (c *clientConn) RemoteAddr() net.Addr {
+	if c.realIP != nil {
+		return c.realIP
+	}
+	return c.conn.RemoteAddr()
+}
+
+// readHandshake reads and decodes the handshake unless it was already read.
+func (c *clientConn) readHandshake() error {
+	if c.isHandshakeRead {
+		return nil
+	}
+
+	if err := c.ReadPacket(&c.readPks[0]); err != nil {
+		return err
+	}
+
+	if err := c.handshake.Unmarshal(c.readPks[0]); err != nil {
+		return err
+	}
+	c.isHandshakeRead = true
+
+	return nil
 }
 
 func newClientConn(c net.Conn) (*clientConn, func()) {
@@ -123,7 +200,172 @@ func newClientConn(c net.Conn) (*clientConn, func()) {
 
 	conn.conn = newConn(c)
 	conn.reqDomain = ""
+	conn.isHandshakeRead = false
+	conn.realIP = nil
+	conn.geo = geoLocation{}
+	conn.isSecretUnknown = false
 	return conn, func() {
 		cliConnPool.Put(conn)
 	}
 }
+
+// pipedConn is a client connection that is piped to a server.
+type pipedConn struct {
+	id          string
+	playerName  string
+	playerUUID  uuid.UUID
+	domain      ServerDomain
+	clientAddr  net.Addr
+	serverName  string
+	serverAddr  net.Addr
+	connectedAt time.Time
+
+	conn  *clientConn
+	relay *serverRelay
+}
+
+// connRegistry keeps track of all piped connections.
+// The zero value is ready to use.
+type connRegistry struct {
+	mu     sync.Mutex
+	nextID uint64
+	conns  map[string]*pipedConn
+	// disconnectMu is read locked while a disconnect packet is sent to a client.
+	// Removing a connection waits for it, because client connections are pooled.
+	disconnectMu sync.RWMutex
+}
+
+// add registers pc and returns its ID.
+func (r *connRegistry) add(pc *pipedConn) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conns == nil {
+		r.conns = make(map[string]*pipedConn)
+	}
+
+	r.nextID++
+	pc.id = strconv.FormatUint(r.nextID, 10)
+	r.conns[pc.id] = pc
+	return pc.id
+}
+
+func (r *connRegistry) remove(id string) {
+	r.mu.Lock()
+	delete(r.conns, id)
+	r.mu.Unlock()
+
+	// Waits for disconnect packets that are sent to the connection
+	r.disconnectMu.Lock()
+	r.disconnectMu.Unlock() //nolint:staticcheck // Empty critical section
+}
+
+// list returns a copy of all piped connections ordered by the time they connected.
+func (r *connRegistry) list() []pipedConn {
+	r.mu.Lock()
+	conns := make([]pipedConn, 0, len(r.conns))
+	for _, pc := range r.conns {
+		conns = append(conns, *pc)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].connectedAt.Before(conns[j].connectedAt)
+	})
+	return conns
+}
+
+// kick closes the client connection with the given ID.
+// It reports whether a connection with that ID was found.
+func (r *connRegistry) kick(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The lock is held while closing, because client connections are pooled
+	// and can only be reused after they are removed from the registry.
+	pc, ok := r.conns[id]
+	if !ok {
+		return false
+	}
+
+	_ = pc.conn.ForceClose()
+	return true
+}
+
+func (r *connRegistry) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.conns)
+}
+
+// disconnectAll sends a disconnect packet with the given reason to all clients
+// and closes their connections. Clients that cannot receive a disconnect packet,
+// like clients with an encrypted connection, are kept connected.
+// The packets are sent concurrently. It returns once all packets are sent or ctx is done.
+func (r *connRegistry) disconnectAll(ctx context.Context, reason any) {
+	r.mu.Lock()
+	conns := make([]*pipedConn, 0, len(r.conns))
+	for _, pc := range r.conns {
+		conns = append(conns, pc)
+	}
+	r.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, pc := range conns {
+		wg.Add(1)
+		go func(pc *pipedConn) {
+			defer wg.Done()
+			r.disconnect(pc, reason)
+		}(pc)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// disconnect sends a disconnect packet to the client of pc unless it was removed already.
+func (r *connRegistry) disconnect(pc *pipedConn, reason any) {
+	r.disconnectMu.RLock()
+	defer r.disconnectMu.RUnlock()
+
+	r.mu.Lock()
+	_, ok := r.conns[pc.id]
+	r.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	// The deadline also stops the relay from blocking the disconnect with a pending write
+	_ = pc.conn.SetWriteDeadline(time.Now().Add(disconnectWriteTimeout))
+	if err := pc.relay.disconnect(reason); err != nil {
+		if errors.Is(err, errOpaqueStream) {
+			_ = pc.conn.SetWriteDeadline(time.Time{})
+			return
+		}
+
+		// The client did not read the disconnect packet in time
+		_ = pc.conn.ForceClose()
+		return
+	}
+
+	// Close gracefully so the disconnect packet still reaches the client
+	_ = pc.conn.Close()
+}
+
+// closeAll closes all client connections.
+func (r *connRegistry) closeAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, pc := range r.conns {
+		_ = pc.conn.ForceClose()
+	}
+}
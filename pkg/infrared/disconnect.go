@@ -0,0 +1,170 @@
+package infrared
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/haveachin/infrared/pkg/infrared/protocol"
+	"github.com/haveachin/infrared/pkg/infrared/protocol/login"
+)
+
+var (
+	ErrServerNotFound    = errors.New("server not found")
+	ErrServerUnreachable = errors.New("server unreachable")
+)
+
+// DisconnectMessagesConfig holds the messages that are shown to a player
+// when their login gets rejected. A message is a chat component.
+// It can be a plain string or a chat component object like {"text": "Hi", "color": "red"}.
+type DisconnectMessagesConfig struct {
+	ServerNotFound     any `yaml:"serverNotFound"`
+	ServerUnreachable  any `yaml:"serverUnreachable"`
+	UnsupportedVersion any `yaml:"unsupportedVersion"`
+	NotAuthenticated   any `yaml:"notAuthenticated"`
+	NotWhitelisted     any `yaml:"notWhitelisted"`
+	// Banned can contain the placeholders {reason} and {expires}
+	Banned any `yaml:"banned"`
+	// RateLimited is only sent if it is set,
+	// because it requires reading from the rate limited connection.
+	RateLimited any `yaml:"rateLimited"`
+	// IPDenied is only sent if it is set, like RateLimited.
+	IPDenied any `yaml:"ipDenied"`
+	// GeoIPDenied is only sent if it is set, like RateLimited.
+	GeoIPDenied any `yaml:"geoIPDenied"`
+	// Filtered is sent when a request filter rejects a login.
+	// Messages of the filter, like RateLimited, take precedence if they are set.
+	Filtered any `yaml:"filtered"`
+	// Shutdown is sent to all connected players when Infrared shuts down.
+	// It is only sent if it is set and only the global message is used.
+	Shutdown any `yaml:"shutdown"`
+}
+
+var defaultDisconnectMessages = DisconnectMessagesConfig{
+	ServerNotFound:     "Server not found",
+	ServerUnreachable:  "Server is currently unreachable. Please try again later.",
+	UnsupportedVersion: "Your Minecraft version is not supported by this server.",
+	NotAuthenticated:   "Failed to verify username!",
+	NotWhitelisted:     "You are not whitelisted on this server!",
+	Banned:             "You are banned from this server.\nReason: {reason}\nYour ban expires: {expires}",
+	Filtered:           "You are not allowed to join this server.",
+}
+
+// DisconnectError is an error that carries the disconnect message for the player.
+type DisconnectError struct {
+	// Reason is a chat component; see DisconnectMessagesConfig.
+	// If Reason is nil, the global message for Err is used.
+	Reason any
+	Err    error
+}
+
+func (err DisconnectError) Error() string {
+	return err.Err.Error()
+}
+
+func (err DisconnectError) Unwrap() error {
+	return err.Err
+}
+
+// disconnectReason returns the chat component that should be sent
+// to the player for the given error. If no reason is known, nil is returned.
+// The placeholders of ban messages are replaced with the ban of err.
+func disconnectReason(err error, msgs DisconnectMessagesConfig) any {
+	reason := disconnectMessage(err, msgs)
+
+	var banErr BanError
+	if reason != nil && errors.As(err, &banErr) {
+		return banErr.fill(reason)
+	}
+
+	return reason
+}
+
+func disconnectMessage(err error, msgs DisconnectMessagesConfig) any {
+	var dErr DisconnectError
+	if errors.As(err, &dErr) && dErr.Reason != nil {
+		return dErr.Reason
+	}
+
+	if errors.Is(err, ErrRequestFiltered) {
+		return firstNonNil(filterMessage(err, msgs), msgs.Filtered, defaultDisconnectMessages.Filtered)
+	}
+
+	switch {
+	case errors.Is(err, ErrServerNotFound):
+		return firstNonNil(msgs.ServerNotFound, defaultDisconnectMessages.ServerNotFound)
+	case errors.Is(err, ErrServerUnreachable):
+		return firstNonNil(msgs.ServerUnreachable, defaultDisconnectMessages.ServerUnreachable)
+	case errors.Is(err, ErrUnsupportedClientVersion):
+		return firstNonNil(msgs.UnsupportedVersion, defaultDisconnectMessages.UnsupportedVersion)
+	case errors.Is(err, ErrNotAuthenticated):
+		return firstNonNil(msgs.NotAuthenticated, defaultDisconnectMessages.NotAuthenticated)
+	case errors.Is(err, ErrNotWhitelisted):
+		return firstNonNil(msgs.NotWhitelisted, defaultDisconnectMessages.NotWhitelisted)
+	case errors.Is(err, ErrBanned):
+		return firstNonNil(msgs.Banned, defaultDisconnectMessages.Banned)
+	}
+
+	return filterMessage(err, msgs)
+}
+
+// filterMessage returns the message of the filter that rejected a connection or a request.
+// Unlike the other messages, these are only sent if they are set.
+func filterMessage(err error, msgs DisconnectMessagesConfig) any {
+	switch {
+	case errors.Is(err, ErrRateLimitReached):
+		return msgs.RateLimited
+	case errors.Is(err, ErrIPDenied):
+		return msgs.IPDenied
+	case errors.Is(err, ErrGeoIPDenied):
+		return msgs.GeoIPDenied
+	}
+
+	return nil
+}
+
+func firstNonNil(vv ...any) any {
+	for _, v := range vv {
+		if v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+// marshalChat converts a chat component from the config into its JSON representation.
+func marshalChat(v any) (protocol.Chat, error) {
+	bb, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return protocol.Chat(bb), nil
+}
+
+// disconnect sends a login disconnect packet with the given reason to the client
+// and closes the connection afterwards. If the shared secret of the client is unknown,
+// the connection is closed without sending a packet.
+func (c *clientConn) disconnect(reason any) error {
+	// The client could not decrypt the packet anyway
+	if c.isSecretUnknown {
+		return c.Close()
+	}
+
+	chat, err := marshalChat(reason)
+	if err != nil {
+		return err
+	}
+
+	var pk protocol.Packet
+	if err := (login.ClientBoundDisconnect{
+		Reason: chat,
+	}).Marshal(&pk); err != nil {
+		return err
+	}
+
+	if err := c.WritePacket(pk); err != nil {
+		return err
+	}
+
+	// Close gracefully so the disconnect packet still reaches the client
+	return c.Close()
+}
@@ -1,7 +1,13 @@
 package infrared
 
 import (
+	"fmt"
 	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
 )
 
 type Filterer interface {
@@ -24,6 +30,27 @@ func WithFilterConfig(c FiltersConfig) FilterConfigFunc {
 
 type FiltersConfig struct {
 	RateLimiter *RateLimiterConfig `yaml:"rateLimiter"`
+	IPFilter    *IPFilterConfig    `yaml:"ipFilter"`
+	GeoIP       *GeoIPFilterConfig `yaml:"geoIP"`
+
+	// onCounterError is called if the rate limiter cannot count requests
+	onCounterError func(err error)
+	// logger logs the files of filters that cannot be reloaded if set
+	logger *zerolog.Logger
+}
+
+// withOnCounterError reports the errors of the request counter of the rate limiter to fn.
+func withOnCounterError(fn func(err error)) FilterConfigFunc {
+	return func(cfg *FiltersConfig) {
+		cfg.onCounterError = fn
+	}
+}
+
+// withFilterLogger logs the files of filters that cannot be reloaded to logger.
+func withFilterLogger(logger *zerolog.Logger) FilterConfigFunc {
+	return func(cfg *FiltersConfig) {
+		cfg.logger = logger
+	}
 }
 
 type Filter struct {
@@ -31,7 +58,7 @@ type Filter struct {
 	filterers []Filterer
 }
 
-func NewFilter(fns ...FilterConfigFunc) Filter {
+func NewFilter(fns ...FilterConfigFunc) (Filter, error) {
 	var cfg FiltersConfig
 	for _, fn := range fns {
 		fn(&cfg)
@@ -39,16 +66,34 @@ func NewFilter(fns ...FilterConfigFunc) Filter {
 
 	filterers := make([]Filterer, 0)
 
+	if cfg.IPFilter != nil {
+		f, err := newIPFilter(*cfg.IPFilter, cfg.logger)
+		if err != nil {
+			return Filter{}, fmt.Errorf("ip filter: %w", err)
+		}
+		filterers = append(filterers, f)
+	}
+
+	if cfg.GeoIP != nil {
+		f, err := newGeoIPFilter(*cfg.GeoIP, cfg.logger)
+		if err != nil {
+			return Filter{}, fmt.Errorf("geoip filter: %w", err)
+		}
+		filterers = append(filterers, f)
+	}
+
 	if cfg.RateLimiter != nil {
-		cfg := cfg.RateLimiter
-		f := RateLimitByIP(cfg.RequestLimit, cfg.WindowLength)
+		f, err := rateLimitFilter(*cfg.RateLimiter, cfg.onCounterError)
+		if err != nil {
+			return Filter{}, fmt.Errorf("rate limiter: %w", err)
+		}
 		filterers = append(filterers, f)
 	}
 
 	return Filter{
 		cfg:       cfg,
 		filterers: filterers,
-	}
+	}, nil
 }
 
 func (f Filter) Filter(c net.Conn) error {
@@ -59,3 +104,99 @@ func (f Filter) Filter(c net.Conn) error {
 	}
 	return nil
 }
+
+// fileReloader reloads a file that a filter depends on
+// once its reload interval passed and the file changed.
+// The methods are safe to call on a nil *fileReloader, which never reloads.
+type fileReloader struct {
+	path     string
+	interval time.Duration
+	load     func() error
+	// name describes the file in logs like "deny file"
+	name string
+	// logger is nil if failed reloads are not logged
+	logger *zerolog.Logger
+
+	// modTime is the modification time of the loaded file
+	modTime time.Time
+	// nextCheck is the Unix time in nanoseconds at which the file is checked next
+	nextCheck atomic.Int64
+	checking  atomic.Bool
+}
+
+// newFileReloader loads the file at path with load.
+// Failed reloads of the file are logged to logger if it is not nil.
+func newFileReloader(
+	path, name string,
+	interval time.Duration,
+	load func() error,
+	logger *zerolog.Logger,
+) (*fileReloader, error) {
+	r := &fileReloader{
+		path:     path,
+		interval: interval,
+		load:     load,
+		name:     name,
+		logger:   logger,
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := load(); err != nil {
+		return nil, err
+	}
+	r.modTime = info.ModTime()
+	r.nextCheck.Store(time.Now().Add(interval).UnixNano())
+
+	return r, nil
+}
+
+// reloadIfDue reloads the file in the background if the reload interval passed and the file changed.
+// Checking on use instead of in an own goroutine means that
+// filters of old configs do not need to be stopped.
+// If the file cannot be loaded, the previously loaded file stays in use.
+func (r *fileReloader) reloadIfDue() {
+	if r == nil {
+		return
+	}
+
+	now := time.Now()
+	if now.UnixNano() < r.nextCheck.Load() || !r.checking.CompareAndSwap(false, true) {
+		return
+	}
+	r.nextCheck.Store(now.Add(r.interval).UnixNano())
+
+	go func() {
+		defer r.checking.Store(false)
+
+		info, err := os.Stat(r.path)
+		if err != nil {
+			r.reloadFailed(err)
+			return
+		}
+
+		if info.ModTime().Equal(r.modTime) {
+			return
+		}
+
+		if err := r.load(); err != nil {
+			r.reloadFailed(err)
+			return
+		}
+		r.modTime = info.ModTime()
+	}()
+}
+
+func (r *fileReloader) reloadFailed(err error) {
+	if r.logger == nil {
+		return
+	}
+
+	r.logger.Error().
+		Err(err).
+		Str("path", r.path).
+		Msg("Failed to reload " + r.name)
+}
@@ -0,0 +1,178 @@
+package infrared
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5" //nolint:gosec // Minecraft derives offline UUIDs from MD5
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/haveachin/infrared/pkg/infrared/protocol"
+	"github.com/haveachin/infrared/pkg/infrared/protocol/handshaking"
+)
+
+var (
+	ErrConflictingForwarding = errors.New("only one of sendRealIP, bungeeCordForwarding and velocityForwarding can be set")
+	ErrNoVelocitySecret      = errors.New("no velocity forwarding secret")
+)
+
+const (
+	bungeeGuardTokenProperty = "bungeeguard-token"
+
+	// velocityPlayerInfoChannel is the channel of the login plugin request
+	// that servers with Velocity modern forwarding send to the proxy.
+	velocityPlayerInfoChannel = "velocity:player_info"
+	// velocityForwardingVersion is the version of the player info that is forwarded.
+	// Version 1 contains the address, UUID, name and properties of the player.
+	velocityForwardingVersion = 1
+)
+
+type BungeeCordForwardingConfig struct {
+	// BungeeGuardToken is sent as a property of the player for servers
+	// that use the BungeeGuard plugin if set
+	BungeeGuardToken string `yaml:"bungeeGuardToken"`
+}
+
+type VelocityForwardingConfig struct {
+	// Secret is the forwarding secret that is configured on the servers
+	Secret string `yaml:"secret"`
+	// SecretFile is the path to a file that contains the forwarding secret.
+	// It is used if Secret is empty.
+	SecretFile string `yaml:"secretFile"`
+}
+
+// ProfileProperty is a property of a player profile like the skin or a BungeeGuard token.
+type ProfileProperty struct {
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// offlinePlayerUUID returns the UUID that servers in offline mode assign to a player.
+func offlinePlayerUUID(name string) uuid.UUID {
+	//nolint:gosec // Minecraft derives offline UUIDs from MD5
+	id := uuid.UUID(md5.Sum([]byte("OfflinePlayer:" + name)))
+	// Version 3 and the IETF variant like UUID.nameUUIDFromBytes in Java
+	id[6] = id[6]&0x0f | 0x30
+	id[8] = id[8]&0x3f | 0x80
+	return id
+}
+
+// playerUUID returns the UUID that the client sent or the offline UUID if it sent none.
+func playerUUID(req ServerRequest) uuid.UUID {
+	if req.PlayerUUID != uuid.Nil {
+		return req.PlayerUUID
+	}
+	return offlinePlayerUUID(req.PlayerName)
+}
+
+// forward replaces the server address of the handshake with
+// "host\x00clientIP\x00UUID[\x00properties]" like BungeeCord does with IP forwarding.
+// Like BungeeCord, Forge data is not forwarded.
+func (cfg BungeeCordForwardingConfig) forward(hs *handshaking.ServerBoundHandshake, req ServerRequest) error {
+	clientIP := req.ClientAddr.String()
+	if host, _, err := net.SplitHostPort(clientIP); err == nil {
+		clientIP = host
+	}
+
+	id := playerUUID(req)
+
+	var addr strings.Builder
+	addr.WriteString(hs.ParseServerAddress())
+	addr.WriteString(handshaking.SeparatorForge)
+	addr.WriteString(clientIP)
+	addr.WriteString(handshaking.SeparatorForge)
+	addr.WriteString(hex.EncodeToString(id[:]))
+
+	properties := req.PlayerProperties
+	if cfg.BungeeGuardToken != "" {
+		properties = append(slices.Clip(properties), ProfileProperty{
+			Name:  bungeeGuardTokenProperty,
+			Value: cfg.BungeeGuardToken,
+		})
+	}
+
+	if len(properties) > 0 {
+		bb, err := json.Marshal(properties)
+		if err != nil {
+			return err
+		}
+		addr.WriteString(handshaking.SeparatorForge)
+		addr.Write(bb)
+	}
+
+	hs.ServerAddress = protocol.String(addr.String())
+	return nil
+}
+
+// velocityForwarder signs the player info that answers the
+// login plugin request of servers with Velocity modern forwarding.
+type velocityForwarder struct {
+	secret []byte
+}
+
+func newVelocityForwarder(cfg VelocityForwardingConfig) (*velocityForwarder, error) {
+	secret := cfg.Secret
+	if secret == "" && cfg.SecretFile != "" {
+		bb, err := os.ReadFile(cfg.SecretFile)
+		if err != nil {
+			return nil, err
+		}
+		secret = strings.TrimSpace(string(bb))
+	}
+
+	if secret == "" {
+		return nil, ErrNoVelocitySecret
+	}
+
+	return &velocityForwarder{
+		secret: []byte(secret),
+	}, nil
+}
+
+// playerInfo returns the HMAC-SHA256 signature followed by the
+// version, address, UUID, name and properties of the player.
+func (f *velocityForwarder) playerInfo(req ServerRequest) ([]byte, error) {
+	clientIP := req.ClientAddr.String()
+	if host, _, err := net.SplitHostPort(clientIP); err == nil {
+		clientIP = host
+	}
+
+	fields := []protocol.FieldEncoder{
+		protocol.VarInt(velocityForwardingVersion),
+		protocol.String(clientIP),
+		protocol.UUID(playerUUID(req)),
+		protocol.String(req.PlayerName),
+		protocol.VarInt(len(req.PlayerProperties)),
+	}
+	for _, p := range req.PlayerProperties {
+		hasSignature := p.Signature != ""
+		fields = append(fields,
+			protocol.String(p.Name),
+			protocol.String(p.Value),
+			protocol.Boolean(hasSignature),
+		)
+		if hasSignature {
+			fields = append(fields, protocol.String(p.Signature))
+		}
+	}
+
+	var data bytes.Buffer
+	for _, field := range fields {
+		if _, err := field.WriteTo(&data); err != nil {
+			return nil, err
+		}
+	}
+
+	mac := hmac.New(sha256.New, f.secret)
+	_, _ = mac.Write(data.Bytes())
+
+	return append(mac.Sum(nil), data.Bytes()...), nil
+}
@@ -0,0 +1,239 @@
+package infrared
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/rs/zerolog"
+)
+
+var (
+	ErrGeoIPDenied       = errors.New("location denied")
+	ErrNoGeoIPDatabase   = errors.New("no geoip database")
+	ErrNoCountryDatabase = errors.New("country rules require a country database")
+	ErrNoASNDatabase     = errors.New("ASN rules require an ASN database")
+)
+
+const defaultGeoIPReloadInterval = time.Minute
+
+type GeoIPFilterConfig struct {
+	// CountryDatabase is the path to a MaxMind DB with countries like GeoLite2-Country.mmdb
+	CountryDatabase string `yaml:"countryDatabase"`
+	// ASNDatabase is the path to a MaxMind DB with autonomous systems like GeoLite2-ASN.mmdb
+	ASNDatabase string `yaml:"asnDatabase"`
+	// AllowCountries only lets clients from these ISO country codes through if it is not empty
+	AllowCountries []string `yaml:"allowCountries"`
+	// DenyCountries drops clients from these ISO country codes
+	DenyCountries []string `yaml:"denyCountries"`
+	// AllowASNs only lets clients from these autonomous systems through if it is not empty
+	AllowASNs []uint `yaml:"allowASNs"`
+	// DenyASNs drops clients from these autonomous systems
+	DenyASNs []uint `yaml:"denyASNs"`
+	// ReloadInterval is the interval in which the databases are checked for changes.
+	// Defaults to 1m.
+	ReloadInterval time.Duration `yaml:"reloadInterval"`
+}
+
+// geoIPRecord holds the fields of the country and ASN databases that are used.
+type geoIPRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	AutonomousSystemNumber uint `maxminddb:"autonomous_system_number"`
+}
+
+// geoLocation is where a client connects from.
+// Fields that are unknown are zero.
+type geoLocation struct {
+	country string
+	asn     uint
+}
+
+func (l geoLocation) logFields(ctx zerolog.Context) zerolog.Context {
+	if l.country != "" {
+		ctx = ctx.Str("country", l.country)
+	}
+	if l.asn != 0 {
+		ctx = ctx.Uint("asn", l.asn)
+	}
+	return ctx
+}
+
+// geoIPDatabase is a MaxMind DB that is reloaded when its file changes.
+type geoIPDatabase struct {
+	path   string
+	reader atomic.Pointer[maxminddb.Reader]
+	file   *fileReloader
+}
+
+func newGeoIPDatabase(path string, reloadInterval time.Duration, logger *zerolog.Logger) (*geoIPDatabase, error) {
+	db := &geoIPDatabase{
+		path: path,
+	}
+
+	r, err := newFileReloader(path, "GeoIP database", reloadInterval, db.load, logger)
+	if err != nil {
+		return nil, err
+	}
+	db.file = r
+
+	return db, nil
+}
+
+// load reads the whole database into memory instead of mapping it,
+// so that lookups are safe while the file is replaced.
+func (db *geoIPDatabase) load() error {
+	bb, err := os.ReadFile(db.path)
+	if err != nil {
+		return err
+	}
+
+	reader, err := maxminddb.FromBytes(bb)
+	if err != nil {
+		return fmt.Errorf("failed to open %q: %w", db.path, err)
+	}
+
+	db.reader.Store(reader)
+	return nil
+}
+
+// lookup decodes the record of ip into rec. The fields of rec stay unchanged if ip is not found.
+func (db *geoIPDatabase) lookup(ip net.IP, rec *geoIPRecord) error {
+	db.file.reloadIfDue()
+	return db.reader.Load().Lookup(ip, rec)
+}
+
+// geoIPFilter drops connections by the country and the autonomous system of the client.
+type geoIPFilter struct {
+	// databases that are nil are not configured
+	countries *geoIPDatabase
+	asns      *geoIPDatabase
+
+	allowCountries []string
+	denyCountries  []string
+	allowASNs      []uint
+	denyASNs       []uint
+}
+
+// GeoIPFilter returns a Filterer that drops connections by the country and the
+// autonomous system of the client, which it looks up in local MaxMind DBs.
+// Clients that are not in the databases are only dropped if there are allow rules.
+func GeoIPFilter(cfg GeoIPFilterConfig) (Filterer, error) {
+	return newGeoIPFilter(cfg, nil)
+}
+
+// newGeoIPFilter is like GeoIPFilter, but logs failed reloads of the databases to logger if it is not nil.
+func newGeoIPFilter(cfg GeoIPFilterConfig, logger *zerolog.Logger) (Filterer, error) {
+	if cfg.CountryDatabase == "" && cfg.ASNDatabase == "" {
+		return nil, ErrNoGeoIPDatabase
+	}
+
+	if cfg.CountryDatabase == "" && (len(cfg.AllowCountries) > 0 || len(cfg.DenyCountries) > 0) {
+		return nil, ErrNoCountryDatabase
+	}
+
+	if cfg.ASNDatabase == "" && (len(cfg.AllowASNs) > 0 || len(cfg.DenyASNs) > 0) {
+		return nil, ErrNoASNDatabase
+	}
+
+	reloadInterval := cfg.ReloadInterval
+	if reloadInterval <= 0 {
+		reloadInterval = defaultGeoIPReloadInterval
+	}
+
+	f := &geoIPFilter{
+		allowCountries: upperAll(cfg.AllowCountries),
+		denyCountries:  upperAll(cfg.DenyCountries),
+		allowASNs:      cfg.AllowASNs,
+		denyASNs:       cfg.DenyASNs,
+	}
+
+	for _, db := range []struct {
+		path string
+		db   **geoIPDatabase
+	}{
+		{cfg.CountryDatabase, &f.countries},
+		{cfg.ASNDatabase, &f.asns},
+	} {
+		if db.path == "" {
+			continue
+		}
+
+		d, err := newGeoIPDatabase(db.path, reloadInterval, logger)
+		if err != nil {
+			return nil, err
+		}
+		*db.db = d
+	}
+
+	return FilterFunc(f.filter), nil
+}
+
+func upperAll(ss []string) []string {
+	upper := make([]string, len(ss))
+	for i, s := range ss {
+		upper[i] = strings.ToUpper(s)
+	}
+	return upper
+}
+
+// locate looks up the country and autonomous system of ip.
+func (f *geoIPFilter) locate(ip net.IP) (geoLocation, error) {
+	var rec geoIPRecord
+	for _, db := range []*geoIPDatabase{f.countries, f.asns} {
+		if db == nil {
+			continue
+		}
+
+		if err := db.lookup(ip, &rec); err != nil {
+			return geoLocation{}, err
+		}
+	}
+
+	return geoLocation{
+		country: rec.Country.ISOCode,
+		asn:     rec.AutonomousSystemNumber,
+	}, nil
+}
+
+func (f *geoIPFilter) filter(c net.Conn) error {
+	addr := c.RemoteAddr().String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("%w: invalid IP address %q", ErrGeoIPDenied, host)
+	}
+
+	loc, err := f.locate(ip)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrGeoIPDenied, err)
+	}
+
+	// The location is added to the logs of the connection
+	if cc, ok := c.(*clientConn); ok {
+		cc.geo = loc
+	}
+
+	if slices.Contains(f.denyCountries, loc.country) ||
+		len(f.allowCountries) > 0 && !slices.Contains(f.allowCountries, loc.country) {
+		return fmt.Errorf("%w: country %q", ErrGeoIPDenied, loc.country)
+	}
+
+	if slices.Contains(f.denyASNs, loc.asn) ||
+		len(f.allowASNs) > 0 && !slices.Contains(f.allowASNs, loc.asn) {
+		return fmt.Errorf("%w: AS%d", ErrGeoIPDenied, loc.asn)
+	}
+
+	return nil
+}
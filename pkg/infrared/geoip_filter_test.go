@@ -0,0 +1,367 @@
+package infrared_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	ir "github.com/haveachin/infrared/pkg/infrared"
+)
+
+type mmdbNode struct {
+	children [2]*mmdbNode
+	// data is the offset of the record in the data section or -1 if the node has none
+	data  int
+	index int
+}
+
+// encodeMMDBData encodes v in the data section format of MaxMind DBs.
+// Only strings, uints and maps are supported.
+func encodeMMDBData(buf *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case string:
+		buf.WriteByte(2<<5 | byte(len(v)))
+		buf.WriteString(v)
+	case uint:
+		buf.WriteByte(6<<5 | 4)
+		_ = binary.Write(buf, binary.BigEndian, uint32(v))
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		buf.WriteByte(7<<5 | byte(len(v)))
+		for _, k := range keys {
+			encodeMMDBData(buf, k)
+			encodeMMDBData(buf, v[k])
+		}
+	}
+}
+
+// writeMMDB writes an IPv4 MaxMind DB with the records by CIDR and returns its path.
+// The CIDRs must not overlap.
+func writeMMDB(t *testing.T, name string, records map[string]map[string]any) string {
+	t.Helper()
+
+	root := &mmdbNode{data: -1}
+	nodes := []*mmdbNode{root}
+	var data bytes.Buffer
+
+	cidrs := make([]string, 0, len(records))
+	for cidr := range records {
+		cidrs = append(cidrs, cidr)
+	}
+	sort.Strings(cidrs)
+
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ip := ipNet.IP.To4()
+		bits, _ := ipNet.Mask.Size()
+
+		n := root
+		for i := 0; i < bits; i++ {
+			b := ip[i/8] >> (7 - i%8) & 1
+			if n.children[b] == nil {
+				n.children[b] = &mmdbNode{data: -1}
+				if i < bits-1 {
+					n.children[b].index = len(nodes)
+					nodes = append(nodes, n.children[b])
+				}
+			}
+			n = n.children[b]
+		}
+
+		n.data = data.Len()
+		encodeMMDBData(&data, records[cidr])
+	}
+
+	nodeCount := len(nodes)
+	var db bytes.Buffer
+	for _, n := range nodes {
+		for _, child := range n.children {
+			record := nodeCount
+			switch {
+			case child == nil:
+			case child.data >= 0:
+				record = nodeCount + 16 + child.data
+			default:
+				record = child.index
+			}
+			db.Write([]byte{byte(record >> 16), byte(record >> 8), byte(record)})
+		}
+	}
+
+	db.Write(make([]byte, 16))
+	db.Write(data.Bytes())
+	db.WriteString("\xab\xcd\xefMaxMind.com")
+	encodeMMDBData(&db, map[string]any{
+		"binary_format_major_version": uint(2),
+		"binary_format_minor_version": uint(0),
+		"database_type":               "Test",
+		"ip_version":                  uint(4),
+		"node_count":                  uint(nodeCount),
+		"record_size":                 uint(24),
+	})
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, db.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func country(isoCode string) map[string]any {
+	return map[string]any{
+		"country": map[string]any{
+			"iso_code": isoCode,
+		},
+	}
+}
+
+func asn(number uint) map[string]any {
+	return map[string]any{
+		"autonomous_system_number": number,
+	}
+}
+
+func TestGeoIPFilter(t *testing.T) {
+	countryDB := writeMMDB(t, "country.mmdb", map[string]map[string]any{
+		"10.0.0.0/8":     country("DE"),
+		"192.168.0.0/16": country("US"),
+	})
+	asnDB := writeMMDB(t, "asn.mmdb", map[string]map[string]any{
+		"10.0.0.0/16":    asn(64496),
+		"192.168.1.0/24": asn(64511),
+	})
+
+	tt := []struct {
+		name    string
+		cfg     ir.GeoIPFilterConfig
+		ip      string
+		wantErr bool
+	}{
+		{
+			name: "no_rules",
+			cfg: ir.GeoIPFilterConfig{
+				CountryDatabase: countryDB,
+			},
+			ip: "10.0.0.1",
+		},
+		{
+			name: "denied_country",
+			cfg: ir.GeoIPFilterConfig{
+				CountryDatabase: countryDB,
+				DenyCountries:   []string{"de"},
+			},
+			ip:      "10.0.0.1",
+			wantErr: true,
+		},
+		{
+			name: "not_denied_country",
+			cfg: ir.GeoIPFilterConfig{
+				CountryDatabase: countryDB,
+				DenyCountries:   []string{"DE"},
+			},
+			ip: "192.168.0.1",
+		},
+		{
+			name: "allowed_country",
+			cfg: ir.GeoIPFilterConfig{
+				CountryDatabase: countryDB,
+				AllowCountries:  []string{"US"},
+			},
+			ip: "192.168.0.1",
+		},
+		{
+			name: "not_allowed_country",
+			cfg: ir.GeoIPFilterConfig{
+				CountryDatabase: countryDB,
+				AllowCountries:  []string{"US"},
+			},
+			ip:      "10.0.0.1",
+			wantErr: true,
+		},
+		{
+			name: "unknown_country_not_allowed",
+			cfg: ir.GeoIPFilterConfig{
+				CountryDatabase: countryDB,
+				AllowCountries:  []string{"US"},
+			},
+			ip:      "172.16.0.1",
+			wantErr: true,
+		},
+		{
+			name: "unknown_country_not_denied",
+			cfg: ir.GeoIPFilterConfig{
+				CountryDatabase: countryDB,
+				DenyCountries:   []string{"DE"},
+			},
+			ip: "172.16.0.1",
+		},
+		{
+			name: "denied_asn",
+			cfg: ir.GeoIPFilterConfig{
+				ASNDatabase: asnDB,
+				DenyASNs:    []uint{64511},
+			},
+			ip:      "192.168.1.1",
+			wantErr: true,
+		},
+		{
+			name: "not_allowed_asn",
+			cfg: ir.GeoIPFilterConfig{
+				ASNDatabase: asnDB,
+				AllowASNs:   []uint{64496},
+			},
+			ip:      "192.168.1.1",
+			wantErr: true,
+		},
+		{
+			name: "allowed_country_denied_asn",
+			cfg: ir.GeoIPFilterConfig{
+				CountryDatabase: countryDB,
+				ASNDatabase:     asnDB,
+				AllowCountries:  []string{"DE"},
+				DenyASNs:        []uint{64496},
+			},
+			ip:      "10.0.1.1",
+			wantErr: true,
+		},
+		{
+			name: "allowed_country_and_asn",
+			cfg: ir.GeoIPFilterConfig{
+				CountryDatabase: countryDB,
+				ASNDatabase:     asnDB,
+				AllowCountries:  []string{"DE"},
+				AllowASNs:       []uint{64496},
+			},
+			ip: "10.0.1.1",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := ir.GeoIPFilter(tc.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = filterIP(t, f, tc.ip)
+			if tc.wantErr && !errors.Is(err, ir.ErrGeoIPDenied) {
+				t.Fatalf("got: %v; want: %v", err, ir.ErrGeoIPDenied)
+			} else if !tc.wantErr && err != nil {
+				t.Fatalf("got: %v; want: nil", err)
+			}
+		})
+	}
+}
+
+func TestGeoIPFilter_InvalidConfig(t *testing.T) {
+	invalidDB := writeTempFile(t, "invalid.mmdb", "not a database")
+	countryDB := writeMMDB(t, "country.mmdb", map[string]map[string]any{
+		"10.0.0.0/8": country("DE"),
+	})
+
+	tt := []struct {
+		name    string
+		cfg     ir.GeoIPFilterConfig
+		wantErr error
+	}{
+		{
+			name:    "no_database",
+			wantErr: ir.ErrNoGeoIPDatabase,
+		},
+		{
+			name: "asn_rules_without_asn_database",
+			cfg: ir.GeoIPFilterConfig{
+				CountryDatabase: countryDB,
+				DenyASNs:        []uint{64496},
+			},
+			wantErr: ir.ErrNoASNDatabase,
+		},
+		{
+			name: "country_rules_without_country_database",
+			cfg: ir.GeoIPFilterConfig{
+				ASNDatabase:   countryDB,
+				DenyCountries: []string{"DE"},
+			},
+			wantErr: ir.ErrNoCountryDatabase,
+		},
+		{
+			name: "missing_database",
+			cfg: ir.GeoIPFilterConfig{
+				CountryDatabase: "does-not-exist.mmdb",
+			},
+		},
+		{
+			name: "invalid_database",
+			cfg: ir.GeoIPFilterConfig{
+				CountryDatabase: invalidDB,
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ir.GeoIPFilter(tc.cfg)
+			if err == nil {
+				t.Fatal("got: nil; want: error")
+			}
+
+			if tc.wantErr != nil && !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got: %v; want: %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestGeoIPFilter_Reload(t *testing.T) {
+	countryDB := writeMMDB(t, "country.mmdb", map[string]map[string]any{
+		"10.0.0.0/8": country("US"),
+	})
+
+	f, err := ir.GeoIPFilter(ir.GeoIPFilterConfig{
+		CountryDatabase: countryDB,
+		DenyCountries:   []string{"DE"},
+		ReloadInterval:  time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := filterIP(t, f, "10.0.0.1"); err != nil {
+		t.Fatalf("got: %v; want: nil", err)
+	}
+
+	newDB := writeMMDB(t, "country.mmdb", map[string]map[string]any{
+		"10.0.0.0/8": country("DE"),
+	})
+	if err := os.Rename(newDB, countryDB); err != nil {
+		t.Fatal(err)
+	}
+	// The modification time might not change on file systems with a coarse resolution
+	modTime := time.Now().Add(time.Second)
+	if err := os.Chtimes(countryDB, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for filterIP(t, f, "10.0.0.1") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("database was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
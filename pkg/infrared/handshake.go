@@ -0,0 +1,42 @@
+package infrared
+
+import (
+	"github.com/haveachin/infrared/pkg/infrared/protocol"
+	"github.com/haveachin/infrared/pkg/infrared/protocol/handshaking"
+)
+
+// HandshakeConfig rewrites the handshake of the client before it is forwarded to the server.
+type HandshakeConfig struct {
+	// ServerAddress replaces the server address that the client connected to if set.
+	// Forge markers of the client are kept.
+	ServerAddress string `yaml:"serverAddress"`
+	// ServerPort replaces the server port that the client connected to if set
+	ServerPort uint16 `yaml:"serverPort"`
+	// StripForgeMarker removes the marker that Forge clients append to the server address
+	StripForgeMarker bool `yaml:"stripForgeMarker"`
+	// ForgeMarker is appended to the server address if the client sent no Forge marker, like "FML2"
+	ForgeMarker string `yaml:"forgeMarker"`
+}
+
+func (cfg HandshakeConfig) isZero() bool {
+	return cfg == HandshakeConfig{}
+}
+
+// rewrite applies the changes of cfg to the handshake.
+func (cfg HandshakeConfig) rewrite(hs *handshaking.ServerBoundHandshake) {
+	if cfg.StripForgeMarker {
+		hs.SetForgeMarker("")
+	}
+
+	if cfg.ForgeMarker != "" && !hs.IsForgeAddress() {
+		hs.SetForgeMarker(cfg.ForgeMarker)
+	}
+
+	if cfg.ServerAddress != "" {
+		hs.SetServerAddress(cfg.ServerAddress)
+	}
+
+	if cfg.ServerPort != 0 {
+		hs.ServerPort = protocol.UnsignedShort(cfg.ServerPort)
+	}
+}
@@ -0,0 +1,190 @@
+package infrared
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/haveachin/infrared/pkg/infrared/protocol"
+	"github.com/haveachin/infrared/pkg/infrared/protocol/handshaking"
+	"github.com/haveachin/infrared/pkg/infrared/protocol/status"
+	"github.com/rs/zerolog"
+)
+
+var (
+	ErrNoHealthyAddresses = errors.New("no healthy addresses")
+)
+
+type HealthCheckConfig struct {
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+	// UnhealthyThreshold is the amount of consecutive failed checks
+	// until an address is removed from rotation.
+	UnhealthyThreshold int `yaml:"unhealthyThreshold"`
+	// HealthyThreshold is the amount of consecutive successful checks
+	// until an unhealthy address is put back into rotation.
+	HealthyThreshold int `yaml:"healthyThreshold"`
+}
+
+func WithServerHealthCheck(c HealthCheckConfig) ServerConfigFunc {
+	return func(cfg *ServerConfig) {
+		cfg.HealthCheck = &c
+	}
+}
+
+func (cfg HealthCheckConfig) withDefaults() HealthCheckConfig {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 3 * time.Second
+	}
+
+	if cfg.UnhealthyThreshold <= 0 {
+		cfg.UnhealthyThreshold = 3
+	}
+
+	if cfg.HealthyThreshold <= 0 {
+		cfg.HealthyThreshold = 2
+	}
+
+	return cfg
+}
+
+type healthChecker struct {
+	cfg    HealthCheckConfig
+	server *Server
+	logger zerolog.Logger
+}
+
+// Run checks all addresses of the server periodically until ctx is done.
+func (hc healthChecker) Run(ctx context.Context) {
+	ticker := time.NewTicker(hc.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		for _, b := range hc.server.backends {
+			// Checks that take longer than the interval are not started again,
+			// so that dials do not pile up and results do not arrive out of order
+			if !b.checking.CompareAndSwap(false, true) {
+				continue
+			}
+
+			go func(b *backend) {
+				defer b.checking.Store(false)
+				hc.checkBackend(ctx, b)
+			}(b)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (hc healthChecker) checkBackend(ctx context.Context, b *backend) {
+	err := hc.ping(b.addr)
+	if ctx.Err() != nil {
+		// The backend might be checked by the health checker of a reloaded server by now
+		return
+	}
+
+	b.healthMu.Lock()
+	defer b.healthMu.Unlock()
+
+	if err != nil {
+		b.successes = 0
+		b.failures++
+		if b.healthy.Load() && b.failures >= hc.cfg.UnhealthyThreshold {
+			b.healthy.Store(false)
+			hc.logger.Warn().
+				Err(err).
+				Str("address", string(b.addr)).
+				Msg("Server address is unhealthy; Removed from rotation")
+		}
+		return
+	}
+
+	b.failures = 0
+	b.successes++
+	if !b.healthy.Load() && b.successes >= hc.cfg.HealthyThreshold {
+		b.healthy.Store(true)
+		hc.logger.Info().
+			Str("address", string(b.addr)).
+			Msg("Server address is healthy again; Restored to rotation")
+	}
+}
+
+// ping performs a status handshake with the server address.
+func (hc healthChecker) ping(addr ServerAddress) error {
+	c, err := net.DialTimeout("tcp", string(addr), hc.cfg.Timeout)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := c.SetDeadline(time.Now().Add(hc.cfg.Timeout)); err != nil {
+		return err
+	}
+
+	if hc.server.cfg.SendProxyProtocol {
+		if err := writeProxyProtocolHeader(c.LocalAddr(), c); err != nil {
+			return err
+		}
+	}
+
+	rc := NewServerConn(c)
+	hsPk, err := hc.handshakePacket(addr)
+	if err != nil {
+		return err
+	}
+
+	var reqPk protocol.Packet
+	if err := (status.ServerBoundRequest{}).Marshal(&reqPk); err != nil {
+		return err
+	}
+
+	if err := rc.WritePackets(hsPk, reqPk); err != nil {
+		return err
+	}
+
+	var pk protocol.Packet
+	if err := rc.ReadPacket(&pk); err != nil {
+		return err
+	}
+
+	var respPk status.ClientBoundResponse
+	if err := respPk.Unmarshal(pk); err != nil {
+		return err
+	}
+
+	var respJSON status.ResponseJSON
+	return json.Unmarshal([]byte(respPk.JSONResponse), &respJSON)
+}
+
+func (hc healthChecker) handshakePacket(addr ServerAddress) (protocol.Packet, error) {
+	host, portStr, err := net.SplitHostPort(string(addr))
+	if err != nil {
+		return protocol.Packet{}, err
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return protocol.Packet{}, err
+	}
+
+	var pk protocol.Packet
+	err = handshaking.ServerBoundHandshake{
+		ProtocolVersion: protocol.VarInt(protocol.Version1_20_2),
+		ServerAddress:   protocol.String(host),
+		ServerPort:      protocol.UnsignedShort(port),
+		NextState:       handshaking.StateStatusServerBoundHandshake,
+	}.Marshal(&pk)
+	return pk, err
+}
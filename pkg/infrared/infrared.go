@@ -1,23 +1,61 @@
 package infrared
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net"
+	"reflect"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/haveachin/infrared/pkg/infrared/protocol"
+	"github.com/haveachin/infrared/pkg/infrared/protocol/login"
 	"github.com/rs/zerolog"
 )
 
+var (
+	ErrShutdown = errors.New("infrared is shut down")
+	// ErrListenersNotBound is returned by Reload if the new config is in use,
+	// but some of its listeners could not be bound
+	ErrListenersNotBound = errors.New("listeners not bound")
+)
+
+const (
+	shutdownPollInterval = 100 * time.Millisecond
+	// limitCounterErrorLogInterval is the minimum time between two logs of request counter errors
+	limitCounterErrorLogInterval = time.Minute
+)
+
 type Config struct {
-	BindAddr            string              `yaml:"bind"`
-	KeepAliveTimeout    time.Duration       `yaml:"keepAliveTimeout"`
-	ServerConfigs       []ServerConfig      `yaml:"servers"`
-	FiltersConfig       FiltersConfig       `yaml:"filters"`
-	ProxyProtocolConfig ProxyProtocolConfig `yaml:"proxyProtocol"`
+	BindAddr string `yaml:"bind"`
+	// Listeners replace the bind address, the PROXY protocol, the RealIP and the online mode config if set
+	Listeners           []ListenerConfig         `yaml:"listeners"`
+	KeepAliveTimeout    time.Duration            `yaml:"keepAliveTimeout"`
+	ServerConfigs       []ServerConfig           `yaml:"servers"`
+	FiltersConfig       FiltersConfig            `yaml:"filters"`
+	ProxyProtocolConfig ProxyProtocolConfig      `yaml:"proxyProtocol"`
+	DisconnectMessages  DisconnectMessagesConfig `yaml:"disconnectMessages"`
+	// ReceiveRealIP verifies RealIP handshakes of an upstream proxy if set
+	ReceiveRealIP *ReceiveRealIPConfig `yaml:"receiveRealIP"`
+	// OnlineMode authenticates players at the session server before they are forwarded if set
+	OnlineMode *OnlineModeConfig `yaml:"onlineMode"`
+	// PlayerLists are checked for the logins of all servers
+	PlayerLists PlayerListsConfig `yaml:"playerLists"`
+	// RequestFilterers filter the requests for all servers
+	// before the player is authenticated in online mode
+	RequestFilterers []RequestFilterer `yaml:"-"`
+	// ServerNotFoundStatus is sent as the status response if no server matches the domain
+	ServerNotFoundStatus *StatusResponseConfig `yaml:"serverNotFoundStatus"`
+	// Metrics enables the Prometheus metrics endpoint if set
+	Metrics *MetricsConfig `yaml:"metrics"`
+	// API enables the admin API if set
+	API *APIConfig `yaml:"api"`
 }
 
 func NewConfig() Config {
@@ -41,6 +79,11 @@ func (cfg Config) WithBindAddr(bindAddr string) Config {
 	return cfg
 }
 
+func (cfg Config) AddListener(c ListenerConfig) Config {
+	cfg.Listeners = append(cfg.Listeners, c)
+	return cfg
+}
+
 func (cfg Config) AddServerConfig(fns ...ServerConfigFunc) Config {
 	var sCfg ServerConfig
 	for _, fn := range fns {
@@ -65,6 +108,48 @@ func (cfg Config) WithProxyProtocolTrustedCIDRs(trustedCIDRs ...string) Config {
 	return cfg
 }
 
+func (cfg Config) WithReceiveRealIP(c ReceiveRealIPConfig) Config {
+	cfg.ReceiveRealIP = &c
+	return cfg
+}
+
+func (cfg Config) WithOnlineMode(c OnlineModeConfig) Config {
+	cfg.OnlineMode = &c
+	return cfg
+}
+
+func (cfg Config) WithPlayerLists(c PlayerListsConfig) Config {
+	cfg.PlayerLists = c
+	return cfg
+}
+
+func (cfg Config) WithRequestFilterers(filterers ...RequestFilterer) Config {
+	cfg.RequestFilterers = append(slices.Clip(cfg.RequestFilterers), filterers...)
+	return cfg
+}
+
+func (cfg Config) WithDisconnectMessages(msgs DisconnectMessagesConfig) Config {
+	cfg.DisconnectMessages = msgs
+	return cfg
+}
+
+func (cfg Config) WithServerNotFoundStatus(c StatusResponseConfig) Config {
+	cfg.ServerNotFoundStatus = &c
+	return cfg
+}
+
+func (cfg Config) WithMetricsBind(bind string) Config {
+	cfg.Metrics = &MetricsConfig{
+		Bind: bind,
+	}
+	return cfg
+}
+
+func (cfg Config) WithAPI(c APIConfig) Config {
+	cfg.API = &c
+	return cfg
+}
+
 func (cfg Config) WithRateLimiterWindowLength(windowLength time.Duration) Config {
 	cfg.FiltersConfig.RateLimiter.WindowLength = windowLength
 	return cfg
@@ -98,13 +183,33 @@ type Infrared struct {
 	NewListenerFunc        NewListenerFunc
 	NewServerRequesterFunc NewServerRequesterFunc
 
-	cfg Config
-
-	l       net.Listener
-	filter  Filter
+	// reloadMu serializes reloads
+	reloadMu sync.Mutex
+	// mu guards everything that can be replaced by a reload
+	mu        sync.RWMutex
+	cfg       Config
+	listeners []*listener
+	servers   []*Server
+	// notFoundStatus is nil if no status is configured for unknown domains
+	notFoundStatus *statusResponse
+	// playerLists is nil if no global player lists are configured
+	playerLists *playerLists
+	// stopServers stops all background tasks of the servers like health checks
+	stopServers context.CancelFunc
+	// stopMetricsServer stops the HTTP server of the metrics endpoint
+	stopMetricsServer func()
+	// stopAPIServer stops the HTTP server of the admin API
+	stopAPIServer func()
+	isShutdown    bool
+
+	// serving counts the running accept loops
+	serving sync.WaitGroup
+	metrics *metrics
 	bufPool sync.Pool
-	conns   map[net.Addr]*clientConn
-	sr      ServerRequester
+	conns   connRegistry
+	// nextLimitCounterErrorLog is the Unix time in nanoseconds
+	// at which request counter errors are logged again
+	nextLimitCounterErrorLog atomic.Int64
 }
 
 func New() *Infrared {
@@ -118,114 +223,414 @@ func NewWithConfigProvider(prv ConfigProvider) *Infrared {
 
 func NewWithConfig(cfg Config) *Infrared {
 	return &Infrared{
-		cfg: cfg,
+		cfg:     cfg,
+		metrics: newMetrics(),
 		bufPool: sync.Pool{
 			New: func() any {
 				b := make([]byte, 1<<15)
 				return &b
 			},
 		},
-		conns: make(map[net.Addr]*clientConn),
 	}
 }
 
-func (ir *Infrared) initListener() error {
-	ir.Logger.Info().
-		Str("bind", ir.cfg.BindAddr).
-		Msg("Starting listener")
+// newServers creates the servers of cfg. The servers of oldCfg that did not change
+// and do not load files are reused, so that their status caches are kept.
+// All other servers keep the backends of the old server with the same name and address.
+func (ir *Infrared) newServers(cfg, oldCfg Config, oldSrvs []*Server) ([]*Server, error) {
+	oldIndexByName := make(map[string]int, len(oldSrvs))
+	for i := range oldSrvs {
+		name := oldCfg.ServerConfigs[i].name()
+		if _, ok := oldIndexByName[name]; ok {
+			// Servers with the same name cannot be told apart
+			oldIndexByName[name] = -1
+			continue
+		}
+		oldIndexByName[name] = i
+	}
 
-	if ir.NewListenerFunc == nil {
-		ir.NewListenerFunc = func(addr string) (net.Listener, error) {
-			return net.Listen("tcp", addr)
+	srvs := make([]*Server, 0)
+	for _, sCfg := range cfg.ServerConfigs {
+		i, ok := oldIndexByName[sCfg.name()]
+		if ok && i >= 0 && !sCfg.referencesFiles() && reflect.DeepEqual(oldCfg.ServerConfigs[i], sCfg) {
+			srvs = append(srvs, oldSrvs[i])
+			continue
 		}
+
+		srv, err := NewServer(
+			WithServerConfig(sCfg),
+			withServerOnCounterError(ir.limitCounterFailed),
+			withServerLogger(&ir.Logger),
+		)
+		if err != nil {
+			return nil, err
+		}
+		if ok && i >= 0 {
+			srv.takeOverBackends(oldSrvs[i])
+		}
+		srv.metrics = ir.metrics
+		srvs = append(srvs, srv)
 	}
 
-	if ir.cfg.ProxyProtocolConfig.Receive {
-		fn := ir.NewListenerFunc
-		ir.NewListenerFunc = func(addr string) (net.Listener, error) {
-			l, err := fn(addr)
-			if err != nil {
-				return nil, err
-			}
+	return srvs, nil
+}
+
+// limitCounterFailed records that a rate limiter could not count requests.
+// Infrared allows these requests, so errors like an unreachable Redis server
+// are logged at most once every limitCounterErrorLogInterval.
+func (ir *Infrared) limitCounterFailed(err error) {
+	ir.metrics.limitCounterFailed()
+
+	now := time.Now()
+	next := ir.nextLimitCounterErrorLog.Load()
+	if now.UnixNano() < next {
+		return
+	}
+
+	if !ir.nextLimitCounterErrorLog.CompareAndSwap(next, now.Add(limitCounterErrorLogInterval).UnixNano()) {
+		return
+	}
+
+	ir.Logger.Error().
+		Err(err).
+		Msg("Failed to count requests of rate limiter; Allowing requests")
+}
 
-			return newProxyProtocolListener(l, ir.cfg.ProxyProtocolConfig.TrustedCIDRs)
+func (ir *Infrared) newServerRequester(srvs []*Server) (ServerRequester, error) {
+	newServerRequesterFn := ir.NewServerRequesterFunc
+	if newServerRequesterFn == nil {
+		newServerRequesterFn = func(s []*Server) (ServerRequester, error) {
+			return NewServerGateway(s, nil)
 		}
 	}
 
-	l, err := ir.NewListenerFunc(ir.cfg.BindAddr)
-	if err != nil {
-		return err
+	return newServerRequesterFn(srvs)
+}
+
+// startServers starts the background tasks of srvs and the global player lists.
+// The returned function stops all background tasks of the servers.
+func (ir *Infrared) startServers(srvs []*Server, lists *playerLists) context.CancelFunc {
+	ctx, cancel := context.WithCancel(context.Background())
+	for _, srv := range srvs {
+		go srv.RunHealthChecks(ctx, ir.Logger)
+		go srv.playerLists.watch(ctx, ir.Logger)
 	}
-	ir.l = l
+	go lists.watch(ctx, ir.Logger)
 
-	return nil
+	return cancel
 }
 
-func (ir *Infrared) initServerGateway() error {
-	srvs := make([]*Server, 0)
-	for _, sCfg := range ir.cfg.ServerConfigs {
-		srv, err := NewServer(WithServerConfig(sCfg))
+// bindListeners binds the listeners of lns. Bound listeners of old are reused
+// if their bind address and PROXY protocol config did not change.
+// Listeners whose address is still bound by a listener of old with a different
+// config are left unbound; they have to be bound once the old listener is closed.
+// It returns the listeners that were newly bound.
+func (ir *Infrared) bindListeners(lns, old []*listener) ([]*listener, error) {
+	bound := make([]*listener, 0, len(lns))
+	for _, ln := range lns {
+		i := slices.IndexFunc(old, func(o *listener) bool {
+			return o.cfg.Bind == ln.cfg.Bind
+		})
+		if i >= 0 {
+			if canReuseListener(old[i].cfg, ln.cfg) {
+				ln.l = old[i].l
+			}
+			continue
+		}
+
+		l, err := ir.listen(ln.cfg)
 		if err != nil {
-			return err
+			for _, ln := range bound {
+				_ = ln.l.Close()
+			}
+			return nil, err
 		}
-		srvs = append(srvs, srv)
+		ln.l = l
+		bound = append(bound, ln)
+	}
+
+	return bound, nil
+}
+
+func (ir *Infrared) init() error {
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+
+	if ir.isShutdown {
+		return ErrShutdown
+	}
+
+	notFoundStatus, err := newStatusResponse(ir.cfg.ServerNotFoundStatus)
+	if err != nil {
+		return err
 	}
 
-	if ir.NewServerRequesterFunc == nil {
-		ir.NewServerRequesterFunc = func(s []*Server) (ServerRequester, error) {
-			return NewServerGateway(srvs, nil)
+	srvs, err := ir.newServers(ir.cfg, Config{}, nil)
+	if err != nil {
+		return err
+	}
+
+	lists, err := newPlayerLists(ir.cfg.PlayerLists)
+	if err != nil {
+		return err
+	}
+
+	lns, err := ir.newListeners(ir.cfg, srvs)
+	if err != nil {
+		return err
+	}
+
+	if _, err := ir.bindListeners(lns, nil); err != nil {
+		return err
+	}
+
+	closeListeners := func() {
+		for _, ln := range lns {
+			_ = ln.l.Close()
 		}
 	}
 
-	sr, err := ir.NewServerRequesterFunc(srvs)
+	stopMetricsServer, err := ir.startMetricsServer(ir.cfg.Metrics)
 	if err != nil {
+		closeListeners()
 		return err
 	}
-	ir.sr = sr
+
+	stopAPIServer, err := ir.startAPIServer(ir.cfg.API)
+	if err != nil {
+		stopMetricsServer()
+		closeListeners()
+		return err
+	}
+
+	ir.listeners = lns
+	ir.notFoundStatus = notFoundStatus
+	ir.servers = srvs
+	ir.playerLists = lists
+	ir.stopServers = ir.startServers(srvs, lists)
+	ir.stopMetricsServer = stopMetricsServer
+	ir.stopAPIServer = stopAPIServer
+
+	for _, ln := range lns {
+		ir.serving.Add(1)
+		go ir.serve(ln.l)
+	}
 
 	return nil
 }
 
-func (ir *Infrared) init() error {
-	if err := ir.initListener(); err != nil {
+// Reload replaces the config of a running Infrared instance.
+// The servers, filters and listener settings are rebuilt from the new config
+// and swapped in all at once. Connections that are already established are not affected.
+// If the new config is invalid, an error is returned and the old config stays in use.
+func (ir *Infrared) Reload(cfg Config) error {
+	ir.reloadMu.Lock()
+	defer ir.reloadMu.Unlock()
+
+	ir.mu.RLock()
+	oldCfg := ir.cfg
+	oldListeners := ir.listeners
+	oldServers := ir.servers
+	isShutdown := ir.isShutdown
+	ir.mu.RUnlock()
+
+	if isShutdown {
+		return ErrShutdown
+	}
+
+	if oldListeners == nil {
+		ir.mu.Lock()
+		ir.cfg = cfg
+		ir.mu.Unlock()
+		return nil
+	}
+
+	notFoundStatus, err := newStatusResponse(cfg.ServerNotFoundStatus)
+	if err != nil {
 		return err
 	}
 
-	if err := ir.initServerGateway(); err != nil {
+	srvs, err := ir.newServers(cfg, oldCfg, oldServers)
+	if err != nil {
 		return err
 	}
 
-	ir.filter = NewFilter(WithFilterConfig(ir.cfg.FiltersConfig))
+	lists, err := newPlayerLists(cfg.PlayerLists)
+	if err != nil {
+		return err
+	}
 
-	return nil
-}
+	lns, err := ir.newListeners(cfg, srvs)
+	if err != nil {
+		return err
+	}
 
-func (ir *Infrared) ListenAndServe() error {
-	if err := ir.init(); err != nil {
+	bound, err := ir.bindListeners(lns, oldListeners)
+	if err != nil {
 		return err
 	}
 
-	for {
-		c, err := ir.l.Accept()
-		if errors.Is(err, net.ErrClosed) {
-			return nil
-		} else if err != nil {
-			ir.Logger.Debug().
-				Err(err).
-				Msg("Error accepting new connection")
+	closeBound := func() {
+		for _, ln := range bound {
+			_ = ln.l.Close()
+		}
+	}
 
+	var stopMetricsServer func()
+	if !isHTTPConfigEqual(oldCfg.Metrics, cfg.Metrics) {
+		stopMetricsServer, err = ir.startMetricsServer(cfg.Metrics)
+		if err != nil {
+			closeBound()
+			return err
+		}
+	}
+
+	var stopAPIServer func()
+	if !isAPIBindEqual(oldCfg.API, cfg.API) {
+		stopAPIServer, err = ir.startAPIServer(cfg.API)
+		if err != nil {
+			if stopMetricsServer != nil {
+				stopMetricsServer()
+			}
+			closeBound()
+			return err
+		}
+	}
+
+	stopServers := ir.startServers(srvs, lists)
+
+	ir.mu.Lock()
+	oldStopServers := ir.stopServers
+	oldStopMetricsServer := ir.stopMetricsServer
+	oldStopAPIServer := ir.stopAPIServer
+	ir.cfg = cfg
+	ir.listeners = lns
+	ir.servers = srvs
+	ir.playerLists = lists
+	ir.stopServers = stopServers
+	ir.notFoundStatus = notFoundStatus
+	if stopMetricsServer != nil {
+		ir.stopMetricsServer = stopMetricsServer
+	}
+	if stopAPIServer != nil {
+		ir.stopAPIServer = stopAPIServer
+	}
+	ir.mu.Unlock()
+
+	oldStopServers()
+	if stopMetricsServer != nil {
+		oldStopMetricsServer()
+	}
+	if stopAPIServer != nil {
+		oldStopAPIServer()
+	}
+
+	for _, ln := range bound {
+		ir.serving.Add(1)
+		go ir.serve(ln.l)
+	}
+
+	// Keeps ListenAndServe from returning while listeners are rebound
+	ir.serving.Add(1)
+	defer ir.serving.Done()
+
+	for _, old := range oldListeners {
+		isReused := slices.ContainsFunc(lns, func(ln *listener) bool {
+			return ln.l == old.l
+		})
+		if !isReused && old.l != nil {
+			// This stops the accept loop of the old listener
+			_ = old.l.Close()
+		}
+	}
+
+	var errs []error
+	for _, ln := range lns {
+		if ln.l != nil {
+			continue
+		}
+
+		l, err := ir.listen(ln.cfg)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("listener %q: %w", ln.cfg.name(), err))
 			continue
 		}
 
-		go ir.handleNewConn(c)
+		ir.mu.Lock()
+		ln.l = l
+		ir.mu.Unlock()
+
+		ir.serving.Add(1)
+		go ir.serve(l)
 	}
+
+	ir.Logger.Info().Msg("Config reloaded")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrListenersNotBound, errors.Join(errs...))
+	}
+
+	return nil
 }
 
-func (ir *Infrared) handleNewConn(c net.Conn) {
-	if err := ir.filter.Filter(c); err != nil {
-		ir.Logger.Debug().
-			Err(err).
-			Msg("Filtered connection")
+func isHTTPConfigEqual[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func (ir *Infrared) config() Config {
+	ir.mu.RLock()
+	defer ir.mu.RUnlock()
+	return ir.cfg
+}
+
+// listenerOf returns the listener that l is bound by or nil if l was removed by a reload.
+func (ir *Infrared) listenerOf(l net.Listener) *listener {
+	ir.mu.RLock()
+	defer ir.mu.RUnlock()
+
+	for _, ln := range ir.listeners {
+		if ln.l == l {
+			return ln
+		}
+	}
+	return nil
+}
+
+func (ir *Infrared) serverNotFoundStatus() *statusResponse {
+	ir.mu.RLock()
+	defer ir.mu.RUnlock()
+	return ir.notFoundStatus
+}
+
+func (ir *Infrared) globalPlayerLists() *playerLists {
+	ir.mu.RLock()
+	defer ir.mu.RUnlock()
+	return ir.playerLists
+}
+
+func (ir *Infrared) serverList() []*Server {
+	ir.mu.RLock()
+	defer ir.mu.RUnlock()
+	return ir.servers
+}
+
+func (ir *Infrared) ListenAndServe() error {
+	if err := ir.init(); err != nil {
+		return err
+	}
+
+	// Accept loops are started by init and by reloads that bind new listeners
+	ir.serving.Wait()
+	return nil
+}
+
+func (ir *Infrared) handleNewConn(l net.Listener, c net.Conn) {
+	ln := ir.listenerOf(l)
+	if ln == nil {
+		_ = c.Close()
 		return
 	}
 
@@ -235,19 +640,68 @@ func (ir *Infrared) handleNewConn(c net.Conn) {
 		cleanUp()
 	}()
 
-	if err := ir.handleConn(conn); err != nil {
-		ir.Logger.Debug().
+	if ln.realIP != nil {
+		if err := ln.realIP.receive(conn); err != nil {
+			ir.Logger.Debug().
+				Err(err).
+				Str("remoteAddr", c.RemoteAddr().String()).
+				Msg("Rejected RealIP handshake")
+
+			ir.metrics.connFiltered(err)
+			return
+		}
+	}
+
+	// The connection is filtered by the address of the player
+	// which can differ from the remote address with RealIP
+	err := ln.filter.Filter(conn)
+	logger := conn.geo.logFields(ir.Logger.With()).Logger()
+	if err != nil {
+		logger.Debug().
+			Err(err).
+			Msg("Filtered connection")
+
+		ir.metrics.connFiltered(err)
+		ir.handleFilteredConn(conn, err)
+		return
+	}
+
+	if err := ir.handleConn(ln, conn); err != nil {
+		logger.Debug().
 			Err(err).
 			Msg("Error while handling connection")
 	}
 }
 
-func (ir *Infrared) handleConn(c *clientConn) error {
-	if err := c.ReadPackets(&c.readPks[0], &c.readPks[1]); err != nil {
+// handleFilteredConn sends a disconnect message to a filtered client
+// if it tries to log in and a message is configured for the filter.
+func (ir *Infrared) handleFilteredConn(c *clientConn, err error) {
+	reason := disconnectReason(err, ir.config().DisconnectMessages)
+	if reason == nil {
+		return
+	}
+
+	if err := c.readHandshake(); err != nil {
+		return
+	}
+
+	if err := c.ReadPacket(&c.readPks[1]); err != nil {
+		return
+	}
+
+	if !c.handshake.IsLoginRequest() {
+		return
+	}
+
+	_ = c.disconnect(reason)
+}
+
+func (ir *Infrared) handleConn(ln *listener, c *clientConn) error {
+	if err := c.readHandshake(); err != nil {
 		return err
 	}
 
-	if err := c.handshake.Unmarshal(c.readPks[0]); err != nil {
+	if err := c.ReadPacket(&c.readPks[1]); err != nil {
 		return err
 	}
 
@@ -261,22 +715,83 @@ func (ir *Infrared) handleConn(c *clientConn) error {
 	}
 	c.reqDomain = ServerDomain(reqDomain)
 
-	resp, err := ir.sr.RequestServer(ServerRequest{
+	req := ServerRequest{
 		ClientAddr:      c.RemoteAddr(),
 		Domain:          c.reqDomain,
 		IsLogin:         c.handshake.IsLoginRequest(),
 		ProtocolVersion: protocol.Version(c.handshake.ProtocolVersion),
 		ReadPackets:     c.readPks,
-	})
+	}
+
+	if req.IsLogin {
+		// Connections are pooled, so fields of the previous login must not leak
+		c.loginStart = login.ServerBoundLoginStart{}
+		if err := c.loginStart.Unmarshal(c.readPks[1], req.ProtocolVersion); err != nil {
+			return err
+		}
+		req.PlayerName = string(c.loginStart.Name)
+		req.PlayerUUID = uuid.UUID(c.loginStart.PlayerUUID)
+	}
+
+	globalFilter := RequestFilter{
+		filterers: ir.config().RequestFilterers,
+	}
+	if err := globalFilter.FilterRequest(req); err != nil {
+		ir.metrics.connRejected(err)
+		return ir.handleRequestError(c, req, err)
+	}
+
+	if req.IsLogin {
+		if ln.auth != nil {
+			if err := ln.auth.authenticate(c, &req); err != nil {
+				err = fmt.Errorf("%w: %w", ErrNotAuthenticated, err)
+				ir.metrics.connRejected(err)
+				return ir.handleRequestError(c, req, err)
+			}
+		}
+
+		if err := ir.globalPlayerLists().check(req); err != nil {
+			ir.metrics.connRejected(err)
+			return ir.handleRequestError(c, req, err)
+		}
+	}
+
+	resp, err := ln.sr.RequestServer(req)
 	if err != nil {
-		return err
+		ir.metrics.connRejected(err)
+		return ir.handleRequestError(c, req, err)
 	}
+	ir.metrics.request(resp.ServerName, req.IsLogin)
 
 	if c.handshake.IsStatusRequest() {
 		return handleStatus(c, resp)
 	}
 
-	return ir.handleLogin(c, resp)
+	return ir.handleLogin(c, req, resp)
+}
+
+// handleRequestError tells the client why its request failed if possible.
+func (ir *Infrared) handleRequestError(c *clientConn, req ServerRequest, err error) error {
+	if req.IsLogin {
+		if reason := disconnectReason(err, ir.config().DisconnectMessages); reason != nil {
+			_ = c.disconnect(reason)
+		}
+		return err
+	}
+
+	notFoundStatus := ir.serverNotFoundStatus()
+	if !errors.Is(err, ErrServerNotFound) || notFoundStatus == nil {
+		return err
+	}
+
+	pk, err := notFoundStatus.packet(req.ProtocolVersion)
+	if err != nil {
+		return err
+	}
+
+	return handleStatus(c, ServerResponse{
+		StatusResponse: pk,
+	})
 }
 
 func handleStatus(c *clientConn, resp ServerResponse) error {
@@ -296,18 +811,13 @@ func handleStatus(c *clientConn, resp ServerResponse) error {
 	return nil
 }
 
-func (ir *Infrared) handleLogin(c *clientConn, resp ServerResponse) error {
-	hsVersion := protocol.Version(c.handshake.ProtocolVersion)
-	if err := c.loginStart.Unmarshal(c.readPks[1], hsVersion); err != nil {
-		return err
-	}
+func (ir *Infrared) handleLogin(c *clientConn, req ServerRequest, resp ServerResponse) error {
+	c.timeout = ir.config().KeepAliveTimeout
 
-	c.timeout = ir.cfg.KeepAliveTimeout
-
-	return ir.handlePipe(c, resp)
+	return ir.handlePipe(c, req, resp)
 }
 
-func (ir *Infrared) handlePipe(c *clientConn, resp ServerResponse) error {
+func (ir *Infrared) handlePipe(c *clientConn, req ServerRequest, resp ServerResponse) error {
 	rc := resp.ServerConn
 	defer rc.Close()
 
@@ -317,19 +827,58 @@ func (ir *Infrared) handlePipe(c *clientConn, resp ServerResponse) error {
 		}
 	}
 
-	if err := rc.WritePackets(c.readPks[0], c.readPks[1]); err != nil {
+	hsPk := c.readPks[0]
+	if resp.Handshake != nil {
+		hsPk = *resp.Handshake
+	}
+
+	if err := rc.WritePackets(hsPk, c.readPks[1]); err != nil {
 		return err
 	}
 
 	rcClosedChan := make(chan struct{})
 	cClosedChan := make(chan struct{})
 
-	c.timeout = ir.cfg.KeepAliveTimeout
-	rc.timeout = ir.cfg.KeepAliveTimeout
-	ir.conns[c.RemoteAddr()] = c
+	keepAliveTimeout := ir.config().KeepAliveTimeout
+	c.timeout = keepAliveTimeout
+	rc.timeout = keepAliveTimeout
+	upstream := countingWriter{
+		w:       rc,
+		counter: ir.metrics.bytesCounter(resp.ServerName, "upstream"),
+	}
+	downstream := countingWriter{
+		w:       c,
+		counter: ir.metrics.bytesCounter(resp.ServerName, "downstream"),
+	}
+	relay := newServerRelay(rc, downstream, req.ProtocolVersion)
+	// Writing to c.w directly does not refresh the write deadline of the disconnect packet
+	relay.disconnectDst = countingWriter{
+		w:       c.w,
+		counter: downstream.counter,
+	}
+	relay.upstream = rc
+	relay.velocityPlayerInfo = resp.VelocityPlayerInfo
+
+	connID := ir.conns.add(&pipedConn{
+		playerName:  req.PlayerName,
+		playerUUID:  req.PlayerUUID,
+		domain:      req.Domain,
+		clientAddr:  c.RemoteAddr(),
+		serverName:  resp.ServerName,
+		serverAddr:  rc.RemoteAddr(),
+		connectedAt: time.Now(),
+		conn:        c,
+		relay:       relay,
+	})
+
+	pipeClosed := ir.metrics.pipeStarted(resp.ServerName)
+	defer pipeClosed()
 
-	go ir.pipe(rc, c, cClosedChan)
-	go ir.pipe(c, rc, rcClosedChan)
+	go ir.pipe(func() error {
+		_, err := io.Copy(upstream, c)
+		return err
+	}, cClosedChan)
+	go ir.pipe(relay.run, rcClosedChan)
 
 	var waitChan chan struct{}
 	select {
@@ -341,13 +890,14 @@ func (ir *Infrared) handlePipe(c *clientConn, resp ServerResponse) error {
 		waitChan = cClosedChan
 	}
 	<-waitChan
-	delete(ir.conns, c.RemoteAddr())
+	ir.conns.remove(connID)
 
 	return nil
 }
 
-func (ir *Infrared) pipe(dst io.WriteCloser, src io.ReadCloser, srcClosedChan chan struct{}) {
-	if _, err := io.Copy(dst, src); err != nil && !errors.Is(err, io.EOF) {
+// pipe runs copyFn and signals srcClosedChan once copying stopped.
+func (ir *Infrared) pipe(copyFn func() error, srcClosedChan chan struct{}) {
+	if err := copyFn(); err != nil && !errors.Is(err, io.EOF) {
 		ir.Logger.Debug().
 			Err(err).
 			Msg("Connection closed unexpectedly")
@@ -355,3 +905,68 @@ func (ir *Infrared) pipe(dst io.WriteCloser, src io.ReadCloser, srcClosedChan ch
 
 	srcClosedChan <- struct{}{}
 }
+
+// Shutdown gracefully shuts down Infrared. It stops accepting new connections and
+// sends the shutdown disconnect message to all players if a message is configured.
+// Then it waits for all connections to close. If ctx is done before that,
+// all remaining connections are closed and the error of ctx is returned.
+// Players with an encrypted connection cannot receive a disconnect message and
+// are disconnected once ctx is done.
+func (ir *Infrared) Shutdown(ctx context.Context) error {
+	ir.reloadMu.Lock()
+	defer ir.reloadMu.Unlock()
+
+	ir.mu.Lock()
+	if ir.isShutdown {
+		ir.mu.Unlock()
+		return ErrShutdown
+	}
+	ir.isShutdown = true
+	cfg := ir.cfg
+	lns := ir.listeners
+	stopServers := ir.stopServers
+	stopMetricsServer := ir.stopMetricsServer
+	stopAPIServer := ir.stopAPIServer
+	ir.mu.Unlock()
+
+	if lns == nil {
+		return nil
+	}
+
+	ir.Logger.Info().
+		Int("connections", ir.conns.len()).
+		Msg("Shutting down")
+
+	// This stops the accept loops
+	for _, ln := range lns {
+		if ln.l != nil {
+			_ = ln.l.Close()
+		}
+	}
+
+	defer func() {
+		stopServers()
+		stopMetricsServer()
+		stopAPIServer()
+	}()
+
+	if cfg.DisconnectMessages.Shutdown != nil {
+		ir.conns.disconnectAll(ctx, cfg.DisconnectMessages.Shutdown)
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if ir.conns.len() == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			ir.conns.closeAll()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -2,15 +2,45 @@ package infrared_test
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
 	"errors"
+	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/google/uuid"
 	ir "github.com/haveachin/infrared/pkg/infrared"
 	"github.com/haveachin/infrared/pkg/infrared/protocol"
 	"github.com/haveachin/infrared/pkg/infrared/protocol/handshaking"
 	"github.com/haveachin/infrared/pkg/infrared/protocol/login"
+	"github.com/haveachin/infrared/pkg/infrared/protocol/play"
+	"github.com/haveachin/infrared/pkg/infrared/protocol/status"
 	"github.com/pires/go-proxyproto"
+	"github.com/rs/zerolog"
 )
 
 type VirtualConn struct {
@@ -72,8 +102,6 @@ type VirtualListener struct {
 }
 
 func (l *VirtualListener) Accept() (net.Conn, error) {
-	l.errChan = make(chan error)
-
 	l.acceptTickerChan <- struct{}{}
 	defer func() {
 		select {
@@ -302,3 +330,2456 @@ func TestInfrared_ReceiveProxyProtocol_True_UntrustedIP(t *testing.T) {
 		t.Fatal("no disconnect after untrusted IP")
 	}
 }
+
+func TestInfrared_Reload(t *testing.T) {
+	vi, srvOut := NewVirtualInfrared(ir.NewConfig(), false)
+	go vi.MustListenAndServe(t)
+
+	vc := vi.NewConn(nil)
+	if err := vc.SendHandshake(handshaking.ServerBoundHandshake{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := vc.SendLoginStart(login.ServerBoundLoginStart{}, protocol.Version1_20_2); err != nil {
+		t.Fatal(err)
+	}
+
+	r := bufio.NewReader(srvOut)
+	var pk protocol.Packet
+	// Handshake and login start
+	for i := 0; i < 2; i++ {
+		if _, err := pk.ReadFrom(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	invalidCfg := ir.NewConfig().
+		AddServerConfig(ir.WithServerDomains("example.com"))
+	if err := vi.vir.Reload(invalidCfg); err == nil {
+		t.Fatal("got: no error; want: error for server without addresses")
+	}
+
+	validCfg := ir.NewConfig().
+		WithKeepAliveTimeout(time.Minute).
+		AddServerConfig(
+			ir.WithServerDomains("example.com"),
+			ir.WithServerAddresses("localhost:25565"),
+		)
+	if err := vi.vir.Reload(validCfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// The piped connection has to survive the reload
+	want := []byte("still connected")
+	go func() {
+		_, _ = vc.Write(want)
+	}()
+
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(r, got); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got: %q; want: %q", got, want)
+	}
+}
+
+func TestInfrared_Reload_KeepsBackendState(t *testing.T) {
+	l := listenTCP(t)
+	newCfg := func(hc *ir.HealthCheckConfig) ir.Config {
+		fns := []ir.ServerConfigFunc{
+			ir.WithServerDomains("example.com"),
+			ir.WithServerAddresses(ir.ServerAddress(l.Addr().String())),
+		}
+		if hc != nil {
+			fns = append(fns, ir.WithServerHealthCheck(*hc))
+		}
+		return ir.NewConfig().AddServerConfig(fns...)
+	}
+
+	tt := []struct {
+		name string
+		// healthCheck is the health check config after the reload
+		healthCheck *ir.HealthCheckConfig
+		wantHealthy bool
+	}{
+		{
+			// A new backend would stay healthy until it failed the new threshold
+			name: "health check changed while address is down",
+			healthCheck: &ir.HealthCheckConfig{
+				Interval:           10 * time.Millisecond,
+				Timeout:            100 * time.Millisecond,
+				UnhealthyThreshold: 1000,
+			},
+			wantHealthy: false,
+		},
+		{
+			// Without health checks nothing would restore the address to rotation
+			name:        "health check removed while address is down",
+			healthCheck: nil,
+			wantHealthy: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			vi, _ := NewVirtualInfrared(newCfg(&ir.HealthCheckConfig{
+				Interval:           10 * time.Millisecond,
+				Timeout:            100 * time.Millisecond,
+				UnhealthyThreshold: 1,
+			}), false)
+			go vi.MustListenAndServe(t)
+			<-vi.AcceptTick()
+
+			api := vi.vir.APIHandler("")
+			isHealthy := func() bool {
+				t.Helper()
+
+				rec := httptest.NewRecorder()
+				api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/servers", nil))
+
+				var srvs []struct {
+					Addresses []struct {
+						Healthy bool `json:"healthy"`
+					} `json:"addresses"`
+				}
+				if err := json.NewDecoder(rec.Body).Decode(&srvs); err != nil {
+					t.Fatal(err)
+				}
+
+				if len(srvs) != 1 || len(srvs[0].Addresses) != 1 {
+					t.Fatalf("got: %v; want: one server with one address", srvs)
+				}
+				return srvs[0].Addresses[0].Healthy
+			}
+
+			deadline := time.Now().Add(5 * time.Second)
+			for isHealthy() {
+				if time.Now().After(deadline) {
+					t.Fatal("address did not become unhealthy")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			if err := vi.vir.Reload(newCfg(tc.healthCheck)); err != nil {
+				t.Fatal(err)
+			}
+
+			// Gives checks of the old server that are still running time to finish
+			time.Sleep(50 * time.Millisecond)
+
+			if got := isHealthy(); got != tc.wantHealthy {
+				t.Fatalf("got: healthy %v; want: healthy %v", got, tc.wantHealthy)
+			}
+		})
+	}
+}
+
+func TestInfrared_Reload_APIToken(t *testing.T) {
+	bind := string(closedAddr(t))
+	newCfg := func(token string) ir.Config {
+		return ir.NewConfig().
+			WithAPI(ir.APIConfig{
+				Bind:  bind,
+				Token: token,
+			}).
+			AddServerConfig(
+				ir.WithServerDomains("example.com"),
+				ir.WithServerAddresses("localhost:25565"),
+			)
+	}
+
+	vi, _ := NewVirtualInfrared(newCfg("old"), false)
+	go vi.MustListenAndServe(t)
+	<-vi.AcceptTick()
+
+	// The API keeps its address, so it must not be bound again
+	if err := vi.vir.Reload(newCfg("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	for token, wantCode := range map[string]int{
+		"old": http.StatusUnauthorized,
+		"new": http.StatusOK,
+	} {
+		req, err := http.NewRequest(http.MethodGet, "http://"+bind+"/servers", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+
+		if resp.StatusCode != wantCode {
+			t.Fatalf("got: %d; want: %d", resp.StatusCode, wantCode)
+		}
+	}
+}
+
+func TestInfrared_DisconnectMessages(t *testing.T) {
+	tt := []struct {
+		name       string
+		cfg        ir.Config
+		domain     string
+		wantReason string
+	}{
+		{
+			name: "server not found with default message",
+			cfg: ir.NewConfig().
+				AddServerConfig(
+					ir.WithServerDomains("example.com"),
+					ir.WithServerAddresses("localhost:25565"),
+				),
+			domain:     "unknown.com",
+			wantReason: `"Server not found"`,
+		},
+		{
+			name: "server not found with global message",
+			cfg: ir.NewConfig().
+				WithDisconnectMessages(ir.DisconnectMessagesConfig{
+					ServerNotFound: map[string]any{"text": "Unknown server"},
+				}).
+				AddServerConfig(
+					ir.WithServerDomains("example.com"),
+					ir.WithServerAddresses("localhost:25565"),
+				),
+			domain:     "unknown.com",
+			wantReason: `{"text":"Unknown server"}`,
+		},
+		{
+			name: "server unreachable with proxy message",
+			cfg: ir.NewConfig().
+				WithDisconnectMessages(ir.DisconnectMessagesConfig{
+					ServerUnreachable: "Global",
+				}).
+				AddServerConfig(
+					ir.WithServerDomains("example.com"),
+					ir.WithServerAddresses(closedAddr(t)),
+					ir.WithServerDisconnectMessages(ir.DisconnectMessagesConfig{
+						ServerUnreachable: "Proxy",
+					}),
+				),
+			domain:     "example.com",
+			wantReason: `"Proxy"`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			vi, _ := NewVirtualInfrared(tc.cfg, false)
+			vi.vir.NewServerRequesterFunc = nil
+			go vi.MustListenAndServe(t)
+
+			vc := vi.NewConn(nil)
+			if err := vc.SendHandshake(handshaking.ServerBoundHandshake{
+				ProtocolVersion: protocol.VarInt(protocol.Version1_20_2),
+				ServerAddress:   protocol.String(tc.domain),
+				ServerPort:      25565,
+				NextState:       handshaking.StateLoginServerBoundHandshake,
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if err := vc.SendLoginStart(login.ServerBoundLoginStart{}, protocol.Version1_20_2); err != nil {
+				t.Fatal(err)
+			}
+
+			var pk protocol.Packet
+			if _, err := pk.ReadFrom(vc); err != nil {
+				t.Fatal(err)
+			}
+
+			if pk.ID != login.ClientBoundDisconnectID {
+				t.Fatalf("got: packet id %d; want: %d", pk.ID, login.ClientBoundDisconnectID)
+			}
+
+			var reason protocol.String
+			if err := pk.Decode(&reason); err != nil {
+				t.Fatal(err)
+			}
+
+			if string(reason) != tc.wantReason {
+				t.Fatalf("got: %s; want: %s", reason, tc.wantReason)
+			}
+		})
+	}
+}
+
+func (c VirtualConn) SendStatusRequest(domain string, v protocol.Version) error {
+	pk := protocol.Packet{}
+	if err := (handshaking.ServerBoundHandshake{
+		ProtocolVersion: protocol.VarInt(v),
+		ServerAddress:   protocol.String(domain),
+		ServerPort:      25565,
+		NextState:       handshaking.StateStatusServerBoundHandshake,
+	}).Marshal(&pk); err != nil {
+		return err
+	}
+
+	if _, err := pk.WriteTo(c.Conn); err != nil {
+		return err
+	}
+
+	if err := (status.ServerBoundRequest{}).Marshal(&pk); err != nil {
+		return err
+	}
+
+	_, err := pk.WriteTo(c.Conn)
+	return err
+}
+
+func (c VirtualConn) ReadStatusResponse() (status.ResponseJSON, error) {
+	var pk protocol.Packet
+	if _, err := pk.ReadFrom(c.Conn); err != nil {
+		return status.ResponseJSON{}, err
+	}
+
+	var respPk status.ClientBoundResponse
+	if err := respPk.Unmarshal(pk); err != nil {
+		return status.ResponseJSON{}, err
+	}
+
+	var respJSON status.ResponseJSON
+	err := json.Unmarshal([]byte(respPk.JSONResponse), &respJSON)
+	return respJSON, err
+}
+
+func TestInfrared_StatusFallback(t *testing.T) {
+	maxPlayers := 20
+
+	tt := []struct {
+		name            string
+		cfg             ir.Config
+		domain          string
+		wantDescription string
+	}{
+		{
+			name: "server not found",
+			cfg: ir.NewConfig().
+				WithServerNotFoundStatus(ir.StatusResponseConfig{
+					VersionName: "Infrared",
+					MOTD:        "Unknown server",
+				}).
+				AddServerConfig(
+					ir.WithServerDomains("example.com"),
+					ir.WithServerAddresses("localhost:25565"),
+				),
+			domain:          "unknown.com",
+			wantDescription: "Unknown server",
+		},
+		{
+			name: "server offline",
+			cfg: ir.NewConfig().
+				AddServerConfig(
+					ir.WithServerDomains("example.com"),
+					ir.WithServerAddresses(closedAddr(t)),
+					ir.WithServerOfflineStatus(ir.StatusResponseConfig{
+						VersionName:    "Infrared",
+						MaxPlayerCount: &maxPlayers,
+						MOTD:           "Server is offline",
+					}),
+				),
+			domain:          "example.com",
+			wantDescription: "Server is offline",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			vi, _ := NewVirtualInfrared(tc.cfg, false)
+			vi.vir.NewServerRequesterFunc = nil
+			go vi.MustListenAndServe(t)
+
+			vc := vi.NewConn(nil)
+			if err := vc.SendStatusRequest(tc.domain, protocol.Version1_20_2); err != nil {
+				t.Fatal(err)
+			}
+
+			respJSON, err := vc.ReadStatusResponse()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if respJSON.Description != tc.wantDescription {
+				t.Errorf("got: %v; want: %s", respJSON.Description, tc.wantDescription)
+			}
+
+			if respJSON.Version.Protocol != int(protocol.Version1_20_2) {
+				t.Errorf("got: %d; want: %d", respJSON.Version.Protocol, protocol.Version1_20_2)
+			}
+		})
+	}
+}
+
+func TestInfrared_StatusOverride(t *testing.T) {
+	l := listenStatusServer(t)
+
+	iconPath := filepath.Join(t.TempDir(), "icon.png")
+	if err := os.WriteFile(iconPath, []byte("\x89PNG"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	maxPlayers := 100
+	cfg := ir.NewConfig().
+		AddServerConfig(
+			ir.WithServerDomains("example.com"),
+			ir.WithServerAddresses(ir.ServerAddress(l.Addr().String())),
+			ir.WithServerStatusOverride(ir.StatusResponseConfig{
+				MaxPlayerCount: &maxPlayers,
+				IconPath:       iconPath,
+				MOTD:           "Branded",
+			}),
+		)
+
+	vi, _ := NewVirtualInfrared(cfg, false)
+	vi.vir.NewServerRequesterFunc = nil
+	go vi.MustListenAndServe(t)
+
+	vc := vi.NewConn(nil)
+	if err := vc.SendStatusRequest("example.com", protocol.Version1_20_2); err != nil {
+		t.Fatal(err)
+	}
+
+	respJSON, err := vc.ReadStatusResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if respJSON.Description != "Branded" {
+		t.Errorf("got: %v; want: Branded", respJSON.Description)
+	}
+
+	if respJSON.Players.Max != maxPlayers {
+		t.Errorf("got: %d; want: %d", respJSON.Players.Max, maxPlayers)
+	}
+
+	wantFavicon := "data:image/png;base64,iVBORw=="
+	if respJSON.Favicon != wantFavicon {
+		t.Errorf("got: %s; want: %s", respJSON.Favicon, wantFavicon)
+	}
+
+	// Not overridden and passed through from the server
+	if respJSON.Version.Name != "1.20.2" {
+		t.Errorf("got: %s; want: 1.20.2", respJSON.Version.Name)
+	}
+}
+
+// requestStatus sends a status request through Infrared and returns the status response.
+func (vi *VirtualInfrared) requestStatus(t *testing.T, domain string, v protocol.Version) status.ResponseJSON {
+	t.Helper()
+
+	vc := vi.NewConn(nil)
+	defer vc.Close()
+
+	if err := vc.SendStatusRequest(domain, v); err != nil {
+		t.Fatal(err)
+	}
+
+	respJSON, err := vc.ReadStatusResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return respJSON
+}
+
+func TestInfrared_StatusCache(t *testing.T) {
+	var requests atomic.Int32
+	l := listenStatusServerFunc(t, func(hs handshaking.ServerBoundHandshake) status.ResponseJSON {
+		return status.ResponseJSON{
+			Version: status.VersionJSON{
+				Protocol: int(hs.ProtocolVersion),
+			},
+			Description: strconv.Itoa(int(requests.Add(1))),
+		}
+	})
+
+	cacheTTL := 100 * time.Millisecond
+	cfg := ir.NewConfig().
+		AddServerConfig(
+			ir.WithServerDomains("example.com"),
+			ir.WithServerAddresses(ir.ServerAddress(l.Addr().String())),
+			ir.WithServerStatusCache(cacheTTL, true),
+		)
+
+	vi, _ := NewVirtualInfrared(cfg, false)
+	vi.vir.NewServerRequesterFunc = nil
+	go vi.MustListenAndServe(t)
+
+	// Every protocol version has its own cache entry
+	for _, v := range []protocol.Version{protocol.Version1_19, protocol.Version1_20_2} {
+		respJSON := vi.requestStatus(t, "example.com", v)
+		if respJSON.Version.Protocol != int(v) {
+			t.Fatalf("got: %d; want: %d", respJSON.Version.Protocol, v)
+		}
+	}
+
+	respJSON := vi.requestStatus(t, "example.com", protocol.Version1_19)
+	if respJSON.Description != "1" {
+		t.Fatalf("got: %v; want: cached response 1", respJSON.Description)
+	}
+
+	time.Sleep(cacheTTL)
+
+	// Expired responses are served while refreshing in the background
+	respJSON = vi.requestStatus(t, "example.com", protocol.Version1_19)
+	if respJSON.Description != "1" {
+		t.Fatalf("got: %v; want: expired response 1", respJSON.Description)
+	}
+
+	time.Sleep(cacheTTL / 2)
+
+	respJSON = vi.requestStatus(t, "example.com", protocol.Version1_19)
+	if respJSON.Description != "3" {
+		t.Fatalf("got: %v; want: refreshed response 3", respJSON.Description)
+	}
+}
+
+func TestInfrared_StatusCache_SharedEntries(t *testing.T) {
+	var requests atomic.Int32
+	l := listenStatusServerFunc(t, func(hs handshaking.ServerBoundHandshake) status.ResponseJSON {
+		// Slow enough that all clients wait for the same request
+		time.Sleep(100 * time.Millisecond)
+		return status.ResponseJSON{
+			Description: strconv.Itoa(int(requests.Add(1))),
+		}
+	})
+
+	cfg := ir.NewConfig().
+		AddServerConfig(
+			ir.WithServerDomains("*.example.com"),
+			ir.WithServerAddresses(ir.ServerAddress(l.Addr().String())),
+			ir.WithServerStatusCache(time.Minute, false),
+		)
+
+	vi, _ := NewVirtualInfrared(cfg, false)
+	vi.vir.NewServerRequesterFunc = nil
+	go vi.MustListenAndServe(t)
+
+	// Domains matched by a wildcard share one cache entry
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			vc := vi.NewConn(nil)
+			defer vc.Close()
+
+			if err := vc.SendStatusRequest(fmt.Sprintf("player%d.example.com", i), protocol.Version1_20_2); err != nil {
+				t.Error(err)
+				return
+			}
+
+			respJSON, err := vc.ReadStatusResponse()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if respJSON.Description != "1" {
+				t.Errorf("got: %v; want: 1", respJSON.Description)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if got := requests.Load(); got != 1 {
+		t.Errorf("got: %d requests; want: 1", got)
+	}
+}
+
+func TestInfrared_StatusCache_ColdWithBackgroundRefresh(t *testing.T) {
+	l := listenStatusServerFunc(t, func(hs handshaking.ServerBoundHandshake) status.ResponseJSON {
+		return status.ResponseJSON{
+			Description: "Online",
+		}
+	})
+
+	cfg := ir.NewConfig().
+		AddServerConfig(
+			ir.WithServerDomains("example.com"),
+			ir.WithServerAddresses(ir.ServerAddress(l.Addr().String())),
+			ir.WithServerStatusCache(time.Minute, true),
+			ir.WithServerOfflineStatus(ir.StatusResponseConfig{
+				MOTD: "Offline",
+			}),
+		)
+
+	vi, _ := NewVirtualInfrared(cfg, false)
+	vi.vir.NewServerRequesterFunc = nil
+	go vi.MustListenAndServe(t)
+
+	respJSON := vi.requestStatus(t, "example.com", protocol.Version1_20_2)
+	if respJSON.Description != "Online" {
+		t.Errorf("got: %v; want: Online", respJSON.Description)
+	}
+}
+
+func TestInfrared_Metrics(t *testing.T) {
+	l := listenStatusServer(t)
+	cfg := ir.NewConfig().
+		AddServerConfig(
+			ir.WithServerName("lobby"),
+			ir.WithServerDomains("example.com"),
+			ir.WithServerAddresses(ir.ServerAddress(l.Addr().String())),
+		)
+
+	vi, _ := NewVirtualInfrared(cfg, false)
+	vi.vir.NewServerRequesterFunc = nil
+	go vi.MustListenAndServe(t)
+
+	vi.requestStatus(t, "example.com", protocol.Version1_20_2)
+	vi.requestStatus(t, "example.com", protocol.Version1_20_2)
+
+	vc := vi.NewConn(nil)
+	if err := vc.SendStatusRequest("unknown.com", protocol.Version1_20_2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vc.ReadStatusResponse(); err == nil {
+		t.Fatal("got: status response; want: closed connection")
+	}
+
+	rec := httptest.NewRecorder()
+	vi.vir.MetricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+
+	wantMetrics := []string{
+		`infrared_connections_accepted_total 3`,
+		`infrared_requests_total{next_state="status",server="lobby"} 2`,
+		`infrared_connections_rejected_total{reason="server_not_found"} 1`,
+		`infrared_status_cache_lookups_total{result="hit",server="lobby"} 1`,
+		`infrared_status_cache_lookups_total{result="miss",server="lobby"} 1`,
+	}
+
+	for _, want := range wantMetrics {
+		if !strings.Contains(body, want) {
+			t.Errorf("got: metrics without %q; want: metric", want)
+		}
+	}
+}
+
+func TestInfrared_Metrics_LimitCounterErrors(t *testing.T) {
+	l := listenStatusServer(t)
+	cfg := ir.NewConfig().
+		AddServerConfig(
+			ir.WithServerDomains("example.com"),
+			ir.WithServerAddresses(ir.ServerAddress(l.Addr().String())),
+		)
+	cfg.FiltersConfig.RateLimiter = &ir.RateLimiterConfig{
+		RequestLimit: 1,
+		WindowLength: time.Minute,
+		Redis: &ir.RedisLimitCounterConfig{
+			Addr:    string(closedAddr(t)),
+			Timeout: 100 * time.Millisecond,
+		},
+	}
+
+	vi, _ := NewVirtualInfrared(cfg, false)
+	vi.vir.NewServerRequesterFunc = nil
+	go vi.MustListenAndServe(t)
+
+	// Requests that cannot be counted are allowed
+	vi.requestStatus(t, "example.com", protocol.Version1_20_2)
+	vi.requestStatus(t, "example.com", protocol.Version1_20_2)
+
+	rec := httptest.NewRecorder()
+	vi.vir.MetricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	want := `infrared_rate_limiter_counter_errors_total 2`
+	if !strings.Contains(rec.Body.String(), want) {
+		t.Fatalf("got: metrics without %q; want: metric", want)
+	}
+}
+
+func TestInfrared_API(t *testing.T) {
+	cfg := ir.NewConfig().
+		AddServerConfig(
+			ir.WithServerName("lobby"),
+			ir.WithServerDomains("example.com"),
+			ir.WithServerAddresses("localhost:25565"),
+		)
+
+	vi, srvOut := NewVirtualInfrared(cfg, false)
+	go vi.MustListenAndServe(t)
+	go func() {
+		_, _ = io.Copy(io.Discard, srvOut)
+	}()
+
+	playerUUID := uuid.New()
+	vc := vi.NewConn(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1337})
+	if err := vc.SendHandshake(handshaking.ServerBoundHandshake{
+		ProtocolVersion: protocol.VarInt(protocol.Version1_20_2),
+		ServerAddress:   "example.com",
+		ServerPort:      25565,
+		NextState:       handshaking.StateLoginServerBoundHandshake,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := vc.SendLoginStart(login.ServerBoundLoginStart{
+		Name:       "Steve",
+		PlayerUUID: protocol.UUID(playerUUID),
+	}, protocol.Version1_20_2); err != nil {
+		t.Fatal(err)
+	}
+
+	api := vi.vir.APIHandler("secret")
+
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/connections", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("got: %d; want: %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	type connection struct {
+		ID         string    `json:"id"`
+		PlayerName string    `json:"playerName"`
+		PlayerUUID uuid.UUID `json:"playerUUID"`
+		Domain     string    `json:"domain"`
+		ClientIP   string    `json:"clientIP"`
+	}
+
+	var conns []connection
+	for i := 0; i < 50 && len(conns) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+
+		req := httptest.NewRequest(http.MethodGet, "/connections", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec = httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		if err := json.NewDecoder(rec.Body).Decode(&conns); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wantConn := connection{
+		PlayerName: "Steve",
+		PlayerUUID: playerUUID,
+		Domain:     "example.com",
+		ClientIP:   "10.0.0.1",
+	}
+	if len(conns) != 1 {
+		t.Fatalf("got: %d connections; want: 1", len(conns))
+	}
+	wantConn.ID = conns[0].ID
+	if conns[0] != wantConn {
+		t.Fatalf("got: %+v; want: %+v", conns[0], wantConn)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/connections/"+conns[0].ID, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("got: %d; want: %d", rec.Code, http.StatusNoContent)
+	}
+
+	if _, err := vc.Read(make([]byte, 1)); err == nil {
+		t.Fatal("got: open connection; want: kicked connection")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/servers", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+
+	wantServers := `[{"name":"lobby","domains":["example.com"],"addresses":` +
+		`[{"address":"localhost:25565","healthy":true,"activeConnections":0}]}]`
+	if got := strings.TrimSpace(rec.Body.String()); got != wantServers {
+		t.Fatalf("got: %s; want: %s", got, wantServers)
+	}
+}
+
+// writeCompressedFrame writes pk uncompressed in the compressed packet format.
+func writeCompressedFrame(w io.Writer, pk protocol.Packet) error {
+	var body bytes.Buffer
+	_, _ = protocol.VarInt(0).WriteTo(&body)
+	_, _ = protocol.VarInt(pk.ID).WriteTo(&body)
+	_, _ = body.Write(pk.Data)
+
+	if _, err := protocol.VarInt(body.Len()).WriteTo(w); err != nil {
+		return err
+	}
+	_, err := w.Write(body.Bytes())
+	return err
+}
+
+// readCompressedFrame reads an uncompressed packet in the compressed packet format.
+func readCompressedFrame(r io.Reader) (protocol.Packet, error) {
+	var frameLen protocol.VarInt
+	if _, err := frameLen.ReadFrom(r); err != nil {
+		return protocol.Packet{}, err
+	}
+
+	body := make([]byte, frameLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return protocol.Packet{}, err
+	}
+
+	br := bytes.NewReader(body)
+	var dataLen, pkID protocol.VarInt
+	if _, err := dataLen.ReadFrom(br); err != nil {
+		return protocol.Packet{}, err
+	}
+	if _, err := pkID.ReadFrom(br); err != nil {
+		return protocol.Packet{}, err
+	}
+
+	data, err := io.ReadAll(br)
+	return protocol.Packet{
+		ID:   int32(pkID),
+		Data: data,
+	}, err
+}
+
+func TestInfrared_Shutdown(t *testing.T) {
+	tt := []struct {
+		name string
+		// loginPks are sent by the server before the shutdown
+		loginPks []protocol.Packet
+		// timeout of the shutdown; defaults to 200ms
+		timeout     time.Duration
+		wantReason  string
+		wantErr     error
+		maxDuration time.Duration
+	}{
+		{
+			name: "sends disconnect in play state",
+			loginPks: []protocol.Packet{
+				{ID: login.ClientBoundSetCompressionID, Data: []byte{0x80, 0x02}},
+				{ID: login.ClientBoundLoginSuccessID},
+			},
+			wantReason: `"Restarting"`,
+		},
+		{
+			name: "does not wait for clients that do not read",
+			loginPks: []protocol.Packet{
+				{ID: login.ClientBoundSetCompressionID, Data: []byte{0x80, 0x02}},
+				{ID: login.ClientBoundLoginSuccessID},
+			},
+			wantErr: context.DeadlineExceeded,
+		},
+		{
+			name: "closes clients that do not read the disconnect in time",
+			loginPks: []protocol.Packet{
+				{ID: login.ClientBoundSetCompressionID, Data: []byte{0x80, 0x02}},
+				{ID: login.ClientBoundLoginSuccessID},
+			},
+			timeout:     10 * time.Second,
+			maxDuration: 2 * time.Second,
+		},
+		{
+			name: "waits for encrypted connections",
+			loginPks: []protocol.Packet{
+				{ID: login.ClientBoundEncryptionRequestID},
+			},
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := ir.NewConfig().
+				WithDisconnectMessages(ir.DisconnectMessagesConfig{
+					Shutdown: "Restarting",
+				})
+			vi, srvOut := NewVirtualInfrared(cfg, false)
+			go vi.MustListenAndServe(t)
+
+			vc := vi.NewConn(nil)
+			if err := vc.SendHandshake(handshaking.ServerBoundHandshake{
+				ProtocolVersion: protocol.VarInt(protocol.Version1_19),
+				NextState:       handshaking.StateLoginServerBoundHandshake,
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if err := vc.SendLoginStart(login.ServerBoundLoginStart{}, protocol.Version1_19); err != nil {
+				t.Fatal(err)
+			}
+
+			srvReader := bufio.NewReader(srvOut)
+			var pk protocol.Packet
+			for i := 0; i < 2; i++ {
+				if _, err := pk.ReadFrom(srvReader); err != nil {
+					t.Fatal(err)
+				}
+			}
+			go func() {
+				_, _ = io.Copy(io.Discard, srvReader)
+			}()
+
+			go func() {
+				compressed := false
+				for _, pk := range tc.loginPks {
+					if compressed {
+						_ = writeCompressedFrame(srvOut, pk)
+					} else {
+						_, _ = pk.WriteTo(srvOut)
+					}
+					compressed = compressed || pk.ID == login.ClientBoundSetCompressionID
+				}
+			}()
+
+			cliReader := bufio.NewReader(vc)
+			for i, wantPk := range tc.loginPks {
+				if i > 0 && tc.loginPks[0].ID == login.ClientBoundSetCompressionID {
+					pk, _ = readCompressedFrame(cliReader)
+				} else {
+					_, _ = pk.ReadFrom(cliReader)
+				}
+
+				if pk.ID != wantPk.ID {
+					t.Fatalf("got: %#x; want: %#x", pk.ID, wantPk.ID)
+				}
+			}
+
+			timeout := 200 * time.Millisecond
+			if tc.timeout != 0 {
+				timeout = tc.timeout
+			}
+
+			start := time.Now()
+			errChan := make(chan error, 1)
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				defer cancel()
+				errChan <- vi.vir.Shutdown(ctx)
+			}()
+
+			if tc.wantReason != "" {
+				pk, err := readCompressedFrame(cliReader)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				wantID, _ := play.ClientBoundDisconnectID(protocol.Version1_19)
+				if pk.ID != wantID {
+					t.Fatalf("got: %#x; want: %#x", pk.ID, wantID)
+				}
+
+				var reason protocol.Chat
+				if err := pk.Decode(&reason); err != nil {
+					t.Fatal(err)
+				}
+
+				if string(reason) != tc.wantReason {
+					t.Fatalf("got: %s; want: %s", reason, tc.wantReason)
+				}
+			}
+
+			if err := <-errChan; !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got: %v; want: %v", err, tc.wantErr)
+			}
+
+			if d := time.Since(start); tc.maxDuration != 0 && d > tc.maxDuration {
+				t.Errorf("got: %v; want: at most %v", d, tc.maxDuration)
+			}
+		})
+	}
+}
+
+func TestInfrared_SupportedVersions(t *testing.T) {
+	l := listenStatusServer(t)
+	cfg := ir.NewConfig().
+		AddServerConfig(
+			ir.WithServerDomains("example.com"),
+			ir.WithServerAddresses(ir.ServerAddress(l.Addr().String())),
+			ir.WithServerSupportedVersions(ir.SupportedVersionsConfig{
+				ProtocolVersionRange: ir.ProtocolVersionRange{
+					Min: protocol.Version1_19_3,
+					Max: protocol.Version1_20_2,
+				},
+			}),
+			ir.WithServerDisconnectMessages(ir.DisconnectMessagesConfig{
+				UnsupportedVersion: "Please use 1.19.3 or newer",
+			}),
+		)
+
+	vi, _ := NewVirtualInfrared(cfg, false)
+	vi.vir.NewServerRequesterFunc = nil
+	go vi.MustListenAndServe(t)
+
+	t.Run("status of supported version", func(t *testing.T) {
+		respJSON := vi.requestStatus(t, "example.com", protocol.Version1_20_2)
+		if respJSON.Version.Name != "1.20.2" {
+			t.Fatalf("got: %s; want: 1.20.2", respJSON.Version.Name)
+		}
+	})
+
+	t.Run("status of unsupported version", func(t *testing.T) {
+		respJSON := vi.requestStatus(t, "example.com", protocol.Version1_19)
+		wantVersion := status.VersionJSON{
+			Name:     "1.19.3-1.20.2",
+			Protocol: int(protocol.Version1_20_2),
+		}
+		if respJSON.Version != wantVersion {
+			t.Fatalf("got: %v; want: %v", respJSON.Version, wantVersion)
+		}
+	})
+
+	t.Run("login of unsupported version", func(t *testing.T) {
+		vc := vi.NewConn(nil)
+		if err := vc.SendHandshake(handshaking.ServerBoundHandshake{
+			ProtocolVersion: protocol.VarInt(protocol.Version1_19),
+			ServerAddress:   "example.com",
+			ServerPort:      25565,
+			NextState:       handshaking.StateLoginServerBoundHandshake,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := vc.SendLoginStart(login.ServerBoundLoginStart{}, protocol.Version1_19); err != nil {
+			t.Fatal(err)
+		}
+
+		var pk protocol.Packet
+		if _, err := pk.ReadFrom(vc); err != nil {
+			t.Fatal(err)
+		}
+
+		var reason protocol.Chat
+		if err := pk.Decode(&reason); err != nil {
+			t.Fatal(err)
+		}
+
+		if want := `"Please use 1.19.3 or newer"`; string(reason) != want {
+			t.Fatalf("got: %s; want: %s", reason, want)
+		}
+	})
+}
+
+func TestInfrared_Listeners(t *testing.T) {
+	newStatusServer := func(name string) ir.ServerAddress {
+		l := listenStatusServerFunc(t, func(handshaking.ServerBoundHandshake) status.ResponseJSON {
+			return status.ResponseJSON{
+				Description: name,
+			}
+		})
+		return ir.ServerAddress(l.Addr().String())
+	}
+
+	cfg := ir.NewConfig().
+		AddListener(ir.ListenerConfig{
+			Name: "public",
+			Bind: ":25565",
+		}).
+		AddListener(ir.ListenerConfig{
+			Name:    "survival",
+			Bind:    ":25566",
+			Servers: []string{"survival"},
+		}).
+		AddServerConfig(
+			ir.WithServerName("lobby"),
+			ir.WithServerDomains("*.example.com"),
+			ir.WithServerAddresses(newStatusServer("lobby")),
+		).
+		AddServerConfig(
+			ir.WithServerName("survival"),
+			ir.WithServerDefault(true),
+			ir.WithServerListeners("survival"),
+			ir.WithServerAddresses(newStatusServer("survival")),
+		)
+
+	vir := ir.NewWithConfig(cfg)
+	vis := make(map[string]*VirtualInfrared)
+	for _, bind := range []string{":25565", ":25566"} {
+		connChan := make(chan net.Conn)
+		vis[bind] = &VirtualInfrared{
+			vir: vir,
+			vl: &VirtualListener{
+				connChan:         connChan,
+				errChan:          make(chan error),
+				acceptTickerChan: make(chan struct{}, 1),
+			},
+			connChan: connChan,
+		}
+	}
+	vir.NewListenerFunc = func(addr string) (net.Listener, error) {
+		return vis[addr].vl, nil
+	}
+	go vis[":25565"].MustListenAndServe(t)
+
+	tt := []struct {
+		name       string
+		bind       string
+		domain     string
+		wantServer string
+	}{
+		{
+			name:       "domain on public listener",
+			bind:       ":25565",
+			domain:     "lobby.example.com",
+			wantServer: "lobby",
+		},
+		{
+			name:       "server of other listener is not available",
+			bind:       ":25565",
+			domain:     "survival.example.com",
+			wantServer: "lobby",
+		},
+		{
+			name:       "raw IP on survival listener",
+			bind:       ":25566",
+			domain:     "203.0.113.10",
+			wantServer: "survival",
+		},
+		{
+			name:       "server not allowed on survival listener",
+			bind:       ":25566",
+			domain:     "lobby.example.com",
+			wantServer: "survival",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			respJSON := vis[tc.bind].requestStatus(t, tc.domain, protocol.Version1_20_2)
+			if respJSON.Description != tc.wantServer {
+				t.Fatalf("got: %v; want: %s", respJSON.Description, tc.wantServer)
+			}
+		})
+	}
+}
+
+func TestInfrared_Listeners_InvalidConfig(t *testing.T) {
+	tt := []struct {
+		name    string
+		cfg     ir.Config
+		wantErr error
+	}{
+		{
+			name: "duplicate listener",
+			cfg: ir.NewConfig().
+				AddListener(ir.ListenerConfig{Bind: ":25565"}).
+				AddListener(ir.ListenerConfig{Bind: ":25565"}),
+			wantErr: ir.ErrDuplicateListener,
+		},
+		{
+			name: "unknown listener of server",
+			cfg: ir.NewConfig().
+				AddServerConfig(
+					ir.WithServerDomains("example.com"),
+					ir.WithServerAddresses("localhost:25565"),
+					ir.WithServerListeners("internal"),
+				),
+			wantErr: ir.ErrUnknownListener,
+		},
+		{
+			name: "unknown server of listener",
+			cfg: ir.NewConfig().
+				AddListener(ir.ListenerConfig{
+					Bind:    ":25565",
+					Servers: []string{"lobby"},
+				}),
+			wantErr: ir.ErrUnknownServer,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			vi, _ := NewVirtualInfrared(tc.cfg, false)
+			if err := vi.ListenAndServe(); !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got: %v; want: %s", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestInfrared_HandshakeRewrite(t *testing.T) {
+	l := listenStatusServerFunc(t, func(hs handshaking.ServerBoundHandshake) status.ResponseJSON {
+		return status.ResponseJSON{
+			Description: net.JoinHostPort(string(hs.ServerAddress), strconv.Itoa(int(hs.ServerPort))),
+		}
+	})
+
+	forgeAddr := "example.com" + handshaking.SeparatorForge + "FML2" + handshaking.SeparatorForge
+
+	tt := []struct {
+		name     string
+		cfg      ir.HandshakeConfig
+		addr     string
+		wantAddr string
+	}{
+		{
+			name:     "no rewrite",
+			addr:     forgeAddr,
+			wantAddr: net.JoinHostPort(forgeAddr, "25565"),
+		},
+		{
+			name: "server address and port keep forge marker",
+			cfg: ir.HandshakeConfig{
+				ServerAddress: "backend.local",
+				ServerPort:    25570,
+			},
+			addr:     forgeAddr,
+			wantAddr: net.JoinHostPort("backend.local"+handshaking.SeparatorForge+"FML2"+handshaking.SeparatorForge, "25570"),
+		},
+		{
+			name: "strip forge marker",
+			cfg: ir.HandshakeConfig{
+				StripForgeMarker: true,
+			},
+			addr:     forgeAddr,
+			wantAddr: "example.com:25565",
+		},
+		{
+			name: "inject forge marker",
+			cfg: ir.HandshakeConfig{
+				ForgeMarker: "FML3",
+			},
+			addr:     "example.com",
+			wantAddr: net.JoinHostPort("example.com"+handshaking.SeparatorForge+"FML3"+handshaking.SeparatorForge, "25565"),
+		},
+		{
+			name: "keep forge marker of client",
+			cfg: ir.HandshakeConfig{
+				ForgeMarker: "FML3",
+			},
+			addr:     forgeAddr,
+			wantAddr: net.JoinHostPort(forgeAddr, "25565"),
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := ir.NewConfig().
+				AddServerConfig(
+					ir.WithServerDomains("example.com"),
+					ir.WithServerAddresses(ir.ServerAddress(l.Addr().String())),
+					ir.WithServerHandshake(tc.cfg),
+				)
+
+			vi, _ := NewVirtualInfrared(cfg, false)
+			vi.vir.NewServerRequesterFunc = nil
+			go vi.MustListenAndServe(t)
+
+			respJSON := vi.requestStatus(t, tc.addr, protocol.Version1_20_2)
+			if respJSON.Description != tc.wantAddr {
+				t.Fatalf("got: %q; want: %q", respJSON.Description, tc.wantAddr)
+			}
+		})
+	}
+}
+
+func TestInfrared_SendRealIP(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyPath := filepath.Join(t.TempDir(), "realip.pem")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})
+	if err := os.WriteFile(keyPath, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	l := listenStatusServerFunc(t, func(hs handshaking.ServerBoundHandshake) status.ResponseJSON {
+		return status.ResponseJSON{
+			Description: string(hs.ServerAddress),
+		}
+	})
+
+	cfg := ir.NewConfig().
+		AddServerConfig(
+			ir.WithServerDomains("example.com"),
+			ir.WithServerAddresses(ir.ServerAddress(l.Addr().String())),
+			ir.WithServerSendRealIP(ir.RealIPConfig{
+				PrivateKeyFile: keyPath,
+			}),
+		)
+
+	vi, _ := NewVirtualInfrared(cfg, false)
+	vi.vir.NewServerRequesterFunc = nil
+	go vi.MustListenAndServe(t)
+
+	respJSON := vi.requestStatus(t, "example.com", protocol.Version1_20_2)
+	addr, ok := respJSON.Description.(string)
+	if !ok {
+		t.Fatalf("got: %v; want: server address", respJSON.Description)
+	}
+
+	parts := strings.Split(addr, handshaking.SeparatorRealIP)
+	if len(parts) != 4 {
+		t.Fatalf("got: %q; want: host///ip:port///timestamp///signature", addr)
+	}
+
+	if parts[0] != "example.com" || parts[1] != "127.0.0.1:25565" {
+		t.Fatalf("got: %q; want: example.com///127.0.0.1:25565///...", addr)
+	}
+
+	timestamp, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d := time.Since(time.Unix(timestamp, 0)); d < 0 || d > time.Minute {
+		t.Fatalf("got: timestamp %d; want: now", timestamp)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha512.Sum512([]byte(strings.Join(parts[:3], handshaking.SeparatorRealIP)))
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], signature) {
+		t.Fatal("got: invalid signature; want: valid signature")
+	}
+}
+
+func TestInfrared_ReceiveRealIP(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pubBytes, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pubPath := filepath.Join(t.TempDir(), "realip.pub.pem")
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes})
+	if err := os.WriteFile(pubPath, pubPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	signWith := func(key *ecdsa.PrivateKey) func([]byte) ([]byte, error) {
+		return func(payload []byte) ([]byte, error) {
+			digest := sha512.Sum512(payload)
+			return ecdsa.SignASN1(rand.Reader, key, digest[:])
+		}
+	}
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	playerAddr := &net.TCPAddr{
+		IP:   net.IPv4(203, 0, 113, 10),
+		Port: 54321,
+	}
+
+	realIPAddr := func(t *testing.T, timestamp time.Time, sign func([]byte) ([]byte, error)) string {
+		t.Helper()
+
+		hs := handshaking.ServerBoundHandshake{
+			ServerAddress: protocol.String("example.com" + handshaking.SeparatorForge + "FML2" + handshaking.SeparatorForge),
+		}
+		if err := hs.UpgradeToSignedRealIP(playerAddr, timestamp, sign); err != nil {
+			t.Fatal(err)
+		}
+		return string(hs.ServerAddress)
+	}
+
+	cfg := ir.NewConfig().
+		WithReceiveRealIP(ir.ReceiveRealIPConfig{
+			PublicKeyFile: pubPath,
+		})
+
+	reqChan := make(chan ir.ServerRequest, 1)
+	vi, _ := NewVirtualInfrared(cfg, false)
+	vi.vir.NewServerRequesterFunc = func([]*ir.Server) (ir.ServerRequester, error) {
+		return ir.ServerRequesterFunc(func(req ir.ServerRequest) (ir.ServerResponse, error) {
+			reqChan <- req
+			return ir.ServerResponse{}, ir.ErrServerNotFound
+		}), nil
+	}
+	go vi.MustListenAndServe(t)
+
+	tt := []struct {
+		name   string
+		addr   string
+		wantOK bool
+	}{
+		{
+			name:   "valid",
+			addr:   realIPAddr(t, time.Now(), signWith(key)),
+			wantOK: true,
+		},
+		{
+			name: "invalid signature",
+			addr: realIPAddr(t, time.Now(), signWith(otherKey)),
+		},
+		{
+			name: "stale timestamp",
+			addr: realIPAddr(t, time.Now().Add(-time.Minute), signWith(key)),
+		},
+		{
+			name: "no RealIP",
+			addr: "example.com",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			vc := vi.NewConn(nil)
+			defer vc.Close()
+
+			go func() {
+				_ = vc.SendStatusRequest(tc.addr, protocol.Version1_20_2)
+			}()
+
+			// The connection is closed either way, because no server is found
+			_, _ = io.Copy(io.Discard, vc)
+
+			var req ir.ServerRequest
+			select {
+			case req = <-reqChan:
+			default:
+				if tc.wantOK {
+					t.Fatal("got: rejected handshake; want: request")
+				}
+				return
+			}
+
+			if !tc.wantOK {
+				t.Fatal("got: request; want: rejected handshake")
+			}
+
+			if req.ClientAddr.String() != playerAddr.String() {
+				t.Fatalf("got: %s; want: %s", req.ClientAddr, playerAddr)
+			}
+
+			var hs handshaking.ServerBoundHandshake
+			if err := hs.Unmarshal(req.ReadPackets[0]); err != nil {
+				t.Fatal(err)
+			}
+
+			wantAddr := "example.com" + handshaking.SeparatorForge + "FML2" + handshaking.SeparatorForge
+			if string(hs.ServerAddress) != wantAddr {
+				t.Fatalf("got: %q; want: %q", hs.ServerAddress, wantAddr)
+			}
+		})
+	}
+}
+
+// listenHandshakeServer starts a server that sends the handshake of every connection to the returned channel.
+func listenHandshakeServer(t *testing.T) (net.Listener, <-chan handshaking.ServerBoundHandshake) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+
+	hsChan := make(chan handshaking.ServerBoundHandshake, 1)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			var pk protocol.Packet
+			if _, err := pk.ReadFrom(c); err != nil {
+				_ = c.Close()
+				continue
+			}
+
+			var hs handshaking.ServerBoundHandshake
+			if err := hs.Unmarshal(pk); err == nil {
+				hsChan <- hs
+			}
+			_ = c.Close()
+		}
+	}()
+
+	return l, hsChan
+}
+
+// login sends a login request through Infrared and waits until the connection is closed.
+func (vi *VirtualInfrared) login(t *testing.T, addr string, ls login.ServerBoundLoginStart, v protocol.Version) {
+	t.Helper()
+
+	vc := vi.NewConn(nil)
+	defer vc.Close()
+
+	if err := vc.SendHandshake(handshaking.ServerBoundHandshake{
+		ProtocolVersion: protocol.VarInt(v),
+		ServerAddress:   protocol.String(addr),
+		ServerPort:      25565,
+		NextState:       handshaking.StateLoginServerBoundHandshake,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := vc.SendLoginStart(ls, v); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = io.Copy(io.Discard, vc)
+}
+
+func TestInfrared_BungeeCordForwarding(t *testing.T) {
+	l, hsChan := listenHandshakeServer(t)
+	playerUUID := uuid.MustParse("069a79f4-44e9-4726-a5be-fca90e38aaf5")
+	forgeAddr := "example.com" + handshaking.SeparatorForge + "FML2" + handshaking.SeparatorForge
+
+	tt := []struct {
+		name       string
+		cfg        ir.BungeeCordForwardingConfig
+		addr       string
+		loginStart login.ServerBoundLoginStart
+		version    protocol.Version
+		wantAddr   string
+	}{
+		{
+			name: "uuid of client",
+			addr: forgeAddr,
+			loginStart: login.ServerBoundLoginStart{
+				Name:       "Notch",
+				PlayerUUID: protocol.UUID(playerUUID),
+			},
+			version:  protocol.Version1_20_2,
+			wantAddr: "example.com\x00127.0.0.1\x00069a79f444e94726a5befca90e38aaf5",
+		},
+		{
+			name: "offline uuid",
+			addr: "example.com",
+			loginStart: login.ServerBoundLoginStart{
+				Name: "Notch",
+			},
+			version:  protocol.Version1_18_2,
+			wantAddr: "example.com\x00127.0.0.1\x00b50ad385829d3141a2167e7d7539ba7f",
+		},
+		{
+			name: "bungeeguard token",
+			cfg: ir.BungeeCordForwardingConfig{
+				BungeeGuardToken: "secret",
+			},
+			addr: "example.com",
+			loginStart: login.ServerBoundLoginStart{
+				Name:       "Notch",
+				PlayerUUID: protocol.UUID(playerUUID),
+			},
+			version:  protocol.Version1_20_2,
+			wantAddr: "example.com\x00127.0.0.1\x00069a79f444e94726a5befca90e38aaf5\x00" + `[{"name":"bungeeguard-token","value":"secret"}]`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := ir.NewConfig().
+				AddServerConfig(
+					ir.WithServerDomains("example.com"),
+					ir.WithServerAddresses(ir.ServerAddress(l.Addr().String())),
+					ir.WithServerBungeeCordForwarding(tc.cfg),
+				)
+
+			vi, _ := NewVirtualInfrared(cfg, false)
+			vi.vir.NewServerRequesterFunc = nil
+			go vi.MustListenAndServe(t)
+
+			vi.login(t, tc.addr, tc.loginStart, tc.version)
+
+			hs := <-hsChan
+			if string(hs.ServerAddress) != tc.wantAddr {
+				t.Fatalf("got: %q; want: %q", hs.ServerAddress, tc.wantAddr)
+			}
+		})
+	}
+}
+
+// listenVelocityServer starts a server that requests the Velocity player info of every login,
+// sends the response to the returned channel and then completes the login.
+func listenVelocityServer(t *testing.T) (net.Listener, <-chan login.ServerBoundLoginPluginResponse) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+
+	respChan := make(chan login.ServerBoundLoginPluginResponse, 1)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			func() {
+				defer c.Close()
+
+				r := bufio.NewReader(c)
+				var pk protocol.Packet
+				for i := 0; i < 2; i++ {
+					if _, err := pk.ReadFrom(r); err != nil {
+						return
+					}
+				}
+
+				_ = login.ClientBoundLoginPluginRequest{
+					MessageID: 7,
+					Channel:   "velocity:player_info",
+					Data:      []byte{0x01},
+				}.Marshal(&pk)
+				if _, err := pk.WriteTo(c); err != nil {
+					return
+				}
+
+				if _, err := pk.ReadFrom(r); err != nil {
+					return
+				}
+
+				var resp login.ServerBoundLoginPluginResponse
+				if err := resp.Unmarshal(pk); err != nil {
+					return
+				}
+				respChan <- resp
+
+				pk = protocol.Packet{ID: login.ClientBoundLoginSuccessID}
+				_, _ = pk.WriteTo(c)
+			}()
+		}
+	}()
+
+	return l, respChan
+}
+
+func TestInfrared_VelocityForwarding(t *testing.T) {
+	l, respChan := listenVelocityServer(t)
+	playerUUID := uuid.MustParse("069a79f4-44e9-4726-a5be-fca90e38aaf5")
+
+	secretFile := filepath.Join(t.TempDir(), "forwarding.secret")
+	if err := os.WriteFile(secretFile, []byte("file-secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name       string
+		cfg        ir.VelocityForwardingConfig
+		loginStart login.ServerBoundLoginStart
+		version    protocol.Version
+		wantSecret string
+		wantUUID   uuid.UUID
+	}{
+		{
+			name: "uuid of client",
+			cfg: ir.VelocityForwardingConfig{
+				Secret: "secret",
+			},
+			loginStart: login.ServerBoundLoginStart{
+				Name:       "Notch",
+				PlayerUUID: protocol.UUID(playerUUID),
+			},
+			version:    protocol.Version1_20_2,
+			wantSecret: "secret",
+			wantUUID:   playerUUID,
+		},
+		{
+			name: "offline uuid from secret file",
+			cfg: ir.VelocityForwardingConfig{
+				SecretFile: secretFile,
+			},
+			loginStart: login.ServerBoundLoginStart{
+				Name: "Notch",
+			},
+			version:    protocol.Version1_18_2,
+			wantSecret: "file-secret",
+			wantUUID:   uuid.MustParse("b50ad385-829d-3141-a216-7e7d7539ba7f"),
+		},
+		{
+			name: "version with unknown play packets",
+			cfg: ir.VelocityForwardingConfig{
+				Secret: "secret",
+			},
+			loginStart: login.ServerBoundLoginStart{
+				Name:       "Notch",
+				PlayerUUID: protocol.UUID(playerUUID),
+			},
+			// 1.20.3
+			version:    protocol.Version(765),
+			wantSecret: "secret",
+			wantUUID:   playerUUID,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := ir.NewConfig().
+				AddServerConfig(
+					ir.WithServerDomains("example.com"),
+					ir.WithServerAddresses(ir.ServerAddress(l.Addr().String())),
+					ir.WithServerVelocityForwarding(tc.cfg),
+				)
+
+			vi, _ := NewVirtualInfrared(cfg, false)
+			vi.vir.NewServerRequesterFunc = nil
+			go vi.MustListenAndServe(t)
+
+			vc := vi.NewConn(nil)
+			defer vc.Close()
+
+			if err := vc.SendHandshake(handshaking.ServerBoundHandshake{
+				ProtocolVersion: protocol.VarInt(tc.version),
+				ServerAddress:   "example.com",
+				ServerPort:      25565,
+				NextState:       handshaking.StateLoginServerBoundHandshake,
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := vc.SendLoginStart(tc.loginStart, tc.version); err != nil {
+				t.Fatal(err)
+			}
+
+			// The player info request is answered by Infrared and not relayed to the client
+			var pk protocol.Packet
+			if _, err := pk.ReadFrom(vc); err != nil {
+				t.Fatal(err)
+			}
+			if pk.ID != login.ClientBoundLoginSuccessID {
+				t.Fatalf("got: %#x; want: %#x", pk.ID, login.ClientBoundLoginSuccessID)
+			}
+
+			resp := <-respChan
+			if resp.MessageID != 7 || !resp.Successful {
+				t.Fatalf("got: message %d successful %v; want: message 7 successful true", resp.MessageID, resp.Successful)
+			}
+
+			if len(resp.Data) < sha256.Size {
+				t.Fatalf("got: %d bytes; want: more than %d", len(resp.Data), sha256.Size)
+			}
+			sig, data := resp.Data[:sha256.Size], resp.Data[sha256.Size:]
+			mac := hmac.New(sha256.New, []byte(tc.wantSecret))
+			_, _ = mac.Write(data)
+			if !hmac.Equal(sig, mac.Sum(nil)) {
+				t.Fatal("invalid signature")
+			}
+
+			var (
+				version    protocol.VarInt
+				addr       protocol.String
+				id         protocol.UUID
+				name       protocol.String
+				properties protocol.VarInt
+			)
+			if err := protocol.ScanFields(bytes.NewReader(data), &version, &addr, &id, &name, &properties); err != nil {
+				t.Fatal(err)
+			}
+
+			if version != 1 || addr != "127.0.0.1" || uuid.UUID(id) != tc.wantUUID || name != "Notch" || properties != 0 {
+				t.Fatalf("got: %d %s %s %s %d; want: 1 127.0.0.1 %s Notch 0",
+					version, addr, uuid.UUID(id), name, properties, tc.wantUUID)
+			}
+		})
+	}
+}
+
+func TestInfrared_Reload_ReadsChangedFiles(t *testing.T) {
+	l, respChan := listenVelocityServer(t)
+	secretFile := writeTempFile(t, "forwarding.secret", "old-secret")
+
+	cfg := ir.NewConfig().
+		AddServerConfig(
+			ir.WithServerDomains("example.com"),
+			ir.WithServerAddresses(ir.ServerAddress(l.Addr().String())),
+			ir.WithServerVelocityForwarding(ir.VelocityForwardingConfig{
+				SecretFile: secretFile,
+			}),
+		)
+
+	vi, _ := NewVirtualInfrared(cfg, false)
+	vi.vir.NewServerRequesterFunc = nil
+	go vi.MustListenAndServe(t)
+
+	loginSecretMatches := func(secret string) bool {
+		t.Helper()
+
+		vc := vi.NewConn(nil)
+		defer vc.Close()
+
+		if err := vc.SendHandshake(handshaking.ServerBoundHandshake{
+			ProtocolVersion: protocol.VarInt(protocol.Version1_20_2),
+			ServerAddress:   "example.com",
+			ServerPort:      25565,
+			NextState:       handshaking.StateLoginServerBoundHandshake,
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := vc.SendLoginStart(login.ServerBoundLoginStart{
+			Name: "Notch",
+		}, protocol.Version1_20_2); err != nil {
+			t.Fatal(err)
+		}
+
+		resp := <-respChan
+		if len(resp.Data) < sha256.Size {
+			t.Fatalf("got: %d bytes; want: more than %d", len(resp.Data), sha256.Size)
+		}
+
+		mac := hmac.New(sha256.New, []byte(secret))
+		_, _ = mac.Write(resp.Data[sha256.Size:])
+		return hmac.Equal(resp.Data[:sha256.Size], mac.Sum(nil))
+	}
+
+	if !loginSecretMatches("old-secret") {
+		t.Fatal("got: other secret; want: old secret")
+	}
+
+	// Only the file changes, not the config
+	if err := os.WriteFile(secretFile, []byte("new-secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := vi.vir.Reload(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if !loginSecretMatches("new-secret") {
+		t.Fatal("got: other secret; want: new secret")
+	}
+}
+
+// syncBuffer is a bytes.Buffer that is safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestInfrared_LogsFailedFilterReloads(t *testing.T) {
+	tt := []struct {
+		name string
+		// filter configures a filter that loads the file at path
+		filter  func(cfg *ir.FiltersConfig, path string)
+		file    string
+		wantMsg string
+	}{
+		{
+			name: "ip filter deny file",
+			filter: func(cfg *ir.FiltersConfig, path string) {
+				cfg.IPFilter = &ir.IPFilterConfig{
+					DenyFile:       path,
+					ReloadInterval: time.Millisecond,
+				}
+			},
+			file:    writeTempFile(t, "blocklist.txt", ""),
+			wantMsg: "Failed to reload deny file",
+		},
+		{
+			name: "geoip database",
+			filter: func(cfg *ir.FiltersConfig, path string) {
+				cfg.GeoIP = &ir.GeoIPFilterConfig{
+					CountryDatabase: path,
+					ReloadInterval:  time.Millisecond,
+				}
+			},
+			file: writeMMDB(t, "country.mmdb", map[string]map[string]any{
+				"10.0.0.0/8": country("US"),
+			}),
+			wantMsg: "Failed to reload GeoIP database",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := ir.NewConfig().
+				AddServerConfig(
+					ir.WithServerDomains("example.com"),
+					ir.WithServerAddresses(closedAddr(t)),
+				)
+			tc.filter(&cfg.FiltersConfig, tc.file)
+
+			vi, _ := NewVirtualInfrared(cfg, false)
+			var logs syncBuffer
+			vi.vir.Logger = zerolog.New(&logs)
+			go vi.MustListenAndServe(t)
+			<-vi.AcceptTick()
+
+			if err := os.WriteFile(tc.file, []byte("invalid"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			// The modification time might not change on file systems with a coarse resolution
+			modTime := time.Now().Add(time.Second)
+			if err := os.Chtimes(tc.file, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+
+			deadline := time.Now().Add(5 * time.Second)
+			for !strings.Contains(logs.String(), tc.wantMsg) {
+				if time.Now().After(deadline) {
+					t.Fatalf("got: logs %q; want: %q", logs.String(), tc.wantMsg)
+				}
+
+				vc := vi.NewConn(&net.TCPAddr{
+					IP:   net.IPv4(127, 0, 0, 1),
+					Port: 25565,
+				})
+				_ = vc.Close()
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			if !strings.Contains(logs.String(), tc.file) {
+				t.Errorf("got: logs %q; want: path %q", logs.String(), tc.file)
+			}
+		})
+	}
+}
+
+// encryptedConn is the encrypted connection of a client in online mode.
+type encryptedConn struct {
+	io.Reader
+	io.Writer
+}
+
+// loginOnline performs the encryption handshake like a client.
+// Like a client, it calls join with the server hash before it answers the encryption request.
+// If tamper is not nil, it can change the encryption response before it is sent.
+// The returned connection encrypts everything after the encryption response.
+func loginOnline(
+	t *testing.T,
+	vc VirtualConn,
+	name string,
+	join func(serverHash string),
+	tamper func(resp *login.ServerBoundEncryptionResponse),
+) encryptedConn {
+	t.Helper()
+
+	if err := vc.SendHandshake(handshaking.ServerBoundHandshake{
+		ProtocolVersion: protocol.VarInt(protocol.Version1_20_2),
+		ServerAddress:   "example.com",
+		ServerPort:      25565,
+		NextState:       handshaking.StateLoginServerBoundHandshake,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := vc.SendLoginStart(login.ServerBoundLoginStart{
+		Name: protocol.String(name),
+	}, protocol.Version1_20_2); err != nil {
+		t.Fatal(err)
+	}
+
+	var pk protocol.Packet
+	if _, err := pk.ReadFrom(vc); err != nil {
+		t.Fatal(err)
+	}
+
+	var encReq login.ClientBoundEncryptionRequest
+	if err := encReq.Unmarshal(pk); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(encReq.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sharedSecret := make([]byte, 16)
+	_, _ = rand.Read(sharedSecret)
+	encSecret, err := rsa.EncryptPKCS1v15(rand.Reader, key.(*rsa.PublicKey), sharedSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encToken, err := rsa.EncryptPKCS1v15(rand.Reader, key.(*rsa.PublicKey), encReq.VerifyToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	join(login.AuthDigest(string(encReq.ServerID), sharedSecret, encReq.PublicKey))
+
+	encResp := login.ServerBoundEncryptionResponse{
+		SharedSecret:   encSecret,
+		HasVerifyToken: true,
+		VerifyToken:    encToken,
+	}
+	if tamper != nil {
+		tamper(&encResp)
+	}
+
+	if err := encResp.Marshal(&pk, protocol.Version1_20_2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pk.WriteTo(vc); err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := aes.NewCipher(sharedSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return encryptedConn{
+		Reader: cipher.StreamReader{S: protocol.NewCFB8Decrypter(block, sharedSecret), R: vc},
+		Writer: cipher.StreamWriter{S: protocol.NewCFB8Encrypter(block, sharedSecret), W: vc},
+	}
+}
+
+func TestInfrared_OnlineMode(t *testing.T) {
+	l, hsChan := listenHandshakeServer(t)
+
+	// joined is the server hash that the session server accepts
+	var joined atomic.Value
+	sessionServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/session/minecraft/hasJoined" ||
+			r.URL.Query().Get("username") != "Notch" ||
+			r.URL.Query().Get("serverId") != joined.Load() {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"id":"069a79f444e94726a5befca90e38aaf5","name":"Notch",` +
+			`"properties":[{"name":"textures","value":"skin","signature":"sig"}]}`))
+	}))
+	t.Cleanup(sessionServer.Close)
+
+	tt := []struct {
+		name       string
+		join       bool
+		tamper     func(resp *login.ServerBoundEncryptionResponse)
+		wantAddr   string
+		wantReason string
+		// wantClosed is set if the connection is closed without a disconnect packet
+		wantClosed bool
+	}{
+		{
+			name:     "authenticated",
+			join:     true,
+			wantAddr: "example.com\x00127.0.0.1\x00069a79f444e94726a5befca90e38aaf5\x00" + `[{"name":"textures","value":"skin","signature":"sig"}]`,
+		},
+		{
+			name:       "not joined",
+			wantReason: `"Failed to verify username!"`,
+		},
+		{
+			name: "invalid verify token",
+			join: true,
+			tamper: func(resp *login.ServerBoundEncryptionResponse) {
+				resp.VerifyToken = resp.SharedSecret
+			},
+			wantReason: `"Failed to verify username!"`,
+		},
+		{
+			name: "invalid shared secret",
+			join: true,
+			tamper: func(resp *login.ServerBoundEncryptionResponse) {
+				resp.SharedSecret = []byte("invalid")
+			},
+			wantClosed: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := ir.NewConfig().
+				WithOnlineMode(ir.OnlineModeConfig{
+					SessionServerURL: sessionServer.URL,
+				}).
+				AddServerConfig(
+					ir.WithServerDomains("example.com"),
+					ir.WithServerAddresses(ir.ServerAddress(l.Addr().String())),
+					ir.WithServerBungeeCordForwarding(ir.BungeeCordForwardingConfig{}),
+				)
+
+			vi, _ := NewVirtualInfrared(cfg, false)
+			vi.vir.NewServerRequesterFunc = nil
+			go vi.MustListenAndServe(t)
+
+			vc := vi.NewConn(nil)
+			defer vc.Close()
+
+			ec := loginOnline(t, vc, "Notch", func(serverHash string) {
+				if tc.join {
+					joined.Store(serverHash)
+				} else {
+					joined.Store("")
+				}
+			}, tc.tamper)
+
+			if tc.wantAddr != "" {
+				hs := <-hsChan
+				if string(hs.ServerAddress) != tc.wantAddr {
+					t.Fatalf("got: %q; want: %q", hs.ServerAddress, tc.wantAddr)
+				}
+				return
+			}
+
+			var pk protocol.Packet
+			if _, err := pk.ReadFrom(ec); tc.wantClosed {
+				if !errors.Is(err, io.EOF) {
+					t.Fatalf("got: %v; want: %v", err, io.EOF)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			var reason protocol.Chat
+			if err := pk.Decode(&reason); err != nil {
+				t.Fatal(err)
+			}
+
+			if pk.ID != login.ClientBoundDisconnectID || string(reason) != tc.wantReason {
+				t.Fatalf("got: %#x %s; want: %#x %s", pk.ID, reason, login.ClientBoundDisconnectID, tc.wantReason)
+			}
+		})
+	}
+}
+
+// loginDisconnectReason logs in through Infrared and returns the disconnect reason.
+// If the player is not disconnected by Infrared, an empty string is returned.
+func (vi *VirtualInfrared) loginDisconnectReason(
+	t *testing.T,
+	remoteAddr net.Addr,
+	domain string,
+	ls login.ServerBoundLoginStart,
+) string {
+	t.Helper()
+
+	vc := vi.NewConn(remoteAddr)
+	defer vc.Close()
+
+	if err := vc.SendHandshake(handshaking.ServerBoundHandshake{
+		ProtocolVersion: protocol.VarInt(protocol.Version1_20_2),
+		ServerAddress:   protocol.String(domain),
+		ServerPort:      25565,
+		NextState:       handshaking.StateLoginServerBoundHandshake,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := vc.SendLoginStart(ls, protocol.Version1_20_2); err != nil {
+		t.Fatal(err)
+	}
+
+	var pk protocol.Packet
+	if _, err := pk.ReadFrom(vc); err != nil {
+		return ""
+	}
+
+	if pk.ID != login.ClientBoundDisconnectID {
+		t.Fatalf("got: packet id %d; want: %d", pk.ID, login.ClientBoundDisconnectID)
+	}
+
+	var reason protocol.Chat
+	if err := pk.Decode(&reason); err != nil {
+		t.Fatal(err)
+	}
+
+	return string(reason)
+}
+
+func writeTempFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestInfrared_PlayerLists(t *testing.T) {
+	l, hsChan := listenHandshakeServer(t)
+	notchUUID := uuid.MustParse("069a79f4-44e9-4726-a5be-fca90e38aaf5")
+
+	cfg := ir.NewConfig().
+		WithPlayerLists(ir.PlayerListsConfig{
+			Whitelist: writeTempFile(t, "whitelist.json", `[
+				{"uuid": "069a79f4-44e9-4726-a5be-fca90e38aaf5", "name": "Notch"},
+				{"name": "jeb_"},
+				{"ip": "10.0.0.0/8"}
+			]`),
+			BannedPlayers: writeTempFile(t, "banned-players.json", `[
+				{"name": "jeb_", "expires": "forever", "reason": "Griefing"},
+				{"name": "Notch", "expires": "2000-01-01 00:00:00 +0000", "reason": "Expired"}
+			]`),
+			BannedIPs: writeTempFile(t, "banned-ips.json", `[
+				{"ip": "192.168.0.0/16", "expires": "2999-01-01 00:00:00 +0000", "reason": "Spam"}
+			]`),
+		}).
+		AddServerConfig(
+			ir.WithServerDomains("example.com"),
+			ir.WithServerAddresses(ir.ServerAddress(l.Addr().String())),
+		).
+		AddServerConfig(
+			ir.WithServerDomains("other.com"),
+			ir.WithServerAddresses(closedAddr(t)),
+			ir.WithServerPlayerLists(ir.PlayerListsConfig{
+				BannedPlayers: writeTempFile(t, "banned-players.json", `[
+					{"uuid": "069a79f4-44e9-4726-a5be-fca90e38aaf5", "name": "Notch"}
+				]`),
+			}),
+			ir.WithServerDisconnectMessages(ir.DisconnectMessagesConfig{
+				Banned: map[string]any{"text": "Banned: {reason}"},
+			}),
+		)
+
+	vi, _ := NewVirtualInfrared(cfg, false)
+	vi.vir.NewServerRequesterFunc = nil
+	go vi.MustListenAndServe(t)
+
+	tt := []struct {
+		name       string
+		remoteAddr net.Addr
+		domain     string
+		loginStart login.ServerBoundLoginStart
+		wantReason string
+	}{
+		{
+			name:   "whitelisted with expired ban",
+			domain: "example.com",
+			loginStart: login.ServerBoundLoginStart{
+				Name:       "Notch",
+				PlayerUUID: protocol.UUID(notchUUID),
+			},
+		},
+		{
+			name:   "not whitelisted",
+			domain: "example.com",
+			loginStart: login.ServerBoundLoginStart{
+				Name: "Dinnerbone",
+			},
+			wantReason: `"You are not whitelisted on this server!"`,
+		},
+		{
+			name:   "not whitelisted with spoofed uuid",
+			domain: "example.com",
+			loginStart: login.ServerBoundLoginStart{
+				Name:       "Dinnerbone",
+				PlayerUUID: protocol.UUID(notchUUID),
+			},
+			wantReason: `"You are not whitelisted on this server!"`,
+		},
+		{
+			name:   "whitelisted by ip",
+			domain: "example.com",
+			remoteAddr: &net.TCPAddr{
+				IP:   net.IPv4(10, 1, 2, 3),
+				Port: 25565,
+			},
+			loginStart: login.ServerBoundLoginStart{
+				Name: "Dinnerbone",
+			},
+		},
+		{
+			name:   "banned player",
+			domain: "example.com",
+			loginStart: login.ServerBoundLoginStart{
+				Name: "jeb_",
+			},
+			wantReason: `"You are banned from this server.\nReason: Griefing\nYour ban expires: never"`,
+		},
+		{
+			name:   "banned ip with expiry",
+			domain: "example.com",
+			remoteAddr: &net.TCPAddr{
+				IP:   net.IPv4(192, 168, 1, 2),
+				Port: 25565,
+			},
+			loginStart: login.ServerBoundLoginStart{
+				Name:       "Notch",
+				PlayerUUID: protocol.UUID(notchUUID),
+			},
+			wantReason: `"You are banned from this server.\nReason: Spam\nYour ban expires: 2999-01-01 00:00:00 +0000"`,
+		},
+		{
+			name:   "banned on proxy",
+			domain: "other.com",
+			loginStart: login.ServerBoundLoginStart{
+				Name:       "Notch",
+				PlayerUUID: protocol.UUID(notchUUID),
+			},
+			wantReason: `{"text":"Banned: Banned by an operator."}`,
+		},
+		{
+			name:   "banned on proxy with spoofed uuid",
+			domain: "other.com",
+			loginStart: login.ServerBoundLoginStart{
+				Name:       "Notch",
+				PlayerUUID: protocol.UUID(uuid.MustParse("853c80ef-3c37-49fd-aa49-938b674adae6")),
+			},
+			wantReason: `{"text":"Banned: Banned by an operator."}`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			reason := vi.loginDisconnectReason(t, tc.remoteAddr, tc.domain, tc.loginStart)
+			if reason != tc.wantReason {
+				t.Fatalf("got: %s; want: %s", reason, tc.wantReason)
+			}
+
+			if tc.wantReason == "" {
+				<-hsChan
+			}
+		})
+	}
+}
+
+func TestInfrared_PlayerLists_Reload(t *testing.T) {
+	l, hsChan := listenHandshakeServer(t)
+	whitelist := writeTempFile(t, "whitelist.json", `[]`)
+
+	cfg := ir.NewConfig().
+		WithPlayerLists(ir.PlayerListsConfig{
+			Whitelist: whitelist,
+		}).
+		AddServerConfig(
+			ir.WithServerDomains("example.com"),
+			ir.WithServerAddresses(ir.ServerAddress(l.Addr().String())),
+		)
+
+	vi, _ := NewVirtualInfrared(cfg, false)
+	vi.vir.NewServerRequesterFunc = nil
+	go vi.MustListenAndServe(t)
+
+	ls := login.ServerBoundLoginStart{
+		Name: "Notch",
+	}
+	if reason := vi.loginDisconnectReason(t, nil, "example.com", ls); reason == "" {
+		t.Fatal("got: login; want: not whitelisted")
+	}
+
+	if err := os.WriteFile(whitelist, []byte(`[{"name": "Notch"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for vi.loginDisconnectReason(t, nil, "example.com", ls) != "" {
+		if time.Now().After(deadline) {
+			t.Fatal("whitelist was not reloaded")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	<-hsChan
+}
+
+func TestInfrared_RequestFilters(t *testing.T) {
+	l, hsChan := listenHandshakeServer(t)
+
+	cfg := ir.NewConfig().
+		WithDisconnectMessages(ir.DisconnectMessagesConfig{
+			IPDenied: "Your IP is denied",
+		}).
+		WithRequestFilterers(ir.RequestFilterFunc(func(req ir.ServerRequest) error {
+			if req.PlayerName == "Herobrine" {
+				return ir.DisconnectError{
+					Reason: "No Herobrine",
+					Err:    errors.New("herobrine"),
+				}
+			}
+			return nil
+		})).
+		AddServerConfig(
+			ir.WithServerDomains("example.com"),
+			ir.WithServerAddresses(ir.ServerAddress(l.Addr().String())),
+			ir.WithServerFilters(ir.RequestFiltersConfig{
+				IPFilter: &ir.IPFilterConfig{
+					Deny: []string{"10.0.0.0/8"},
+				},
+				PlayerName: &ir.PlayerNameFilterConfig{
+					Pattern: `^[a-zA-Z0-9_]{3,16}$`,
+					Deny:    []string{"*bot*"},
+				},
+			}),
+		).
+		AddServerConfig(
+			ir.WithServerDomains("other.com"),
+			ir.WithServerAddresses(closedAddr(t)),
+			ir.WithServerRequestFilterers(ir.RequestFilterFunc(func(req ir.ServerRequest) error {
+				if req.Domain == "other.com" && req.ProtocolVersion == protocol.Version1_20_2 {
+					return errors.New("filtered")
+				}
+				return nil
+			})),
+			ir.WithServerDisconnectMessages(ir.DisconnectMessagesConfig{
+				Filtered: "Go away",
+			}),
+		)
+
+	vi, _ := NewVirtualInfrared(cfg, false)
+	vi.vir.NewServerRequesterFunc = nil
+	go vi.MustListenAndServe(t)
+
+	tt := []struct {
+		name       string
+		remoteAddr net.Addr
+		domain     string
+		playerName string
+		wantReason string
+	}{
+		{
+			name:       "allowed",
+			domain:     "example.com",
+			playerName: "Notch",
+		},
+		{
+			name:       "invalid name",
+			domain:     "example.com",
+			playerName: "No Tch",
+			wantReason: `"You are not allowed to join this server."`,
+		},
+		{
+			name:       "denied name",
+			domain:     "example.com",
+			playerName: "SpamBot42",
+			wantReason: `"You are not allowed to join this server."`,
+		},
+		{
+			name: "denied ip",
+			remoteAddr: &net.TCPAddr{
+				IP:   net.IPv4(10, 1, 2, 3),
+				Port: 25565,
+			},
+			domain:     "example.com",
+			playerName: "Notch",
+			wantReason: `"Your IP is denied"`,
+		},
+		{
+			name:       "global filter",
+			domain:     "example.com",
+			playerName: "Herobrine",
+			wantReason: `"No Herobrine"`,
+		},
+		{
+			name:       "proxy filter",
+			domain:     "other.com",
+			playerName: "Notch",
+			wantReason: `"Go away"`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			reason := vi.loginDisconnectReason(t, tc.remoteAddr, tc.domain, login.ServerBoundLoginStart{
+				Name: protocol.String(tc.playerName),
+			})
+			if reason != tc.wantReason {
+				t.Fatalf("got: %s; want: %s", reason, tc.wantReason)
+			}
+
+			if tc.wantReason == "" {
+				<-hsChan
+			}
+		})
+	}
+}
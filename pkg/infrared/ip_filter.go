@@ -0,0 +1,233 @@
+package infrared
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+var ErrIPDenied = errors.New("IP address denied")
+
+const defaultIPFilterReloadInterval = time.Minute
+
+type IPFilterConfig struct {
+	// Allow only lets addresses in these CIDRs through if it is not empty
+	Allow []string `yaml:"allow"`
+	// Deny drops addresses in these CIDRs
+	Deny []string `yaml:"deny"`
+	// DenyFile is the path to a blocklist with one IP address or CIDR per line.
+	// Empty lines and lines starting with '#' are ignored.
+	DenyFile string `yaml:"denyFile"`
+	// ReloadInterval is the interval in which the deny file is checked for changes.
+	// Defaults to 1m.
+	ReloadInterval time.Duration `yaml:"reloadInterval"`
+}
+
+type ipRule int8
+
+const (
+	ipRuleNone ipRule = iota
+	ipRuleAllow
+	ipRuleDeny
+)
+
+// ipTrie is a binary prefix trie of IP addresses.
+// IPv4 addresses are stored as IPv4-mapped IPv6 addresses.
+type ipTrie struct {
+	root ipTrieNode
+}
+
+type ipTrieNode struct {
+	children [2]*ipTrieNode
+	rule     ipRule
+}
+
+// insert adds the rule for prefix. Deny rules win over allow rules of the same prefix.
+func (t *ipTrie) insert(prefix netip.Prefix, rule ipRule) {
+	addr := prefix.Addr()
+	bits := prefix.Bits()
+	if addr.Is4() {
+		addr = netip.AddrFrom16(addr.As16())
+		bits += 96
+	}
+
+	ip := addr.As16()
+	n := &t.root
+	for i := 0; i < bits; i++ {
+		b := ip[i/8] >> (7 - i%8) & 1
+		if n.children[b] == nil {
+			n.children[b] = &ipTrieNode{}
+		}
+		n = n.children[b]
+	}
+
+	if n.rule != ipRuleDeny {
+		n.rule = rule
+	}
+}
+
+// lookup returns the rule of the longest prefix that contains addr.
+func (t *ipTrie) lookup(addr netip.Addr) ipRule {
+	ip := addr.As16()
+	n := &t.root
+	rule := n.rule
+	for i := 0; i < 128; i++ {
+		n = n.children[ip[i/8]>>(7-i%8)&1]
+		if n == nil {
+			break
+		}
+
+		if n.rule != ipRuleNone {
+			rule = n.rule
+		}
+	}
+
+	return rule
+}
+
+// parsePrefix parses s as a CIDR or as a single IP address.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// ipFilter drops connections by the address of the client.
+// The most specific allow or deny rule that contains the address decides.
+// If no rule contains the address, it is only allowed if there are no allow rules.
+type ipFilter struct {
+	cfg          IPFilterConfig
+	defaultAllow bool
+	trie         atomic.Pointer[ipTrie]
+	// denyFile is nil if no deny file is configured
+	denyFile *fileReloader
+}
+
+// IPFilter returns a Filterer that drops connections by the IP address of the client.
+// The most specific CIDR that contains the address decides if it is allowed or denied.
+func IPFilter(cfg IPFilterConfig) (Filterer, error) {
+	return newIPFilter(cfg, nil)
+}
+
+// newIPFilter is like IPFilter, but logs failed reloads of the deny file to logger if it is not nil.
+func newIPFilter(cfg IPFilterConfig, logger *zerolog.Logger) (Filterer, error) {
+	if cfg.ReloadInterval <= 0 {
+		cfg.ReloadInterval = defaultIPFilterReloadInterval
+	}
+
+	f := &ipFilter{
+		cfg:          cfg,
+		defaultAllow: len(cfg.Allow) == 0,
+	}
+
+	if cfg.DenyFile == "" {
+		if err := f.load(); err != nil {
+			return nil, err
+		}
+	} else {
+		r, err := newFileReloader(cfg.DenyFile, "deny file", cfg.ReloadInterval, f.load, logger)
+		if err != nil {
+			return nil, err
+		}
+		f.denyFile = r
+	}
+
+	return FilterFunc(f.filter), nil
+}
+
+// load builds the trie from the config and the deny file.
+func (f *ipFilter) load() error {
+	var t ipTrie
+	for _, rules := range []struct {
+		cidrs []string
+		rule  ipRule
+	}{
+		{f.cfg.Allow, ipRuleAllow},
+		{f.cfg.Deny, ipRuleDeny},
+	} {
+		for _, s := range rules.cidrs {
+			prefix, err := parsePrefix(s)
+			if err != nil {
+				return err
+			}
+			t.insert(prefix, rules.rule)
+		}
+	}
+
+	if f.cfg.DenyFile != "" {
+		if err := loadDenyFile(&t, f.cfg.DenyFile); err != nil {
+			return err
+		}
+	}
+
+	f.trie.Store(&t)
+	return nil
+}
+
+func loadDenyFile(t *ipTrie, path string) error {
+	bb, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(bb))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		prefix, err := parsePrefix(line)
+		if err != nil {
+			return fmt.Errorf("line %d of %q: %w", n, path, err)
+		}
+		t.insert(prefix, ipRuleDeny)
+	}
+
+	return s.Err()
+}
+
+func (f *ipFilter) filter(c net.Conn) error {
+	f.denyFile.reloadIfDue()
+
+	addr, err := netip.ParseAddrPort(c.RemoteAddr().String())
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrIPDenied, err)
+	}
+
+	ip := addr.Addr().Unmap()
+	if ip.Is4() {
+		ip = netip.AddrFrom16(ip.As16())
+	}
+
+	switch f.trie.Load().lookup(ip) {
+	case ipRuleAllow:
+		return nil
+	case ipRuleDeny:
+		return fmt.Errorf("%w: %s", ErrIPDenied, addr.Addr())
+	default:
+		if f.defaultAllow {
+			return nil
+		}
+		return fmt.Errorf("%w: %s", ErrIPDenied, addr.Addr())
+	}
+}
@@ -0,0 +1,239 @@
+package infrared_test
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	ir "github.com/haveachin/infrared/pkg/infrared"
+)
+
+func filterIP(t *testing.T, f ir.Filterer, ip string) error {
+	t.Helper()
+
+	return f.Filter(VirtualConn{
+		remoteAddr: &net.TCPAddr{
+			IP:   net.ParseIP(ip),
+			Port: 25565,
+		},
+	})
+}
+
+func TestIPFilter(t *testing.T) {
+	denyFile := writeTempFile(t, "blocklist.txt", "# bots\n\n192.0.2.1\n198.51.100.0/24\n")
+
+	tt := []struct {
+		name    string
+		cfg     ir.IPFilterConfig
+		ip      string
+		wantErr bool
+	}{
+		{
+			name: "no_rules",
+			ip:   "10.0.0.1",
+		},
+		{
+			name: "denied_ip",
+			cfg: ir.IPFilterConfig{
+				Deny: []string{"10.0.0.1"},
+			},
+			ip:      "10.0.0.1",
+			wantErr: true,
+		},
+		{
+			name: "not_denied_ip",
+			cfg: ir.IPFilterConfig{
+				Deny: []string{"10.0.0.1"},
+			},
+			ip: "10.0.0.2",
+		},
+		{
+			name: "denied_cidr",
+			cfg: ir.IPFilterConfig{
+				Deny: []string{"10.0.0.0/8"},
+			},
+			ip:      "10.13.37.1",
+			wantErr: true,
+		},
+		{
+			name: "not_allowed",
+			cfg: ir.IPFilterConfig{
+				Allow: []string{"10.0.0.0/8"},
+			},
+			ip:      "192.168.0.1",
+			wantErr: true,
+		},
+		{
+			name: "allowed",
+			cfg: ir.IPFilterConfig{
+				Allow: []string{"10.0.0.0/8"},
+			},
+			ip: "10.1.2.3",
+		},
+		{
+			name: "more_specific_deny",
+			cfg: ir.IPFilterConfig{
+				Allow: []string{"10.0.0.0/8"},
+				Deny:  []string{"10.13.37.0/24"},
+			},
+			ip:      "10.13.37.5",
+			wantErr: true,
+		},
+		{
+			name: "more_specific_allow",
+			cfg: ir.IPFilterConfig{
+				Allow: []string{"10.13.37.5"},
+				Deny:  []string{"10.0.0.0/8"},
+			},
+			ip: "10.13.37.5",
+		},
+		{
+			name: "deny_wins_on_same_cidr",
+			cfg: ir.IPFilterConfig{
+				Allow: []string{"10.0.0.0/8"},
+				Deny:  []string{"10.0.0.0/8"},
+			},
+			ip:      "10.0.0.1",
+			wantErr: true,
+		},
+		{
+			name: "mapped_ipv4",
+			cfg: ir.IPFilterConfig{
+				Deny: []string{"10.0.0.0/8"},
+			},
+			ip:      "::ffff:10.0.0.1",
+			wantErr: true,
+		},
+		{
+			name: "ipv6",
+			cfg: ir.IPFilterConfig{
+				Deny: []string{"2001:db8::/32"},
+			},
+			ip:      "2001:db8::1",
+			wantErr: true,
+		},
+		{
+			name: "ipv4_does_not_match_ipv6",
+			cfg: ir.IPFilterConfig{
+				Deny: []string{"0.0.0.0/0"},
+			},
+			ip: "2001:db8::1",
+		},
+		{
+			name: "deny_file_ip",
+			cfg: ir.IPFilterConfig{
+				DenyFile: denyFile,
+			},
+			ip:      "192.0.2.1",
+			wantErr: true,
+		},
+		{
+			name: "deny_file_cidr",
+			cfg: ir.IPFilterConfig{
+				DenyFile: denyFile,
+			},
+			ip:      "198.51.100.42",
+			wantErr: true,
+		},
+		{
+			name: "not_in_deny_file",
+			cfg: ir.IPFilterConfig{
+				DenyFile: denyFile,
+			},
+			ip: "192.0.2.2",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := ir.IPFilter(tc.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = filterIP(t, f, tc.ip)
+			if tc.wantErr && !errors.Is(err, ir.ErrIPDenied) {
+				t.Fatalf("got: %v; want: %v", err, ir.ErrIPDenied)
+			} else if !tc.wantErr && err != nil {
+				t.Fatalf("got: %v; want: nil", err)
+			}
+		})
+	}
+}
+
+func TestIPFilter_InvalidConfig(t *testing.T) {
+	invalidDenyFile := writeTempFile(t, "blocklist.txt", "10.0.0.1\nnot an ip\n")
+
+	tt := []struct {
+		name string
+		cfg  ir.IPFilterConfig
+	}{
+		{
+			name: "invalid_allow",
+			cfg: ir.IPFilterConfig{
+				Allow: []string{"10.0.0.0/33"},
+			},
+		},
+		{
+			name: "invalid_deny",
+			cfg: ir.IPFilterConfig{
+				Deny: []string{"localhost"},
+			},
+		},
+		{
+			name: "missing_deny_file",
+			cfg: ir.IPFilterConfig{
+				DenyFile: "does-not-exist.txt",
+			},
+		},
+		{
+			name: "invalid_deny_file",
+			cfg: ir.IPFilterConfig{
+				DenyFile: invalidDenyFile,
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := ir.IPFilter(tc.cfg); err == nil {
+				t.Fatal("got: nil; want: error")
+			}
+		})
+	}
+}
+
+func TestIPFilter_Reload(t *testing.T) {
+	denyFile := writeTempFile(t, "blocklist.txt", "")
+
+	f, err := ir.IPFilter(ir.IPFilterConfig{
+		DenyFile:       denyFile,
+		ReloadInterval: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := filterIP(t, f, "192.0.2.1"); err != nil {
+		t.Fatalf("got: %v; want: nil", err)
+	}
+
+	if err := os.WriteFile(denyFile, []byte("192.0.2.1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// The modification time might not change on file systems with a coarse resolution
+	modTime := time.Now().Add(time.Second)
+	if err := os.Chtimes(denyFile, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for filterIP(t, f, "192.0.2.1") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("deny file was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
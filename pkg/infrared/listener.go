@@ -0,0 +1,248 @@
+package infrared
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+)
+
+var (
+	ErrDuplicateListener = errors.New("duplicate listener")
+	ErrUnknownListener   = errors.New("unknown listener")
+	ErrUnknownServer     = errors.New("unknown server")
+)
+
+// defaultListenerName is the name of the listener that is created
+// from the bind address of the config if no listeners are configured.
+const defaultListenerName = "default"
+
+type ListenerConfig struct {
+	// Name identifies the listener in the listeners of a server.
+	// Defaults to the bind address.
+	Name          string              `yaml:"name"`
+	Bind          string              `yaml:"bind"`
+	ProxyProtocol ProxyProtocolConfig `yaml:"proxyProtocol"`
+	// ReceiveRealIP verifies RealIP handshakes of an upstream proxy if set
+	ReceiveRealIP *ReceiveRealIPConfig `yaml:"receiveRealIP"`
+	// OnlineMode authenticates players at the session server before they are forwarded if set
+	OnlineMode *OnlineModeConfig `yaml:"onlineMode"`
+	// Filters replace the global filters for connections of this listener if set
+	Filters *FiltersConfig `yaml:"filters"`
+	// Servers limits the listener to the servers with these names.
+	// All servers are available if it is empty.
+	Servers []string `yaml:"servers"`
+}
+
+func (cfg ListenerConfig) name() string {
+	if cfg.Name == "" {
+		return cfg.Bind
+	}
+	return cfg.Name
+}
+
+// listener is a bound address of Infrared with everything that
+// is needed to handle the connections that it accepts.
+type listener struct {
+	cfg ListenerConfig
+	// l is nil while the listener is not bound
+	l      net.Listener
+	filter Filter
+	sr     ServerRequester
+	// realIP is nil if the listener does not receive RealIP handshakes
+	realIP *realIPVerifier
+	// auth is nil if the listener does not authenticate players
+	auth *authenticator
+}
+
+// listenerConfigs returns the listeners of cfg. If no listeners are configured,
+// a single listener is created from the bind address, the PROXY protocol, the RealIP and the online mode config.
+func (cfg Config) listenerConfigs() []ListenerConfig {
+	if len(cfg.Listeners) > 0 {
+		return cfg.Listeners
+	}
+
+	return []ListenerConfig{
+		{
+			Name:          defaultListenerName,
+			Bind:          cfg.BindAddr,
+			ProxyProtocol: cfg.ProxyProtocolConfig,
+			ReceiveRealIP: cfg.ReceiveRealIP,
+			OnlineMode:    cfg.OnlineMode,
+		},
+	}
+}
+
+// newListeners creates the listeners of cfg without binding them.
+// Every listener gets its own server requester for the servers that are available on it.
+func (ir *Infrared) newListeners(cfg Config, srvs []*Server) ([]*listener, error) {
+	lCfgs := cfg.listenerConfigs()
+	globalFilter, err := NewFilter(
+		WithFilterConfig(cfg.FiltersConfig),
+		withOnCounterError(ir.limitCounterFailed),
+		withFilterLogger(&ir.Logger),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool, len(lCfgs))
+	for _, lCfg := range lCfgs {
+		name := lCfg.name()
+		if names[name] {
+			return nil, fmt.Errorf("%w: %q", ErrDuplicateListener, name)
+		}
+		names[name] = true
+
+		if lCfg.ProxyProtocol.Receive {
+			if _, err := parseTrustedCIDRs(lCfg.ProxyProtocol.TrustedCIDRs); err != nil {
+				return nil, fmt.Errorf("listener %q: %w", name, err)
+			}
+		}
+	}
+
+	srvNames := make(map[string]bool, len(srvs))
+	for _, srv := range srvs {
+		srvNames[srv.Name()] = true
+		for _, name := range srv.cfg.Listeners {
+			if !names[name] {
+				return nil, fmt.Errorf("%w: %q of server %q", ErrUnknownListener, name, srv.Name())
+			}
+		}
+	}
+
+	lns := make([]*listener, len(lCfgs))
+	for i, lCfg := range lCfgs {
+		for _, name := range lCfg.Servers {
+			if !srvNames[name] {
+				return nil, fmt.Errorf("%w: %q of listener %q", ErrUnknownServer, name, lCfg.name())
+			}
+		}
+
+		sr, err := ir.newServerRequester(listenerServers(lCfg, srvs))
+		if err != nil {
+			return nil, fmt.Errorf("listener %q: %w", lCfg.name(), err)
+		}
+
+		filter := globalFilter
+		if lCfg.Filters != nil {
+			filter, err = NewFilter(
+				WithFilterConfig(*lCfg.Filters),
+				withOnCounterError(ir.limitCounterFailed),
+				withFilterLogger(&ir.Logger),
+			)
+			if err != nil {
+				return nil, fmt.Errorf("listener %q: %w", lCfg.name(), err)
+			}
+		}
+
+		var realIP *realIPVerifier
+		if lCfg.ReceiveRealIP != nil {
+			realIP, err = newRealIPVerifier(*lCfg.ReceiveRealIP)
+			if err != nil {
+				return nil, fmt.Errorf("listener %q: %w", lCfg.name(), err)
+			}
+		}
+
+		var auth *authenticator
+		if lCfg.OnlineMode != nil {
+			auth, err = newAuthenticator(*lCfg.OnlineMode)
+			if err != nil {
+				return nil, fmt.Errorf("listener %q: %w", lCfg.name(), err)
+			}
+		}
+
+		lns[i] = &listener{
+			cfg:    lCfg,
+			filter: filter,
+			sr:     sr,
+			realIP: realIP,
+			auth:   auth,
+		}
+	}
+
+	return lns, nil
+}
+
+// listenerServers returns the servers that are available on the listener.
+func listenerServers(lCfg ListenerConfig, srvs []*Server) []*Server {
+	name := lCfg.name()
+	lSrvs := make([]*Server, 0, len(srvs))
+	for _, srv := range srvs {
+		if len(srv.cfg.Listeners) > 0 && !slices.Contains(srv.cfg.Listeners, name) {
+			continue
+		}
+
+		if len(lCfg.Servers) > 0 && !slices.Contains(lCfg.Servers, srv.Name()) {
+			continue
+		}
+
+		lSrvs = append(lSrvs, srv)
+	}
+
+	return lSrvs
+}
+
+// listen binds the address of the listener.
+func (ir *Infrared) listen(cfg ListenerConfig) (net.Listener, error) {
+	ir.Logger.Info().
+		Str("listener", cfg.name()).
+		Str("bind", cfg.Bind).
+		Msg("Starting listener")
+
+	var trustedCIDRs []*net.IPNet
+	if cfg.ProxyProtocol.Receive {
+		var err error
+		trustedCIDRs, err = parseTrustedCIDRs(cfg.ProxyProtocol.TrustedCIDRs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	newListenerFn := ir.NewListenerFunc
+	if newListenerFn == nil {
+		newListenerFn = func(addr string) (net.Listener, error) {
+			return net.Listen("tcp", addr)
+		}
+	}
+
+	l, err := newListenerFn(cfg.Bind)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.ProxyProtocol.Receive {
+		l = newProxyProtocolListener(l, trustedCIDRs)
+	}
+
+	return l, nil
+}
+
+// canReuseListener reports whether a bound listener with the config a
+// can be used for the config b.
+func canReuseListener(a, b ListenerConfig) bool {
+	return a.Bind == b.Bind &&
+		a.ProxyProtocol.Receive == b.ProxyProtocol.Receive &&
+		slices.Equal(a.ProxyProtocol.TrustedCIDRs, b.ProxyProtocol.TrustedCIDRs)
+}
+
+// serve accepts connections until l is closed.
+func (ir *Infrared) serve(l net.Listener) {
+	defer ir.serving.Done()
+
+	for {
+		c, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			ir.Logger.Debug().
+				Err(err).
+				Msg("Error accepting new connection")
+
+			continue
+		}
+
+		ir.metrics.connAccepted()
+		go ir.handleNewConn(l, c)
+	}
+}
@@ -0,0 +1,168 @@
+package infrared
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+var (
+	ErrUnknownLoadBalancerStrategy = errors.New("unknown load balancer strategy")
+)
+
+type LoadBalancerStrategy string
+
+const (
+	RoundRobinStrategy       LoadBalancerStrategy = "roundRobin"
+	RandomStrategy           LoadBalancerStrategy = "random"
+	LeastConnectionsStrategy LoadBalancerStrategy = "leastConnections"
+	ClientIPHashStrategy     LoadBalancerStrategy = "clientIPHash"
+	PlayerNameHashStrategy   LoadBalancerStrategy = "playerNameHash"
+)
+
+type LoadBalancerConfig struct {
+	Strategy LoadBalancerStrategy `yaml:"strategy"`
+}
+
+// backend is a single address of a server and its runtime state.
+type backend struct {
+	addr        ServerAddress
+	activeConns atomic.Int32
+	healthy     atomic.Bool
+
+	// checking is set while a health check of the backend is running
+	checking atomic.Bool
+
+	healthMu  sync.Mutex
+	successes int
+	failures  int
+}
+
+func newBackend(addr ServerAddress) *backend {
+	b := &backend{
+		addr: addr,
+	}
+	b.healthy.Store(true)
+	return b
+}
+
+// resetHealth puts the backend back into rotation and forgets the results of its checks.
+func (b *backend) resetHealth() {
+	b.healthMu.Lock()
+	defer b.healthMu.Unlock()
+
+	b.successes = 0
+	b.failures = 0
+	b.healthy.Store(true)
+}
+
+// loadBalancer orders the backends of a server for a request.
+// The first backend should be dialed first; all following backends
+// are used as failover in the given order.
+type loadBalancer interface {
+	order(req ServerRequest, backends []*backend) []*backend
+}
+
+type loadBalancerFunc func(req ServerRequest, backends []*backend) []*backend
+
+func (fn loadBalancerFunc) order(req ServerRequest, backends []*backend) []*backend {
+	return fn(req, backends)
+}
+
+func newLoadBalancer(cfg LoadBalancerConfig) (loadBalancer, error) {
+	switch cfg.Strategy {
+	case "", RoundRobinStrategy:
+		return &roundRobinLoadBalancer{}, nil
+	case RandomStrategy:
+		return loadBalancerFunc(orderRandom), nil
+	case LeastConnectionsStrategy:
+		return loadBalancerFunc(orderLeastConnections), nil
+	case ClientIPHashStrategy:
+		return hashLoadBalancer(keyByClientIP), nil
+	case PlayerNameHashStrategy:
+		return hashLoadBalancer(keyByPlayerName), nil
+	default:
+		return nil, ErrUnknownLoadBalancerStrategy
+	}
+}
+
+type roundRobinLoadBalancer struct {
+	next atomic.Uint32
+}
+
+func (lb *roundRobinLoadBalancer) order(_ ServerRequest, backends []*backend) []*backend {
+	n := len(backends)
+	start := int(lb.next.Add(1)-1) % n
+
+	ordered := make([]*backend, 0, n)
+	ordered = append(ordered, backends[start:]...)
+	ordered = append(ordered, backends[:start]...)
+	return ordered
+}
+
+func orderRandom(_ ServerRequest, backends []*backend) []*backend {
+	ordered := make([]*backend, len(backends))
+	//nolint:gosec // Balancing does not need to be cryptographically secure
+	for i, j := range rand.Perm(len(backends)) {
+		ordered[i] = backends[j]
+	}
+	return ordered
+}
+
+func orderLeastConnections(_ ServerRequest, backends []*backend) []*backend {
+	ordered := make([]*backend, len(backends))
+	copy(ordered, backends)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].activeConns.Load() < ordered[j].activeConns.Load()
+	})
+	return ordered
+}
+
+func keyByClientIP(req ServerRequest) string {
+	if req.ClientAddr == nil {
+		return ""
+	}
+
+	addr := req.ClientAddr.String()
+	ip, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return ip
+}
+
+func keyByPlayerName(req ServerRequest) string {
+	if req.PlayerName == "" {
+		// Status requests don't have a player name
+		return keyByClientIP(req)
+	}
+	return req.PlayerName
+}
+
+// hashLoadBalancer uses rendezvous hashing to consistently map a key to a backend.
+// Adding or removing a backend only remaps the keys of that backend and
+// the failover order for a key is stable as well.
+func hashLoadBalancer(keyFn func(ServerRequest) string) loadBalancer {
+	return loadBalancerFunc(func(req ServerRequest, backends []*backend) []*backend {
+		key := keyFn(req)
+		scores := make(map[*backend]uint64, len(backends))
+		for _, b := range backends {
+			h := xxhash.New()
+			_, _ = h.WriteString(key)
+			_, _ = h.WriteString(string(b.addr))
+			scores[b] = h.Sum64()
+		}
+
+		ordered := make([]*backend, len(backends))
+		copy(ordered, backends)
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return scores[ordered[i]] > scores[ordered[j]]
+		})
+		return ordered
+	})
+}
@@ -0,0 +1,259 @@
+package infrared
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	metricsNamespace = "infrared"
+	metricsPath      = "/metrics"
+)
+
+type MetricsConfig struct {
+	// Bind is the address of the HTTP server that serves the metrics under /metrics
+	Bind string `yaml:"bind"`
+}
+
+// metrics holds all Prometheus collectors of an Infrared instance.
+// The methods that record metrics are safe to call on a nil *metrics,
+// so servers that are not created by Infrared work without metrics.
+//
+// Connections are labeled by the name of the server instead of the
+// requested domain, because clients can send arbitrary domains.
+type metrics struct {
+	registry *prometheus.Registry
+
+	acceptedConns      prometheus.Counter
+	filteredConns      prometheus.Counter
+	rejectedConns      *prometheus.CounterVec
+	requests           *prometheus.CounterVec
+	activeConns        *prometheus.GaugeVec
+	bytesTransferred   *prometheus.CounterVec
+	dialDuration       *prometheus.HistogramVec
+	statusCacheLookups *prometheus.CounterVec
+	rateLimitedConns   prometheus.Counter
+	limitCounterErrors prometheus.Counter
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		acceptedConns: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "connections_accepted_total",
+			Help:      "Total number of accepted connections.",
+		}),
+		filteredConns: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "connections_filtered_total",
+			Help:      "Total number of connections that were dropped by a filter.",
+		}),
+		rejectedConns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "connections_rejected_total",
+			Help:      "Total number of connections whose request could not be served.",
+		}, []string{"reason"}),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "requests_total",
+			Help:      "Total number of requests by server and next state.",
+		}, []string{"server", "next_state"}),
+		activeConns: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "active_connections",
+			Help:      "Number of connections that are currently piped to a server.",
+		}, []string{"server"}),
+		bytesTransferred: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "transferred_bytes_total",
+			Help:      "Total number of bytes piped between clients and servers.",
+		}, []string{"server", "direction"}),
+		dialDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "server_dial_duration_seconds",
+			Help:      "Duration of dialing a server address.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 13),
+		}, []string{"server", "address", "result"}),
+		statusCacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "status_cache_lookups_total",
+			Help:      "Total number of status cache lookups by result (hit or miss).",
+		}, []string{"server", "result"}),
+		rateLimitedConns: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "rate_limiter_rejections_total",
+			Help:      "Total number of connections that were rejected by the rate limiter.",
+		}),
+		limitCounterErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "rate_limiter_counter_errors_total",
+			Help:      "Total number of errors of the request counter of the rate limiter.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.acceptedConns,
+		m.filteredConns,
+		m.rejectedConns,
+		m.requests,
+		m.activeConns,
+		m.bytesTransferred,
+		m.dialDuration,
+		m.statusCacheLookups,
+		m.rateLimitedConns,
+		m.limitCounterErrors,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *metrics) connAccepted() {
+	if m == nil {
+		return
+	}
+	m.acceptedConns.Inc()
+}
+
+func (m *metrics) connFiltered(err error) {
+	if m == nil {
+		return
+	}
+	m.filteredConns.Inc()
+
+	if errors.Is(err, ErrRateLimitReached) {
+		m.rateLimitedConns.Inc()
+	}
+}
+
+func (m *metrics) limitCounterFailed() {
+	if m == nil {
+		return
+	}
+	m.limitCounterErrors.Inc()
+}
+
+func (m *metrics) connRejected(err error) {
+	if m == nil {
+		return
+	}
+
+	reason := "other"
+	switch {
+	case errors.Is(err, ErrServerNotFound):
+		reason = "server_not_found"
+	case errors.Is(err, ErrServerUnreachable):
+		reason = "server_unreachable"
+	case errors.Is(err, ErrUnsupportedClientVersion):
+		reason = "unsupported_version"
+	case errors.Is(err, ErrNotAuthenticated):
+		reason = "not_authenticated"
+	case errors.Is(err, ErrNotWhitelisted):
+		reason = "not_whitelisted"
+	case errors.Is(err, ErrBanned):
+		reason = "banned"
+	case errors.Is(err, ErrRequestFiltered):
+		reason = "filtered"
+	}
+	m.rejectedConns.WithLabelValues(reason).Inc()
+}
+
+func (m *metrics) request(srvName string, isLogin bool) {
+	if m == nil {
+		return
+	}
+
+	nextState := "status"
+	if isLogin {
+		nextState = "login"
+	}
+	m.requests.WithLabelValues(srvName, nextState).Inc()
+}
+
+// pipeStarted counts an active connection. The returned function has to be called
+// once the pipe is closed.
+func (m *metrics) pipeStarted(srvName string) func() {
+	if m == nil {
+		return func() {}
+	}
+
+	g := m.activeConns.WithLabelValues(srvName)
+	g.Inc()
+	return g.Dec
+}
+
+// bytesCounter returns the counter for bytes sent in the given direction.
+// The direction is either "upstream" (client to server) or "downstream".
+func (m *metrics) bytesCounter(srvName, direction string) prometheus.Counter {
+	return m.bytesTransferred.WithLabelValues(srvName, direction)
+}
+
+func (m *metrics) dialed(srvName string, addr ServerAddress, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.dialDuration.WithLabelValues(srvName, string(addr), result).Observe(d.Seconds())
+}
+
+func (m *metrics) statusCacheLookup(srvName string, hit bool) {
+	if m == nil {
+		return
+	}
+
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.statusCacheLookups.WithLabelValues(srvName, result).Inc()
+}
+
+// countingWriter adds the number of written bytes to a counter.
+type countingWriter struct {
+	w       io.Writer
+	counter prometheus.Counter
+}
+
+func (w countingWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	w.counter.Add(float64(n))
+	return n, err
+}
+
+// startMetricsServer serves the metrics on the bind address of cfg.
+// The returned function stops the server.
+func (ir *Infrared) startMetricsServer(cfg *MetricsConfig) (func(), error) {
+	if cfg == nil {
+		return func() {}, nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, ir.MetricsHandler())
+
+	ir.Logger.Info().
+		Str("bind", cfg.Bind).
+		Msg("Starting metrics server")
+
+	return ir.startHTTPServer(cfg.Bind, mux)
+}
+
+// MetricsHandler returns the HTTP handler that serves the Prometheus metrics of Infrared.
+// It can be used to serve the metrics on a custom HTTP server.
+func (ir *Infrared) MetricsHandler() http.Handler {
+	return ir.metrics.handler()
+}
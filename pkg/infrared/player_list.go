@@ -0,0 +1,420 @@
+package infrared
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+var (
+	ErrNotWhitelisted = errors.New("not whitelisted")
+	ErrBanned         = errors.New("banned")
+)
+
+const (
+	// playerListTimeLayout is the layout of the dates in the lists of Minecraft servers.
+	playerListTimeLayout = "2006-01-02 15:04:05 -0700"
+	// playerListForever is the expiry date of permanent bans.
+	playerListForever = "forever"
+	// playerListWatchDebounce is the time to wait for more changes before a list is reloaded.
+	playerListWatchDebounce = 500 * time.Millisecond
+
+	defaultBanReason = "Banned by an operator."
+)
+
+type PlayerListsConfig struct {
+	// Whitelist is the path to a whitelist.json.
+	// Only players on the whitelist can log in if set.
+	Whitelist string `yaml:"whitelist"`
+	// BannedPlayers is the path to a banned-players.json
+	BannedPlayers string `yaml:"bannedPlayers"`
+	// BannedIPs is the path to a banned-ips.json.
+	// The IPs can also be CIDRs.
+	BannedIPs string `yaml:"bannedIPs"`
+}
+
+// BanError is returned if a player is banned.
+type BanError struct {
+	Reason string
+	// Expires is zero if the ban is permanent
+	Expires time.Time
+}
+
+func (err BanError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrBanned, err.Reason)
+}
+
+func (err BanError) Unwrap() error {
+	return ErrBanned
+}
+
+// fill replaces the {reason} and {expires} placeholders in all strings of the chat component v.
+func (err BanError) fill(v any) any {
+	expires := "never"
+	if !err.Expires.IsZero() {
+		expires = err.Expires.Format(playerListTimeLayout)
+	}
+
+	r := strings.NewReplacer(
+		"{reason}", err.Reason,
+		"{expires}", expires,
+	)
+	return replaceInChat(v, r)
+}
+
+func replaceInChat(v any, r *strings.Replacer) any {
+	switch v := v.(type) {
+	case string:
+		return r.Replace(v)
+	case []any:
+		vv := make([]any, len(v))
+		for i, e := range v {
+			vv[i] = replaceInChat(e, r)
+		}
+		return vv
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[k] = replaceInChat(e, r)
+		}
+		return m
+	default:
+		return v
+	}
+}
+
+// playerListFileEntry is an entry of whitelist.json, banned-players.json or banned-ips.json.
+type playerListFileEntry struct {
+	UUID    string `json:"uuid"`
+	Name    string `json:"name"`
+	IP      string `json:"ip"`
+	Expires string `json:"expires"`
+	Reason  string `json:"reason"`
+}
+
+type playerListEntry struct {
+	uuid uuid.UUID
+	name string
+	// ipNet is nil if the entry is not for an address
+	ipNet *net.IPNet
+	// expires is zero if the entry does not expire
+	expires time.Time
+	reason  string
+}
+
+func newPlayerListEntry(e playerListFileEntry) (playerListEntry, error) {
+	entry := playerListEntry{
+		name:   e.Name,
+		reason: e.Reason,
+	}
+
+	if e.UUID != "" {
+		id, err := uuid.Parse(e.UUID)
+		if err != nil {
+			return playerListEntry{}, err
+		}
+		entry.uuid = id
+	}
+
+	if e.IP != "" {
+		ipNet, err := parseIPOrCIDR(e.IP)
+		if err != nil {
+			return playerListEntry{}, err
+		}
+		entry.ipNet = ipNet
+	}
+
+	if e.Expires != "" && e.Expires != playerListForever {
+		expires, err := time.Parse(playerListTimeLayout, e.Expires)
+		if err != nil {
+			return playerListEntry{}, err
+		}
+		entry.expires = expires
+	}
+
+	return entry, nil
+}
+
+// parseIPOrCIDR parses s as a CIDR or as a single IP address.
+func parseIPOrCIDR(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, ipNet, err := net.ParseCIDR(s)
+		return ipNet, err
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %q", s)
+	}
+
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		bits = 8 * net.IPv4len
+	}
+
+	return &net.IPNet{
+		IP:   ip,
+		Mask: net.CIDRMask(bits, bits),
+	}, nil
+}
+
+// matches reports whether the entry is for the player of req.
+// Bans match players by their UUID or by their name, so that a ban cannot be evaded
+// with another UUID. Other entries match players by their UUID only if it was verified
+// in online mode and by their name otherwise, so that a UUID cannot be spoofed.
+func (e playerListEntry) matches(req ServerRequest, clientIP net.IP, isBan bool) bool {
+	if e.ipNet != nil {
+		return clientIP != nil && e.ipNet.Contains(clientIP)
+	}
+
+	uuidMatches := e.uuid != uuid.Nil && e.uuid == req.PlayerUUID
+	nameMatches := e.name != "" && strings.EqualFold(e.name, req.PlayerName)
+
+	if isBan {
+		return uuidMatches || nameMatches
+	}
+
+	if req.IsAuthenticated && e.uuid != uuid.Nil {
+		return uuidMatches
+	}
+
+	return nameMatches
+}
+
+func (e playerListEntry) isExpired(now time.Time) bool {
+	return !e.expires.IsZero() && now.After(e.expires)
+}
+
+// playerList is a list file that can be reloaded while it is in use.
+type playerList struct {
+	path string
+	// isBan is set if the entries are bans
+	isBan   bool
+	entries atomic.Pointer[[]playerListEntry]
+}
+
+func newPlayerList(path string, isBan bool) (*playerList, error) {
+	l := &playerList{
+		path:  path,
+		isBan: isBan,
+	}
+
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *playerList) load() error {
+	bb, err := os.ReadFile(l.path)
+	if err != nil {
+		return err
+	}
+
+	var fileEntries []playerListFileEntry
+	if err := json.Unmarshal(bb, &fileEntries); err != nil {
+		return fmt.Errorf("failed to decode %q: %w", l.path, err)
+	}
+
+	entries := make([]playerListEntry, len(fileEntries))
+	for i, fe := range fileEntries {
+		e, err := newPlayerListEntry(fe)
+		if err != nil {
+			return fmt.Errorf("entry %d of %q: %w", i, l.path, err)
+		}
+		entries[i] = e
+	}
+
+	l.entries.Store(&entries)
+	return nil
+}
+
+// find returns the first entry that matches req and is not expired.
+func (l *playerList) find(req ServerRequest, clientIP net.IP) (playerListEntry, bool) {
+	now := time.Now()
+	for _, e := range *l.entries.Load() {
+		if e.matches(req, clientIP, l.isBan) && !e.isExpired(now) {
+			return e, true
+		}
+	}
+
+	return playerListEntry{}, false
+}
+
+// playerLists are the whitelist and ban lists of Infrared or of a server.
+// The methods are safe to call on a nil *playerLists, which allows every player.
+type playerLists struct {
+	// lists that are nil are not configured
+	whitelist     *playerList
+	bannedPlayers *playerList
+	bannedIPs     *playerList
+}
+
+// newPlayerLists loads the lists of cfg. It returns nil if no list is configured.
+func newPlayerLists(cfg PlayerListsConfig) (*playerLists, error) {
+	if cfg == (PlayerListsConfig{}) {
+		return nil, nil //nolint:nilnil // No config means no lists
+	}
+
+	var lists playerLists
+	for _, l := range []struct {
+		path  string
+		isBan bool
+		list  **playerList
+	}{
+		{cfg.Whitelist, false, &lists.whitelist},
+		{cfg.BannedPlayers, true, &lists.bannedPlayers},
+		{cfg.BannedIPs, true, &lists.bannedIPs},
+	} {
+		if l.path == "" {
+			continue
+		}
+
+		list, err := newPlayerList(l.path, l.isBan)
+		if err != nil {
+			return nil, err
+		}
+		*l.list = list
+	}
+
+	return &lists, nil
+}
+
+func (ls *playerLists) all() []*playerList {
+	all := make([]*playerList, 0, 3)
+	for _, l := range []*playerList{ls.whitelist, ls.bannedPlayers, ls.bannedIPs} {
+		if l != nil {
+			all = append(all, l)
+		}
+	}
+	return all
+}
+
+// check returns a BanError if the player of req is banned and
+// ErrNotWhitelisted if a whitelist is configured and the player is not on it.
+func (ls *playerLists) check(req ServerRequest) error {
+	if ls == nil {
+		return nil
+	}
+
+	var clientIP net.IP
+	if req.ClientAddr != nil {
+		host, _, err := net.SplitHostPort(req.ClientAddr.String())
+		if err != nil {
+			host = req.ClientAddr.String()
+		}
+		clientIP = net.ParseIP(host)
+	}
+
+	for _, l := range []*playerList{ls.bannedPlayers, ls.bannedIPs} {
+		if l == nil {
+			continue
+		}
+
+		if e, ok := l.find(req, clientIP); ok {
+			reason := e.reason
+			if reason == "" {
+				reason = defaultBanReason
+			}
+
+			return BanError{
+				Reason:  reason,
+				Expires: e.expires,
+			}
+		}
+	}
+
+	if ls.whitelist != nil {
+		if _, ok := ls.whitelist.find(req, clientIP); !ok {
+			return ErrNotWhitelisted
+		}
+	}
+
+	return nil
+}
+
+// watch reloads the lists when their files change until ctx is done.
+// If a list cannot be reloaded, the old entries stay in use.
+func (ls *playerLists) watch(ctx context.Context, logger zerolog.Logger) {
+	if ls == nil {
+		return
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to watch player lists")
+		return
+	}
+	defer w.Close()
+
+	// Watching the directories instead of the files also
+	// catches editors and servers that replace the files on save.
+	lists := make(map[string]*playerList)
+	for _, l := range ls.all() {
+		path, err := filepath.Abs(l.path)
+		if err != nil {
+			logger.Error().Err(err).Str("path", l.path).Msg("Failed to watch player list")
+			continue
+		}
+
+		if err := w.Add(filepath.Dir(path)); err != nil {
+			logger.Error().Err(err).Str("path", l.path).Msg("Failed to watch player list")
+			continue
+		}
+		lists[path] = l
+
+		// Catches changes between loading the list and watching it
+		_ = l.load()
+	}
+
+	changed := make(map[*playerList]bool)
+	timer := time.NewTimer(playerListWatchDebounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-w.Errors:
+			logger.Error().Err(err).Msg("Failed to watch player lists")
+			return
+		case e := <-w.Events:
+			l, ok := lists[e.Name]
+			if !ok {
+				continue
+			}
+
+			changed[l] = true
+			timer.Reset(playerListWatchDebounce)
+		case <-timer.C:
+			for l := range changed {
+				if err := l.load(); err != nil {
+					logger.Error().
+						Err(err).
+						Str("path", l.path).
+						Msg("Failed to reload player list")
+					continue
+				}
+
+				logger.Info().
+					Str("path", l.path).
+					Msg("Player list reloaded")
+			}
+			clear(changed)
+		}
+	}
+}
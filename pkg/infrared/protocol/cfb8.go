@@ -0,0 +1,60 @@
+package protocol
+
+import "crypto/cipher"
+
+// cfb8 is the cipher feedback mode with a segment size of 8 bits
+// that Minecraft uses to encrypt connections after the login.
+type cfb8 struct {
+	b       cipher.Block
+	sr      []byte
+	out     []byte
+	decrypt bool
+}
+
+// NewCFB8Encrypter returns a Stream which encrypts with cipher feedback mode
+// with a segment size of 8 bits, using the given Block.
+// The iv must be the same length as the Block's block size.
+func NewCFB8Encrypter(block cipher.Block, iv []byte) cipher.Stream {
+	return newCFB8(block, iv, false)
+}
+
+// NewCFB8Decrypter returns a Stream which decrypts with cipher feedback mode
+// with a segment size of 8 bits, using the given Block.
+// The iv must be the same length as the Block's block size.
+func NewCFB8Decrypter(block cipher.Block, iv []byte) cipher.Stream {
+	return newCFB8(block, iv, true)
+}
+
+func newCFB8(block cipher.Block, iv []byte, decrypt bool) *cfb8 {
+	if len(iv) != block.BlockSize() {
+		panic("cfb8: IV length must equal block size")
+	}
+
+	sr := make([]byte, len(iv))
+	copy(sr, iv)
+	return &cfb8{
+		b:       block,
+		sr:      sr,
+		out:     make([]byte, block.BlockSize()),
+		decrypt: decrypt,
+	}
+}
+
+func (x *cfb8) XORKeyStream(dst, src []byte) {
+	if len(dst) < len(src) {
+		panic("cfb8: output smaller than input")
+	}
+
+	for i, b := range src {
+		x.b.Encrypt(x.out, x.sr)
+		dst[i] = b ^ x.out[0]
+
+		// The shift register is fed with the ciphertext
+		c := dst[i]
+		if x.decrypt {
+			c = b
+		}
+		copy(x.sr, x.sr[1:])
+		x.sr[len(x.sr)-1] = c
+	}
+}
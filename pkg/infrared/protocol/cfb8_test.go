@@ -0,0 +1,40 @@
+package protocol_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/haveachin/infrared/pkg/infrared/protocol"
+)
+
+func TestCFB8(t *testing.T) {
+	// CFB8-AES128 test vector of NIST SP 800-38A
+	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	iv, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	plaintext, _ := hex.DecodeString("6bc1bee22e409f96e93d7e117393172aae2d")
+	ciphertext, _ := hex.DecodeString("3b79424c9c0dd436bace9e0ed4586a4f32b9")
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([]byte, len(plaintext))
+	enc := protocol.NewCFB8Encrypter(block, iv)
+	// Encrypt in two calls to check that the state is kept
+	enc.XORKeyStream(got[:5], plaintext[:5])
+	enc.XORKeyStream(got[5:], plaintext[5:])
+	if !bytes.Equal(got, ciphertext) {
+		t.Fatalf("got: %x; want: %x", got, ciphertext)
+	}
+
+	got = make([]byte, len(ciphertext))
+	copy(got, ciphertext)
+	// Decrypt in place
+	protocol.NewCFB8Decrypter(block, iv).XORKeyStream(got, got)
+	if !bytes.Equal(got, plaintext) {
+		t.Fatalf("got: %x; want: %x", got, plaintext)
+	}
+}
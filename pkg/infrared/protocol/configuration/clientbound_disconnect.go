@@ -0,0 +1,17 @@
+package configuration
+
+import "github.com/haveachin/infrared/pkg/infrared/protocol"
+
+// The configuration state was added in 1.20.2.
+const ClientBoundDisconnectID int32 = 0x01
+
+type ClientBoundDisconnect struct {
+	Reason protocol.Chat
+}
+
+func (pk ClientBoundDisconnect) Marshal(packet *protocol.Packet) error {
+	return packet.Encode(
+		ClientBoundDisconnectID,
+		pk.Reason,
+	)
+}
@@ -0,0 +1,5 @@
+package configuration
+
+// ClientBoundFinishConfigurationID is the ID of the packet that
+// switches the connection to the play state.
+const ClientBoundFinishConfigurationID int32 = 0x02
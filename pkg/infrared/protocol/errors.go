@@ -5,5 +5,6 @@ import (
 )
 
 var (
-	ErrInvalidPacketID = errors.New("invalid packet id")
+	ErrInvalidPacketID    = errors.New("invalid packet id")
+	ErrUnsupportedVersion = errors.New("unsupported protocol version")
 )
@@ -1,6 +1,7 @@
 package handshaking
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"net"
@@ -51,10 +52,37 @@ func (pk *ServerBoundHandshake) Unmarshal(packet protocol.Packet) error {
 	)
 }
 
+// SetServerAddress replaces the server address and keeps the Forge and RealIP data that follows it.
 func (pk *ServerBoundHandshake) SetServerAddress(addr string) {
-	oldAddr := pk.ParseServerAddress()
-	newAddr := strings.Replace(string(pk.ServerAddress), oldAddr, addr, 1)
-	pk.ServerAddress = protocol.String(newAddr)
+	oldAddr := string(pk.ServerAddress)
+	i := len(oldAddr)
+	for _, sep := range []string{SeparatorForge, SeparatorRealIP} {
+		if j := strings.Index(oldAddr, sep); j != -1 && j < i {
+			i = j
+		}
+	}
+	pk.ServerAddress = protocol.String(addr + oldAddr[i:])
+}
+
+// ForgeMarker returns the marker that Forge clients append to the server address
+// like "FML2" or an empty string if there is none.
+func (pk ServerBoundHandshake) ForgeMarker() string {
+	_, marker, ok := strings.Cut(string(pk.ServerAddress), SeparatorForge)
+	if !ok {
+		return ""
+	}
+	marker, _, _ = strings.Cut(marker, SeparatorForge)
+	return marker
+}
+
+// SetForgeMarker replaces the Forge marker of the server address.
+// An empty marker removes it.
+func (pk *ServerBoundHandshake) SetForgeMarker(marker string) {
+	addr, _, _ := strings.Cut(string(pk.ServerAddress), SeparatorForge)
+	if marker != "" {
+		addr += SeparatorForge + marker + SeparatorForge
+	}
+	pk.ServerAddress = protocol.String(addr)
 }
 
 func (pk ServerBoundHandshake) IsStatusRequest() bool {
@@ -105,23 +133,77 @@ func parseTCPAddr(addr string) (net.Addr, error) {
 	}, nil
 }
 
+// ParseRealIP parses the RealIP data "host///ip:port///timestamp///signature" of the server address.
+// It returns the address of the client, the timestamp and the decoded signature.
 func (pk ServerBoundHandshake) ParseRealIP() (net.Addr, time.Time, []byte, error) {
-	payload := strings.Split(string(pk.ServerAddress), SeparatorRealIP)
+	addr, _, _ := strings.Cut(string(pk.ServerAddress), SeparatorForge)
+	payload := strings.Split(addr, SeparatorRealIP)
 	if len(payload) < 4 {
 		return nil, time.Time{}, nil, errors.New("invalid payload")
 	}
 
-	addr, err := parseTCPAddr(payload[1])
+	clientAddr, err := parseTCPAddr(payload[1])
 	if err != nil {
 		return nil, time.Time{}, nil, err
 	}
 
-	timeStamp, err := time.Parse(time.UnixDate, payload[2])
+	unixTimestamp, err := strconv.ParseInt(payload[2], 10, 64)
 	if err != nil {
 		return nil, time.Time{}, nil, err
 	}
 
-	return addr, timeStamp, []byte(payload[3]), nil
+	signature, err := base64.StdEncoding.DecodeString(payload[3])
+	if err != nil {
+		return nil, time.Time{}, nil, err
+	}
+
+	return clientAddr, time.Unix(unixTimestamp, 0), signature, nil
+}
+
+// RealIPPayload returns the signed part "host///ip:port///timestamp" of the RealIP data.
+func (pk ServerBoundHandshake) RealIPPayload() string {
+	addr, _, _ := strings.Cut(string(pk.ServerAddress), SeparatorForge)
+	if i := strings.LastIndex(addr, SeparatorRealIP); i != -1 {
+		addr = addr[:i]
+	}
+	return addr
+}
+
+// RemoveRealIP removes the RealIP data from the server address and keeps the Forge data.
+func (pk *ServerBoundHandshake) RemoveRealIP() {
+	addr, forge, isForge := strings.Cut(string(pk.ServerAddress), SeparatorForge)
+	addr, _, _ = strings.Cut(addr, SeparatorRealIP)
+	if isForge {
+		addr += SeparatorForge + forge
+	}
+	pk.ServerAddress = protocol.String(addr)
+}
+
+// UpgradeToSignedRealIP upgrades the server address to the RealIP format
+// "host///ip:port///timestamp///signature" that backends with the RealIP plugin expect.
+// The signature is created by sign for "host///ip:port///timestamp" and encoded with base64.
+// Forge data is kept at the end of the server address.
+func (pk *ServerBoundHandshake) UpgradeToSignedRealIP(
+	clientAddr net.Addr,
+	timestamp time.Time,
+	sign func(payload []byte) ([]byte, error),
+) error {
+	addr, forge, isForge := strings.Cut(string(pk.ServerAddress), SeparatorForge)
+	addr, _, _ = strings.Cut(addr, SeparatorRealIP)
+
+	payload := fmt.Sprintf("%s///%s///%d", addr, clientAddr.String(), timestamp.Unix())
+	signature, err := sign([]byte(payload))
+	if err != nil {
+		return err
+	}
+
+	addr = payload + SeparatorRealIP + base64.StdEncoding.EncodeToString(signature)
+	if isForge {
+		addr += SeparatorForge + forge
+	}
+
+	pk.ServerAddress = protocol.String(addr)
+	return nil
 }
 
 func (pk *ServerBoundHandshake) UpgradeToRealIP(clientAddr net.Addr, timestamp time.Time) {
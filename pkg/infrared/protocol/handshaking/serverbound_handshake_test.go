@@ -2,6 +2,7 @@ package handshaking_test
 
 import (
 	"bytes"
+	"encoding/base64"
 	"net"
 	"strconv"
 	"strings"
@@ -303,6 +304,107 @@ func TestServerBoundHandshake_ParseServerAddress(t *testing.T) {
 	}
 }
 
+func TestServerBoundHandshake_SetServerAddress(t *testing.T) {
+	tt := []struct {
+		addr         string
+		newAddr      string
+		expectedAddr string
+	}{
+		{
+			addr:         "",
+			newAddr:      "backend.local",
+			expectedAddr: "backend.local",
+		},
+		{
+			addr:         "example.com",
+			newAddr:      "backend.local",
+			expectedAddr: "backend.local",
+		},
+		{
+			addr:         "example.com.",
+			newAddr:      "backend.local",
+			expectedAddr: "backend.local",
+		},
+		{
+			addr:         "example.com" + handshaking.SeparatorForge + "FML2" + handshaking.SeparatorForge,
+			newAddr:      "backend.local",
+			expectedAddr: "backend.local" + handshaking.SeparatorForge + "FML2" + handshaking.SeparatorForge,
+		},
+		{
+			addr:         "example.com" + handshaking.SeparatorRealIP + "127.0.0.1:25565" + handshaking.SeparatorRealIP + "1",
+			newAddr:      "backend.local",
+			expectedAddr: "backend.local" + handshaking.SeparatorRealIP + "127.0.0.1:25565" + handshaking.SeparatorRealIP + "1",
+		},
+	}
+
+	for _, tc := range tt {
+		hs := handshaking.ServerBoundHandshake{ServerAddress: protocol.String(tc.addr)}
+		hs.SetServerAddress(tc.newAddr)
+		if string(hs.ServerAddress) != tc.expectedAddr {
+			t.Errorf("%q: got: %q; want: %q", tc.addr, hs.ServerAddress, tc.expectedAddr)
+		}
+	}
+}
+
+func TestServerBoundHandshake_ForgeMarker(t *testing.T) {
+	tt := []struct {
+		addr   string
+		marker string
+	}{
+		{
+			addr:   "example.com",
+			marker: "",
+		},
+		{
+			addr:   "example.com" + handshaking.SeparatorForge + "FML" + handshaking.SeparatorForge,
+			marker: "FML",
+		},
+		{
+			addr:   "example.com" + handshaking.SeparatorForge + "FML3",
+			marker: "FML3",
+		},
+	}
+
+	for _, tc := range tt {
+		hs := handshaking.ServerBoundHandshake{ServerAddress: protocol.String(tc.addr)}
+		if hs.ForgeMarker() != tc.marker {
+			t.Errorf("%q: got: %q; want: %q", tc.addr, hs.ForgeMarker(), tc.marker)
+		}
+	}
+}
+
+func TestServerBoundHandshake_SetForgeMarker(t *testing.T) {
+	tt := []struct {
+		addr         string
+		marker       string
+		expectedAddr string
+	}{
+		{
+			addr:         "example.com",
+			marker:       "FML2",
+			expectedAddr: "example.com" + handshaking.SeparatorForge + "FML2" + handshaking.SeparatorForge,
+		},
+		{
+			addr:         "example.com" + handshaking.SeparatorForge + "FML" + handshaking.SeparatorForge,
+			marker:       "FML3",
+			expectedAddr: "example.com" + handshaking.SeparatorForge + "FML3" + handshaking.SeparatorForge,
+		},
+		{
+			addr:         "example.com" + handshaking.SeparatorForge + "FML2" + handshaking.SeparatorForge,
+			marker:       "",
+			expectedAddr: "example.com",
+		},
+	}
+
+	for _, tc := range tt {
+		hs := handshaking.ServerBoundHandshake{ServerAddress: protocol.String(tc.addr)}
+		hs.SetForgeMarker(tc.marker)
+		if string(hs.ServerAddress) != tc.expectedAddr {
+			t.Errorf("%q: got: %q; want: %q", tc.addr, hs.ServerAddress, tc.expectedAddr)
+		}
+	}
+}
+
 func TestServerBoundHandshake_UpgradeToRealIP(t *testing.T) {
 	tt := []struct {
 		addr       string
@@ -372,6 +474,102 @@ func TestServerBoundHandshake_UpgradeToRealIP(t *testing.T) {
 	}
 }
 
+func TestServerBoundHandshake_UpgradeToSignedRealIP(t *testing.T) {
+	clientAddr := &net.TCPAddr{
+		IP:   net.IPv4(203, 0, 113, 10),
+		Port: 54321,
+	}
+	timestamp := time.Unix(1700000000, 0)
+	sign := func(payload []byte) ([]byte, error) {
+		return append([]byte("signed:"), payload...), nil
+	}
+
+	tt := []struct {
+		addr         string
+		expectedAddr string
+	}{
+		{
+			addr: "example.com",
+			expectedAddr: "example.com///203.0.113.10:54321///1700000000///" +
+				base64.StdEncoding.EncodeToString([]byte("signed:example.com///203.0.113.10:54321///1700000000")),
+		},
+		{
+			addr: "example.com" + handshaking.SeparatorForge + "FML2" + handshaking.SeparatorForge,
+			expectedAddr: "example.com///203.0.113.10:54321///1700000000///" +
+				base64.StdEncoding.EncodeToString([]byte("signed:example.com///203.0.113.10:54321///1700000000")) +
+				handshaking.SeparatorForge + "FML2" + handshaking.SeparatorForge,
+		},
+		{
+			addr: "example.com///127.0.0.1:25565///1///c2ln",
+			expectedAddr: "example.com///203.0.113.10:54321///1700000000///" +
+				base64.StdEncoding.EncodeToString([]byte("signed:example.com///203.0.113.10:54321///1700000000")),
+		},
+	}
+
+	for _, tc := range tt {
+		hs := handshaking.ServerBoundHandshake{ServerAddress: protocol.String(tc.addr)}
+		if err := hs.UpgradeToSignedRealIP(clientAddr, timestamp, sign); err != nil {
+			t.Fatal(err)
+		}
+
+		if string(hs.ServerAddress) != tc.expectedAddr {
+			t.Errorf("%q: got: %q; want: %q", tc.addr, hs.ServerAddress, tc.expectedAddr)
+		}
+	}
+}
+
+func TestServerBoundHandshake_ParseRealIP(t *testing.T) {
+	signature := []byte("signature")
+	hs := handshaking.ServerBoundHandshake{
+		ServerAddress: protocol.String("example.com///203.0.113.10:54321///1700000000///" +
+			base64.StdEncoding.EncodeToString(signature) +
+			handshaking.SeparatorForge + "FML2" + handshaking.SeparatorForge),
+	}
+
+	addr, timestamp, sig, err := hs.ParseRealIP()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if addr.String() != "203.0.113.10:54321" {
+		t.Errorf("got: %s; want: 203.0.113.10:54321", addr)
+	}
+
+	if timestamp.Unix() != 1700000000 {
+		t.Errorf("got: %d; want: 1700000000", timestamp.Unix())
+	}
+
+	if !bytes.Equal(sig, signature) {
+		t.Errorf("got: %q; want: %q", sig, signature)
+	}
+
+	if want := "example.com///203.0.113.10:54321///1700000000"; hs.RealIPPayload() != want {
+		t.Errorf("got: %q; want: %q", hs.RealIPPayload(), want)
+	}
+
+	hs.RemoveRealIP()
+	if want := "example.com" + handshaking.SeparatorForge + "FML2" + handshaking.SeparatorForge; string(hs.ServerAddress) != want {
+		t.Errorf("got: %q; want: %q", hs.ServerAddress, want)
+	}
+}
+
+func TestServerBoundHandshake_ParseRealIP_Invalid(t *testing.T) {
+	tt := []string{
+		"example.com",
+		"example.com///203.0.113.10:54321///1700000000",
+		"example.com///203.0.113.10///1700000000///c2ln",
+		"example.com///203.0.113.10:54321///Mon Jan 2 15:04:05 UTC 2006///c2ln",
+		"example.com///203.0.113.10:54321///1700000000///not base64",
+	}
+
+	for _, addr := range tt {
+		hs := handshaking.ServerBoundHandshake{ServerAddress: protocol.String(addr)}
+		if _, _, _, err := hs.ParseRealIP(); err == nil {
+			t.Errorf("%q: got: no error; want: error", addr)
+		}
+	}
+}
+
 func BenchmarkHandshakingServerBoundHandshake_Marshal(b *testing.B) {
 	hsPk := handshaking.ServerBoundHandshake{
 		ProtocolVersion: 578,
@@ -0,0 +1,37 @@
+package login
+
+import (
+	"crypto/sha1" //nolint:gosec // Minecraft uses SHA-1 for the server hash
+	"math/big"
+)
+
+// AuthDigest returns the server hash that the client and the server send to the session server.
+// It is the SHA-1 digest of the server ID, the shared secret and the public key of the server
+// encoded as a signed hexadecimal number like Java's BigInteger.toString(16).
+func AuthDigest(serverID string, sharedSecret, publicKey []byte) string {
+	h := sha1.New() //nolint:gosec // Minecraft uses SHA-1 for the server hash
+	h.Write([]byte(serverID))
+	h.Write(sharedSecret)
+	h.Write(publicKey)
+	digest := h.Sum(nil)
+
+	// The digest is a two's complement number
+	negative := digest[0]&0x80 != 0
+	if negative {
+		for i := range digest {
+			digest[i] = ^digest[i]
+		}
+		for i := len(digest) - 1; i >= 0; i-- {
+			digest[i]++
+			if digest[i] != 0 {
+				break
+			}
+		}
+	}
+
+	s := new(big.Int).SetBytes(digest).Text(16)
+	if negative {
+		return "-" + s
+	}
+	return s
+}
@@ -0,0 +1,33 @@
+package login_test
+
+import (
+	"testing"
+
+	"github.com/haveachin/infrared/pkg/infrared/protocol/login"
+)
+
+func TestAuthDigest(t *testing.T) {
+	tt := []struct {
+		serverID string
+		want     string
+	}{
+		{
+			serverID: "Notch",
+			want:     "4ed1f46bbe04bc756bcb17c0c7ce3e4632f06a48",
+		},
+		{
+			serverID: "jeb_",
+			want:     "-7c9d5b0044c130109a5d7b5fb5c317c02b4e28c1",
+		},
+		{
+			serverID: "simon",
+			want:     "88e16a1019277b15d58faf0541e11910eb756f6",
+		},
+	}
+
+	for _, tc := range tt {
+		if got := login.AuthDigest(tc.serverID, nil, nil); got != tc.want {
+			t.Errorf("got: %s, want: %s", got, tc.want)
+		}
+	}
+}
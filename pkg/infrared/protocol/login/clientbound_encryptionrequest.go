@@ -19,7 +19,7 @@ func (pk ClientBoundEncryptionRequest) Marshal(packet *protocol.Packet) error {
 	)
 }
 
-func (pk ClientBoundEncryptionRequest) Unmarshal(packet protocol.Packet) error {
+func (pk *ClientBoundEncryptionRequest) Unmarshal(packet protocol.Packet) error {
 	if packet.ID != ClientBoundEncryptionRequestID {
 		return protocol.ErrInvalidPacketID
 	}
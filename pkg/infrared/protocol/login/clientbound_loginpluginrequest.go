@@ -0,0 +1,49 @@
+package login
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/haveachin/infrared/pkg/infrared/protocol"
+)
+
+const ClientBoundLoginPluginRequestID int32 = 0x04
+
+type ClientBoundLoginPluginRequest struct {
+	MessageID protocol.VarInt
+	Channel   protocol.String
+	// Data is the rest of the packet without a length prefix
+	Data []byte
+}
+
+func (pk ClientBoundLoginPluginRequest) Marshal(packet *protocol.Packet) error {
+	if err := packet.Encode(
+		ClientBoundLoginPluginRequestID,
+		pk.MessageID,
+		pk.Channel,
+	); err != nil {
+		return err
+	}
+
+	packet.Data = append(packet.Data, pk.Data...)
+	return nil
+}
+
+func (pk *ClientBoundLoginPluginRequest) Unmarshal(packet protocol.Packet) error {
+	if packet.ID != ClientBoundLoginPluginRequestID {
+		return protocol.ErrInvalidPacketID
+	}
+
+	r := bytes.NewReader(packet.Data)
+	if err := protocol.ScanFields(r, &pk.MessageID, &pk.Channel); err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	pk.Data = data
+
+	return nil
+}
@@ -0,0 +1,4 @@
+package login
+
+// ClientBoundLoginSuccessID is the ID of the packet that ends the login state.
+const ClientBoundLoginSuccessID int32 = 0x02
@@ -0,0 +1,28 @@
+package login
+
+import "github.com/haveachin/infrared/pkg/infrared/protocol"
+
+const ClientBoundSetCompressionID int32 = 0x03
+
+type ClientBoundSetCompression struct {
+	// Threshold is the minimum size of a packet before it is compressed.
+	// A negative value disables compression.
+	Threshold protocol.VarInt
+}
+
+func (pk ClientBoundSetCompression) Marshal(packet *protocol.Packet) error {
+	return packet.Encode(
+		ClientBoundSetCompressionID,
+		pk.Threshold,
+	)
+}
+
+func (pk *ClientBoundSetCompression) Unmarshal(packet protocol.Packet) error {
+	if packet.ID != ClientBoundSetCompressionID {
+		return protocol.ErrInvalidPacketID
+	}
+
+	return packet.Decode(
+		&pk.Threshold,
+	)
+}
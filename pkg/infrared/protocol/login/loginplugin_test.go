@@ -0,0 +1,125 @@
+package login_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/haveachin/infrared/pkg/infrared/protocol"
+	"github.com/haveachin/infrared/pkg/infrared/protocol/login"
+)
+
+func TestClientBoundLoginPluginRequest(t *testing.T) {
+	tt := []struct {
+		packet          login.ClientBoundLoginPluginRequest
+		marshaledPacket protocol.Packet
+	}{
+		{
+			packet: login.ClientBoundLoginPluginRequest{
+				MessageID: 1,
+				Channel:   "a:b",
+			},
+			marshaledPacket: protocol.Packet{
+				ID:   0x04,
+				Data: []byte{0x01, 0x03, 0x61, 0x3a, 0x62},
+			},
+		},
+		{
+			packet: login.ClientBoundLoginPluginRequest{
+				MessageID: 300,
+				Channel:   "velocity:player_info",
+				Data:      []byte{0x04},
+			},
+			marshaledPacket: protocol.Packet{
+				ID: 0x04,
+				Data: append(
+					[]byte{0xac, 0x02, 0x14},
+					append([]byte("velocity:player_info"), 0x04)...,
+				),
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		var pk protocol.Packet
+		if err := tc.packet.Marshal(&pk); err != nil {
+			t.Fatal(err)
+		}
+
+		if pk.ID != login.ClientBoundLoginPluginRequestID {
+			t.Error("invalid packet id")
+		}
+
+		if !bytes.Equal(pk.Data, tc.marshaledPacket.Data) {
+			t.Errorf("got: %v, want: %v", pk.Data, tc.marshaledPacket.Data)
+		}
+
+		var req login.ClientBoundLoginPluginRequest
+		if err := req.Unmarshal(tc.marshaledPacket); err != nil {
+			t.Fatal(err)
+		}
+
+		if req.MessageID != tc.packet.MessageID || req.Channel != tc.packet.Channel {
+			t.Errorf("got: %v %v, want: %v %v", req.MessageID, req.Channel, tc.packet.MessageID, tc.packet.Channel)
+		}
+
+		if !bytes.Equal(req.Data, tc.packet.Data) {
+			t.Errorf("got: %v, want: %v", req.Data, tc.packet.Data)
+		}
+	}
+}
+
+func TestServerBoundLoginPluginResponse(t *testing.T) {
+	tt := []struct {
+		packet          login.ServerBoundLoginPluginResponse
+		marshaledPacket protocol.Packet
+	}{
+		{
+			packet: login.ServerBoundLoginPluginResponse{
+				MessageID: 1,
+			},
+			marshaledPacket: protocol.Packet{
+				ID:   0x02,
+				Data: []byte{0x01, 0x00},
+			},
+		},
+		{
+			packet: login.ServerBoundLoginPluginResponse{
+				MessageID:  2,
+				Successful: true,
+				Data:       []byte{0xca, 0xfe},
+			},
+			marshaledPacket: protocol.Packet{
+				ID:   0x02,
+				Data: []byte{0x02, 0x01, 0xca, 0xfe},
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		var pk protocol.Packet
+		if err := tc.packet.Marshal(&pk); err != nil {
+			t.Fatal(err)
+		}
+
+		if pk.ID != login.ServerBoundLoginPluginResponseID {
+			t.Error("invalid packet id")
+		}
+
+		if !bytes.Equal(pk.Data, tc.marshaledPacket.Data) {
+			t.Errorf("got: %v, want: %v", pk.Data, tc.marshaledPacket.Data)
+		}
+
+		var resp login.ServerBoundLoginPluginResponse
+		if err := resp.Unmarshal(tc.marshaledPacket); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.MessageID != tc.packet.MessageID || resp.Successful != tc.packet.Successful {
+			t.Errorf("got: %v %v, want: %v %v", resp.MessageID, resp.Successful, tc.packet.MessageID, tc.packet.Successful)
+		}
+
+		if !bytes.Equal(resp.Data, tc.packet.Data) {
+			t.Errorf("got: %v, want: %v", resp.Data, tc.packet.Data)
+		}
+	}
+}
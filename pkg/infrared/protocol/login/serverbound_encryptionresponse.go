@@ -1,29 +1,73 @@
 package login
 
-import "github.com/haveachin/infrared/pkg/infrared/protocol"
+import (
+	"bytes"
+
+	"github.com/haveachin/infrared/pkg/infrared/protocol"
+)
 
 const ServerBoundEncryptionResponseID int32 = 0x01
 
 type ServerBoundEncryptionResponse struct {
 	SharedSecret protocol.ByteArray
-	VerifyToken  protocol.ByteArray
+
+	// Added in 1.19; removed in 1.19.3
+	HasVerifyToken protocol.Boolean
+
+	VerifyToken protocol.ByteArray
+
+	// Added in 1.19; removed in 1.19.3
+	// Sent instead of the verify token by clients with a chat signing key
+	Salt             protocol.Long
+	MessageSignature protocol.ByteArray
 }
 
-func (pk ServerBoundEncryptionResponse) Marshal(packet *protocol.Packet) error {
+func isSaltSignatureVersion(version protocol.Version) bool {
+	return version >= protocol.Version1_19 &&
+		version < protocol.Version1_19_3
+}
+
+func (pk ServerBoundEncryptionResponse) Marshal(packet *protocol.Packet, version protocol.Version) error {
+	fields := make([]protocol.FieldEncoder, 0, 4)
+	fields = append(fields, pk.SharedSecret)
+
+	switch {
+	case !isSaltSignatureVersion(version):
+		fields = append(fields, pk.VerifyToken)
+	case bool(pk.HasVerifyToken):
+		fields = append(fields, pk.HasVerifyToken, pk.VerifyToken)
+	default:
+		fields = append(fields, pk.HasVerifyToken, pk.Salt, pk.MessageSignature)
+	}
+
 	return packet.Encode(
 		ServerBoundEncryptionResponseID,
-		pk.SharedSecret,
-		pk.VerifyToken,
+		fields...,
 	)
 }
 
-func (pk *ServerBoundEncryptionResponse) Unmarshal(packet protocol.Packet) error {
+func (pk *ServerBoundEncryptionResponse) Unmarshal(packet protocol.Packet, version protocol.Version) error {
 	if packet.ID != ServerBoundEncryptionResponseID {
 		return protocol.ErrInvalidPacketID
 	}
 
-	return packet.Decode(
-		&pk.SharedSecret,
-		&pk.VerifyToken,
-	)
+	r := bytes.NewReader(packet.Data)
+	if err := protocol.ScanFields(r, &pk.SharedSecret); err != nil {
+		return err
+	}
+
+	if !isSaltSignatureVersion(version) {
+		pk.HasVerifyToken = true
+		return protocol.ScanFields(r, &pk.VerifyToken)
+	}
+
+	if err := protocol.ScanFields(r, &pk.HasVerifyToken); err != nil {
+		return err
+	}
+
+	if pk.HasVerifyToken {
+		return protocol.ScanFields(r, &pk.VerifyToken)
+	}
+
+	return protocol.ScanFields(r, &pk.Salt, &pk.MessageSignature)
 }
@@ -0,0 +1,84 @@
+package login_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/haveachin/infrared/pkg/infrared/protocol"
+	"github.com/haveachin/infrared/pkg/infrared/protocol/login"
+)
+
+func TestServerBoundEncryptionResponse(t *testing.T) {
+	tt := []struct {
+		name            string
+		packet          login.ServerBoundEncryptionResponse
+		version         protocol.Version
+		marshaledPacket protocol.Packet
+	}{
+		{
+			name: "verify token",
+			packet: login.ServerBoundEncryptionResponse{
+				SharedSecret:   []byte{0x01},
+				HasVerifyToken: true,
+				VerifyToken:    []byte{0x02, 0x03},
+			},
+			version: protocol.Version1_20_2,
+			marshaledPacket: protocol.Packet{
+				ID:   0x01,
+				Data: []byte{0x01, 0x01, 0x02, 0x02, 0x03},
+			},
+		},
+		{
+			name: "verify token in 1.19",
+			packet: login.ServerBoundEncryptionResponse{
+				SharedSecret:   []byte{0x01},
+				HasVerifyToken: true,
+				VerifyToken:    []byte{0x02},
+			},
+			version: protocol.Version1_19,
+			marshaledPacket: protocol.Packet{
+				ID:   0x01,
+				Data: []byte{0x01, 0x01, 0x01, 0x01, 0x02},
+			},
+		},
+		{
+			name: "salt and signature in 1.19.1",
+			packet: login.ServerBoundEncryptionResponse{
+				SharedSecret:     []byte{0x01},
+				Salt:             2,
+				MessageSignature: []byte{0x03},
+			},
+			version: protocol.Version1_19_1,
+			marshaledPacket: protocol.Packet{
+				ID:   0x01,
+				Data: []byte{0x01, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x01, 0x03},
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var pk protocol.Packet
+			if err := tc.packet.Marshal(&pk, tc.version); err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(pk.Data, tc.marshaledPacket.Data) {
+				t.Fatalf("got: %v, want: %v", pk.Data, tc.marshaledPacket.Data)
+			}
+
+			var resp login.ServerBoundEncryptionResponse
+			if err := resp.Unmarshal(tc.marshaledPacket, tc.version); err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.HasVerifyToken != tc.packet.HasVerifyToken ||
+				!bytes.Equal(resp.SharedSecret, tc.packet.SharedSecret) ||
+				!bytes.Equal(resp.VerifyToken, tc.packet.VerifyToken) ||
+				resp.Salt != tc.packet.Salt ||
+				!bytes.Equal(resp.MessageSignature, tc.packet.MessageSignature) {
+				t.Fatalf("got: %+v, want: %+v", resp, tc.packet)
+			}
+		})
+	}
+}
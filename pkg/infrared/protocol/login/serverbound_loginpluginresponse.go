@@ -0,0 +1,50 @@
+package login
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/haveachin/infrared/pkg/infrared/protocol"
+)
+
+const ServerBoundLoginPluginResponseID int32 = 0x02
+
+type ServerBoundLoginPluginResponse struct {
+	MessageID protocol.VarInt
+	// Successful is false if the client does not understand the request
+	Successful protocol.Boolean
+	// Data is the rest of the packet without a length prefix
+	Data []byte
+}
+
+func (pk ServerBoundLoginPluginResponse) Marshal(packet *protocol.Packet) error {
+	if err := packet.Encode(
+		ServerBoundLoginPluginResponseID,
+		pk.MessageID,
+		pk.Successful,
+	); err != nil {
+		return err
+	}
+
+	packet.Data = append(packet.Data, pk.Data...)
+	return nil
+}
+
+func (pk *ServerBoundLoginPluginResponse) Unmarshal(packet protocol.Packet) error {
+	if packet.ID != ServerBoundLoginPluginResponseID {
+		return protocol.ErrInvalidPacketID
+	}
+
+	r := bytes.NewReader(packet.Data)
+	if err := protocol.ScanFields(r, &pk.MessageID, &pk.Successful); err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	pk.Data = data
+
+	return nil
+}
@@ -2,15 +2,38 @@ package play
 
 import "github.com/haveachin/infrared/pkg/infrared/protocol"
 
-const ClientBoundDisconnectID int32 = 0x17
+// ClientBoundDisconnectID returns the ID of the disconnect packet for the given version.
+// It reports false if the version is not supported.
+func ClientBoundDisconnectID(version protocol.Version) (int32, bool) {
+	switch version {
+	case protocol.Version1_19,
+		protocol.Version1_19_3:
+		return 0x17, true
+	case protocol.Version1_19_1:
+		return 0x19, true
+	case protocol.Version1_18_2,
+		protocol.Version1_19_4,
+		protocol.Version1_20:
+		return 0x1A, true
+	case protocol.Version1_20_2:
+		return 0x1B, true
+	default:
+		return 0, false
+	}
+}
 
 type ClientBoundDisconnect struct {
 	Reason protocol.Chat
 }
 
-func (pk ClientBoundDisconnect) Marshal(packet *protocol.Packet) error {
+func (pk ClientBoundDisconnect) Marshal(packet *protocol.Packet, version protocol.Version) error {
+	id, ok := ClientBoundDisconnectID(version)
+	if !ok {
+		return protocol.ErrUnsupportedVersion
+	}
+
 	return packet.Encode(
-		ClientBoundDisconnectID,
+		id,
 		pk.Reason,
 	)
 }
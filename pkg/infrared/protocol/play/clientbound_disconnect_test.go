@@ -0,0 +1,69 @@
+package play_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/haveachin/infrared/pkg/infrared/protocol"
+	"github.com/haveachin/infrared/pkg/infrared/protocol/play"
+)
+
+func TestClientBoundDisconnect_Marshal(t *testing.T) {
+	tt := []struct {
+		version protocol.Version
+		wantID  int32
+		wantErr error
+	}{
+		{
+			version: protocol.Version1_18_2,
+			wantID:  0x1A,
+		},
+		{
+			version: protocol.Version1_19,
+			wantID:  0x17,
+		},
+		{
+			version: protocol.Version1_19_1,
+			wantID:  0x19,
+		},
+		{
+			version: protocol.Version1_19_3,
+			wantID:  0x17,
+		},
+		{
+			version: protocol.Version1_20_2,
+			wantID:  0x1B,
+		},
+		{
+			version: protocol.Version(0),
+			wantErr: protocol.ErrUnsupportedVersion,
+		},
+	}
+
+	wantData := []byte{0x0d, 0x48, 0x65, 0x6c, 0x6c, 0x6f, 0x2c, 0x20, 0x57, 0x6f, 0x72, 0x6c, 0x64, 0x21}
+
+	for _, tc := range tt {
+		t.Run(tc.version.Name(), func(t *testing.T) {
+			var pk protocol.Packet
+			err := play.ClientBoundDisconnect{
+				Reason: protocol.Chat("Hello, World!"),
+			}.Marshal(&pk, tc.version)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got: %v; want: %v", err, tc.wantErr)
+			}
+
+			if tc.wantErr != nil {
+				return
+			}
+
+			if pk.ID != tc.wantID {
+				t.Errorf("got: %#x; want: %#x", pk.ID, tc.wantID)
+			}
+
+			if !bytes.Equal(pk.Data, wantData) {
+				t.Errorf("got: %v; want: %v", pk.Data, wantData)
+			}
+		})
+	}
+}
@@ -7,7 +7,10 @@ type Version int32
 const (
 	Version1_18_2 Version = 758
 	Version1_19   Version = 759
+	Version1_19_1 Version = 760
 	Version1_19_3 Version = 761
+	Version1_19_4 Version = 762
+	Version1_20   Version = 763
 	Version1_20_2 Version = 764
 )
 
@@ -17,8 +20,14 @@ func (v Version) Name() string {
 		return "1.18.2"
 	case Version1_19:
 		return "1.19"
+	case Version1_19_1:
+		return "1.19.1"
 	case Version1_19_3:
 		return "1.19.3"
+	case Version1_19_4:
+		return "1.19.4"
+	case Version1_20:
+		return "1.20"
 	case Version1_20_2:
 		return "1.20.2"
 	default:
@@ -17,7 +17,7 @@ type ProxyProtocolConfig struct {
 	TrustedCIDRs []string `yaml:"trustedCIDRs"`
 }
 
-func newProxyProtocolListener(l net.Listener, trustedCIDRs []string) (net.Listener, error) {
+func parseTrustedCIDRs(trustedCIDRs []string) ([]*net.IPNet, error) {
 	if len(trustedCIDRs) == 0 {
 		return nil, ErrNoTrustedCIDRs
 	}
@@ -31,6 +31,10 @@ func newProxyProtocolListener(l net.Listener, trustedCIDRs []string) (net.Listen
 		cidrs[i] = cidr
 	}
 
+	return cidrs, nil
+}
+
+func newProxyProtocolListener(l net.Listener, cidrs []*net.IPNet) net.Listener {
 	return &proxyproto.Listener{
 		Listener: l,
 		Policy: func(upstream net.Addr) (proxyproto.Policy, error) {
@@ -47,7 +51,7 @@ func newProxyProtocolListener(l net.Listener, trustedCIDRs []string) (net.Listen
 
 			return proxyproto.REJECT, ErrUpstreamNotTrusted
 		},
-	}, nil
+	}
 }
 
 func writeProxyProtocolHeader(addr net.Addr, rc net.Conn) error {
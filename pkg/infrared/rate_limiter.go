@@ -15,6 +15,32 @@ import (
 type RateLimiterConfig struct {
 	RequestLimit int           `yaml:"requestLimit"`
 	WindowLength time.Duration `yaml:"windowLength"`
+	// Redis shares the request counts with other Infrared instances if set
+	Redis *RedisLimitCounterConfig `yaml:"redis"`
+}
+
+// rateLimitFilter returns the rate limiter of cfg that limits by IP.
+// It does not close connections, because they are closed by the caller of the filter.
+// onCounterError is called with the errors of the counter if it is not nil.
+func rateLimitFilter(cfg RateLimiterConfig, onCounterError func(err error)) (Filterer, error) {
+	opts := []RateLimiterOption{
+		WithKeyByIP(),
+		WithOnRequestLimit(func(net.Conn) {}),
+	}
+
+	if onCounterError != nil {
+		opts = append(opts, WithOnCounterError(onCounterError))
+	}
+
+	if cfg.Redis != nil {
+		counter, err := NewRedisLimitCounter(*cfg.Redis, cfg.WindowLength)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithLimitCounter(counter))
+	}
+
+	return RateLimit(cfg.RequestLimit, cfg.WindowLength, opts...), nil
 }
 
 func RateLimit(requestLimit int, windowLength time.Duration, options ...RateLimiterOption) Filterer {
@@ -46,6 +72,30 @@ func WithKeyByIP() RateLimiterOption {
 	return WithKeyFuncs(KeyByIP)
 }
 
+// WithLimitCounter sets the counter of the requests.
+// By default the requests are counted in memory.
+func WithLimitCounter(counter LimitCounter) RateLimiterOption {
+	return func(rl *rateLimiter) {
+		rl.limitCounter = counter
+	}
+}
+
+// WithOnRequestLimit sets the function that is called with every connection
+// that reached the request limit. By default the connection is closed.
+func WithOnRequestLimit(fn func(c net.Conn)) RateLimiterOption {
+	return func(rl *rateLimiter) {
+		rl.onRequestLimit = fn
+	}
+}
+
+// WithOnCounterError sets the function that is called if the requests cannot be counted.
+// The request is allowed then. By default the errors are ignored.
+func WithOnCounterError(fn func(err error)) RateLimiterOption {
+	return func(rl *rateLimiter) {
+		rl.onCounterError = fn
+	}
+}
+
 func composedKeyFunc(keyFuncs ...RateLimiterKeyFunc) RateLimiterKeyFunc {
 	return func(c net.Conn) string {
 		var key strings.Builder
@@ -111,16 +161,16 @@ func newRateLimiter(requestLimit int, windowLength time.Duration, options ...Rat
 	rl := &rateLimiter{
 		requestLimit: requestLimit,
 		windowLength: windowLength,
-		limitCounter: localCounter{
-			counters:     make(map[uint64]*count),
-			windowLength: windowLength,
-		},
 	}
 
 	for _, opt := range options {
 		opt(rl)
 	}
 
+	if rl.limitCounter == nil {
+		rl.limitCounter = NewLocalLimitCounter(windowLength)
+	}
+
 	if rl.keyFn == nil {
 		rl.keyFn = func(c net.Conn) string {
 			return "*"
@@ -133,6 +183,10 @@ func newRateLimiter(requestLimit int, windowLength time.Duration, options ...Rat
 		}
 	}
 
+	if rl.onCounterError == nil {
+		rl.onCounterError = func(error) {}
+	}
+
 	return rl
 }
 
@@ -140,42 +194,65 @@ type rateLimiter struct {
 	requestLimit   int
 	windowLength   time.Duration
 	keyFn          RateLimiterKeyFunc
-	limitCounter   localCounter
+	limitCounter   LimitCounter
 	onRequestLimit func(c net.Conn)
+	onCounterError func(err error)
 }
 
-func (r *rateLimiter) Status(key string) (bool, float64) {
-	t := time.Now().UTC()
-	currentWindow := t.Truncate(r.windowLength)
-	previousWindow := currentWindow.Add(-r.windowLength)
-
-	currCount, prevCount := r.limitCounter.Get(key, currentWindow, previousWindow)
-
+// rate returns the number of requests in the sliding window that ends at t.
+// The count of the previous window is weighted by how much it overlaps with the sliding window.
+func (r *rateLimiter) rate(t, currentWindow time.Time, currCount, prevCount int) float64 {
 	diff := t.Sub(currentWindow)
-	rate := float64(prevCount)*(float64(r.windowLength)-float64(diff))/float64(r.windowLength) + float64(currCount)
-	return rate > float64(r.requestLimit), rate
+	return float64(prevCount)*(float64(r.windowLength)-float64(diff))/float64(r.windowLength) + float64(currCount)
 }
 
 var ErrRateLimitReached = errors.New("rate limit reached")
 
+// Filterer returns the filter of the rate limiter.
+// Every request is counted before it is checked, so that concurrent requests
+// cannot exceed the limit. The count of denied requests is taken back.
+// If the requests cannot be counted, the connection is allowed.
 func (r *rateLimiter) Filterer() Filterer {
 	return FilterFunc(func(c net.Conn) error {
 		key := r.keyFn(c)
-		currentWindow := time.Now().UTC().Truncate(r.windowLength)
-
-		_, rate := r.Status(key)
-		nrate := int(math.Round(rate))
+		t := time.Now().UTC()
+		currentWindow := t.Truncate(r.windowLength)
+		previousWindow := currentWindow.Add(-r.windowLength)
+
+		currCount, prevCount, err := r.limitCounter.Increment(key, currentWindow, previousWindow)
+		if err != nil {
+			r.onCounterError(err)
+			return nil
+		}
 
+		// The rate is the one before this request
+		nrate := int(math.Round(r.rate(t, currentWindow, currCount-1, prevCount)))
 		if nrate >= r.requestLimit {
+			if err := r.limitCounter.Decrement(key, currentWindow); err != nil {
+				r.onCounterError(err)
+			}
+
 			r.onRequestLimit(c)
 			return ErrRateLimitReached
 		}
 
-		r.limitCounter.Inc(key, currentWindow)
 		return nil
 	})
 }
 
+// LimitCounter counts the requests of keys in fixed windows.
+// The rate limiter uses the counts of the current and the previous window
+// to approximate the requests in a sliding window.
+// Implementations must be safe for concurrent use.
+type LimitCounter interface {
+	// Increment increments the count of key in currentWindow and returns the counts
+	// of key in currentWindow and previousWindow. Both have to be read at once with
+	// the increment, so that concurrent increments return different counts.
+	Increment(key string, currentWindow, previousWindow time.Time) (int, int, error)
+	// Decrement decrements the count of key in currentWindow.
+	Decrement(key string, currentWindow time.Time) error
+}
+
 type localCounter struct {
 	counters     map[uint64]*count
 	windowLength time.Duration
@@ -188,50 +265,60 @@ type count struct {
 	updatedAt time.Time
 }
 
-func (c *localCounter) Inc(key string, currentWindow time.Time) {
-	c.evict()
+// NewLocalLimitCounter returns a LimitCounter that counts in memory.
+// The counts are only shared by the rate limiters that use the same counter.
+func NewLocalLimitCounter(windowLength time.Duration) LimitCounter {
+	return &localCounter{
+		counters:     make(map[uint64]*count),
+		windowLength: windowLength,
+	}
+}
 
+func (c *localCounter) Increment(key string, currentWindow, previousWindow time.Time) (int, int, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	hkey := limitCounterKey(key, currentWindow)
+	c.evict()
 
-	v, ok := c.counters[hkey]
+	hkey := limitCounterKey(key, currentWindow)
+	curr, ok := c.counters[hkey]
 	if !ok {
-		v = &count{}
-		c.counters[hkey] = v
+		curr = &count{}
+		c.counters[hkey] = curr
 	}
-	v.value++
-	v.updatedAt = time.Now()
+	curr.value++
+	curr.updatedAt = time.Now()
+
+	var prevCount int
+	if prev, ok := c.counters[limitCounterKey(key, previousWindow)]; ok {
+		prevCount = prev.value
+	}
+
+	return curr.value, prevCount, nil
 }
 
-func (c *localCounter) Get(key string, currentWindow, previousWindow time.Time) (int, int) {
+func (c *localCounter) Decrement(key string, currentWindow time.Time) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	curr, ok := c.counters[limitCounterKey(key, currentWindow)]
-	if !ok {
-		curr = &count{value: 0, updatedAt: time.Now()}
-	}
-	prev, ok := c.counters[limitCounterKey(key, previousWindow)]
-	if !ok {
-		prev = &count{value: 0, updatedAt: time.Now()}
+	if curr, ok := c.counters[limitCounterKey(key, currentWindow)]; ok && curr.value > 0 {
+		curr.value--
 	}
 
-	return curr.value, prev.value
+	return nil
 }
 
+// evict deletes the counts that are not used anymore.
+// Counts are kept for two windows, because they are used as the previous window.
+// The caller has to hold the lock.
 func (c *localCounter) evict() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
 	if time.Since(c.lastEvict) < c.windowLength {
 		return
 	}
 	c.lastEvict = time.Now()
 
 	for k, v := range c.counters {
-		if time.Since(v.updatedAt) >= c.windowLength {
+		if time.Since(v.updatedAt) >= 2*c.windowLength {
 			delete(c.counters, k)
 		}
 	}
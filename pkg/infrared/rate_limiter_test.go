@@ -0,0 +1,396 @@
+package infrared_test
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ir "github.com/haveachin/infrared/pkg/infrared"
+)
+
+// redisStub is a server that implements the few Redis commands the rate limiter uses.
+type redisStub struct {
+	password string
+
+	mu     sync.Mutex
+	values map[string]int
+}
+
+func listenRedisStub(t *testing.T, password string) string {
+	t.Helper()
+
+	s := &redisStub{
+		password: password,
+		values:   make(map[string]int),
+	}
+
+	l := listenTCPFunc(t, s.serve)
+	return l.Addr().String()
+}
+
+func listenTCPFunc(t *testing.T, handle func(c net.Conn)) net.Listener {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handle(c)
+		}
+	}()
+
+	return l
+}
+
+func readRedisCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		l, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+
+		bb := make([]byte, l+2)
+		if _, err := io.ReadFull(r, bb); err != nil {
+			return nil, err
+		}
+		args[i] = string(bb[:l])
+	}
+
+	return args, nil
+}
+
+func (s *redisStub) serve(c net.Conn) {
+	defer c.Close()
+
+	r := bufio.NewReader(c)
+	authenticated := s.password == ""
+	var queue [][]string
+	inMulti := false
+
+	for {
+		args, err := readRedisCommand(r)
+		if err != nil {
+			return
+		}
+
+		var reply string
+		switch cmd := strings.ToUpper(args[0]); {
+		case cmd == "AUTH":
+			authenticated = args[len(args)-1] == s.password
+			reply = "+OK\r\n"
+			if !authenticated {
+				reply = "-WRONGPASS invalid password\r\n"
+			}
+		case !authenticated:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case cmd == "MULTI":
+			inMulti = true
+			reply = "+OK\r\n"
+		case cmd == "EXEC":
+			reply = fmt.Sprintf("*%d\r\n", len(queue))
+			for _, args := range queue {
+				reply += s.exec(args)
+			}
+			queue = nil
+			inMulti = false
+		case inMulti:
+			queue = append(queue, args)
+			reply = "+QUEUED\r\n"
+		default:
+			reply = s.exec(args)
+		}
+
+		if _, err := io.WriteString(c, reply); err != nil {
+			return
+		}
+	}
+}
+
+func (s *redisStub) exec(args []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "INCR":
+		s.values[args[1]]++
+		return fmt.Sprintf(":%d\r\n", s.values[args[1]])
+	case "DECR":
+		s.values[args[1]]--
+		return fmt.Sprintf(":%d\r\n", s.values[args[1]])
+	case "PEXPIRE":
+		return ":1\r\n"
+	case "GET":
+		v, ok := s.values[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		str := strconv.Itoa(v)
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(str), str)
+	case "SELECT":
+		return "+OK\r\n"
+	default:
+		return "-ERR unknown command\r\n"
+	}
+}
+
+// rateLimitByIP returns a rate limiter with a window of a minute that keeps denied connections open.
+func rateLimitByIP(requestLimit int, counter ir.LimitCounter) ir.Filterer {
+	return ir.RateLimit(requestLimit, time.Minute,
+		ir.WithKeyByIP(),
+		ir.WithLimitCounter(counter),
+		ir.WithOnRequestLimit(func(net.Conn) {}),
+	)
+}
+
+func TestRateLimit_LimitCounter(t *testing.T) {
+	addr := listenRedisStub(t, "secret")
+	redisCfg := ir.RedisLimitCounterConfig{
+		Addr:     addr,
+		Password: "secret",
+		DB:       1,
+	}
+
+	tt := []struct {
+		name       string
+		newCounter func(t *testing.T) ir.LimitCounter
+	}{
+		{
+			name: "local",
+			newCounter: func(t *testing.T) ir.LimitCounter {
+				return ir.NewLocalLimitCounter(time.Minute)
+			},
+		},
+		{
+			name: "redis",
+			newCounter: func(t *testing.T) ir.LimitCounter {
+				c, err := ir.NewRedisLimitCounter(redisCfg, time.Minute)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return c
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Two instances share the counter
+			counter := tc.newCounter(t)
+			instances := []ir.Filterer{
+				rateLimitByIP(3, counter),
+				rateLimitByIP(3, counter),
+			}
+
+			for i, ip := range []string{"10.0.0.1", "10.0.0.1", "10.0.0.2", "10.0.0.1"} {
+				if err := filterIP(t, instances[i%2], ip); err != nil {
+					t.Fatalf("got: %v; want: nil", err)
+				}
+			}
+
+			if err := filterIP(t, instances[0], "10.0.0.1"); !errors.Is(err, ir.ErrRateLimitReached) {
+				t.Fatalf("got: %v; want: %v", err, ir.ErrRateLimitReached)
+			}
+
+			if err := filterIP(t, instances[1], "10.0.0.2"); err != nil {
+				t.Fatalf("got: %v; want: nil", err)
+			}
+		})
+	}
+}
+
+// incrementCounter is a LimitCounter that counts its increments that are not decremented.
+type incrementCounter struct {
+	ir.LimitCounter
+	increments int
+}
+
+func (c *incrementCounter) Increment(key string, currentWindow, previousWindow time.Time) (int, int, error) {
+	c.increments++
+	return c.LimitCounter.Increment(key, currentWindow, previousWindow)
+}
+
+func (c *incrementCounter) Decrement(key string, currentWindow time.Time) error {
+	c.increments--
+	return c.LimitCounter.Decrement(key, currentWindow)
+}
+
+func TestRateLimit_CountsOnlyAllowedRequests(t *testing.T) {
+	counter := &incrementCounter{
+		LimitCounter: ir.NewLocalLimitCounter(time.Minute),
+	}
+	f := rateLimitByIP(2, counter)
+
+	allowed := 0
+	for i := 0; i < 5; i++ {
+		if err := filterIP(t, f, "10.0.0.1"); err == nil {
+			allowed++
+		}
+	}
+
+	if allowed != 2 || counter.increments != 2 {
+		t.Fatalf("got: %d allowed and %d counted; want: 2 allowed and 2 counted", allowed, counter.increments)
+	}
+}
+
+func TestConnRequestFilter_RateLimitByIP(t *testing.T) {
+	// The default handler of the rate limiter closes the connection of denied requests
+	f := ir.ConnRequestFilter(ir.RateLimitByIP(1, time.Minute))
+	req := ir.ServerRequest{
+		ClientAddr: &net.TCPAddr{
+			IP:   net.ParseIP("10.0.0.1"),
+			Port: 25565,
+		},
+	}
+
+	if err := f.FilterRequest(req); err != nil {
+		t.Fatalf("got: %v; want: nil", err)
+	}
+
+	if err := f.FilterRequest(req); !errors.Is(err, ir.ErrRateLimitReached) {
+		t.Fatalf("got: %v; want: %v", err, ir.ErrRateLimitReached)
+	}
+}
+
+func TestRateLimit_RedisCounter_SharedAcrossInstances(t *testing.T) {
+	addr := listenRedisStub(t, "")
+
+	instances := make([]ir.Filterer, 3)
+	for i := range instances {
+		counter, err := ir.NewRedisLimitCounter(ir.RedisLimitCounterConfig{
+			Addr: addr,
+		}, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		instances[i] = rateLimitByIP(5, counter)
+	}
+
+	allowed := 0
+	for i := 0; i < 9; i++ {
+		if err := filterIP(t, instances[i%3], "10.0.0.1"); err == nil {
+			allowed++
+		}
+	}
+
+	if allowed != 5 {
+		t.Fatalf("got: %d allowed; want: 5", allowed)
+	}
+}
+
+func TestRateLimit_RedisCounter_Concurrent(t *testing.T) {
+	addr := listenRedisStub(t, "")
+	counter, err := ir.NewRedisLimitCounter(ir.RedisLimitCounterConfig{
+		Addr: addr,
+	}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limit := 10
+	f := rateLimitByIP(limit, counter)
+
+	var wg sync.WaitGroup
+	var allowed atomic.Int32
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := filterIP(t, f, "10.0.0.1"); err == nil {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := int(allowed.Load()); got != limit {
+		t.Fatalf("got: %d allowed; want: %d", got, limit)
+	}
+}
+
+func TestRateLimit_RedisCounter_Unreachable(t *testing.T) {
+	counter, err := ir.NewRedisLimitCounter(ir.RedisLimitCounterConfig{
+		Addr:    string(closedAddr(t)),
+		Timeout: 100 * time.Millisecond,
+	}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var counterErrs int
+	f := ir.RateLimit(1, time.Minute,
+		ir.WithKeyByIP(),
+		ir.WithLimitCounter(counter),
+		ir.WithOnCounterError(func(error) {
+			counterErrs++
+		}),
+	)
+
+	// Connections are allowed if the requests cannot be counted
+	for i := 0; i < 3; i++ {
+		if err := filterIP(t, f, "10.0.0.1"); err != nil {
+			t.Fatalf("got: %v; want: nil", err)
+		}
+	}
+
+	if counterErrs != 3 {
+		t.Fatalf("got: %d counter errors; want: 3", counterErrs)
+	}
+}
+
+func TestRateLimit_RedisCounter_WrongPassword(t *testing.T) {
+	addr := listenRedisStub(t, "secret")
+
+	counter, err := ir.NewRedisLimitCounter(ir.RedisLimitCounterConfig{
+		Addr:     addr,
+		Password: "wrong",
+	}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	if _, _, err := counter.Increment("key", now, now.Add(-time.Minute)); err == nil {
+		t.Fatal("got: nil; want: error")
+	}
+}
+
+func TestNewRedisLimitCounter_NoAddr(t *testing.T) {
+	_, err := ir.NewRedisLimitCounter(ir.RedisLimitCounterConfig{}, time.Minute)
+	if !errors.Is(err, ir.ErrNoRedisAddr) {
+		t.Fatalf("got: %v; want: %v", err, ir.ErrNoRedisAddr)
+	}
+}
@@ -0,0 +1,178 @@
+package infrared
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+var (
+	ErrUnsupportedRealIPKey = errors.New("unsupported RealIP key; only RSA and ECDSA keys are supported")
+	ErrInvalidRealIP        = errors.New("invalid RealIP handshake")
+	ErrStaleRealIP          = errors.New("stale RealIP handshake")
+)
+
+const defaultRealIPMaxAge = 5 * time.Second
+
+type RealIPConfig struct {
+	// PrivateKeyFile is the path to the PEM encoded RSA or ECDSA private key
+	// that signs the RealIP handshakes
+	PrivateKeyFile string `yaml:"privateKeyFile"`
+}
+
+type ReceiveRealIPConfig struct {
+	// PublicKeyFile is the path to the PEM encoded RSA or ECDSA public key
+	// of the upstream proxy that signs the RealIP handshakes
+	PublicKeyFile string `yaml:"publicKeyFile"`
+	// MaxAge is the maximum difference between the timestamp of a
+	// RealIP handshake and now. Defaults to 5s.
+	MaxAge time.Duration `yaml:"maxAge"`
+}
+
+// realIPSigner signs the payload of RealIP handshakes with SHA-512.
+// RSA keys create PKCS #1 v1.5 signatures and ECDSA keys ASN.1 encoded signatures,
+// like SHA512withRSA and SHA512withECDSA in Java.
+type realIPSigner struct {
+	key crypto.Signer
+}
+
+func loadRealIPSigner(path string) (*realIPSigner, error) {
+	block, err := readPEMFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return &realIPSigner{key: key}, nil
+	case *ecdsa.PrivateKey:
+		return &realIPSigner{key: key}, nil
+	default:
+		return nil, ErrUnsupportedRealIPKey
+	}
+}
+
+func (s *realIPSigner) sign(payload []byte) ([]byte, error) {
+	digest := sha512.Sum512(payload)
+	return s.key.Sign(rand.Reader, digest[:], crypto.SHA512)
+}
+
+// realIPVerifier verifies RealIP handshakes of an upstream proxy.
+type realIPVerifier struct {
+	key    crypto.PublicKey
+	maxAge time.Duration
+}
+
+func newRealIPVerifier(cfg ReceiveRealIPConfig) (*realIPVerifier, error) {
+	block, err := readPEMFile(cfg.PublicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var key any
+	if block.Type == "RSA PUBLIC KEY" {
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+	default:
+		return nil, ErrUnsupportedRealIPKey
+	}
+
+	maxAge := cfg.MaxAge
+	if maxAge <= 0 {
+		maxAge = defaultRealIPMaxAge
+	}
+
+	return &realIPVerifier{
+		key:    key,
+		maxAge: maxAge,
+	}, nil
+}
+
+func (v *realIPVerifier) verify(payload, signature []byte) bool {
+	digest := sha512.Sum512(payload)
+	switch key := v.key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA512, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, digest[:], signature)
+	default:
+		return false
+	}
+}
+
+// receive reads the handshake of c and verifies its RealIP data.
+// The RealIP data is removed from the handshake and the address of the
+// player replaces the remote address of c.
+func (v *realIPVerifier) receive(c *clientConn) error {
+	if err := c.readHandshake(); err != nil {
+		return err
+	}
+
+	if !c.handshake.IsRealIPAddress() {
+		return fmt.Errorf("%w: no RealIP data", ErrInvalidRealIP)
+	}
+
+	addr, timestamp, signature, err := c.handshake.ParseRealIP()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRealIP, err)
+	}
+
+	if age := time.Since(timestamp); age > v.maxAge || age < -v.maxAge {
+		return fmt.Errorf("%w: timestamp is %s old", ErrStaleRealIP, age.Round(time.Second))
+	}
+
+	if !v.verify([]byte(c.handshake.RealIPPayload()), signature) {
+		return fmt.Errorf("%w: invalid signature", ErrInvalidRealIP)
+	}
+
+	c.handshake.RemoveRealIP()
+	if err := c.handshake.Marshal(&c.readPks[0]); err != nil {
+		return err
+	}
+	c.realIP = addr
+
+	return nil
+}
+
+func readPEMFile(path string) (*pem.Block, error) {
+	bb, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(bb)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %q", path)
+	}
+
+	return block, nil
+}
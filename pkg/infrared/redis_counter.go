@@ -0,0 +1,302 @@
+package infrared
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+var ErrNoRedisAddr = errors.New("no redis address")
+
+const (
+	defaultRedisKeyPrefix = "infrared:ratelimit:"
+	defaultRedisTimeout   = time.Second
+	// redisMaxIdleConns is the number of connections that are kept open for reuse
+	redisMaxIdleConns = 8
+)
+
+type RedisLimitCounterConfig struct {
+	// Addr is the address of the Redis server like "localhost:6379"
+	Addr     string `yaml:"addr"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+	// KeyPrefix is prepended to all keys. Defaults to "infrared:ratelimit:".
+	KeyPrefix string `yaml:"keyPrefix"`
+	// Timeout of dialing and of every request. Defaults to 1s.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// redisError is an error reply of the Redis server.
+type redisError string
+
+func (err redisError) Error() string {
+	return "redis: " + string(err)
+}
+
+// redisConn is a connection that speaks the Redis serialization protocol (RESP).
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func (c *redisConn) writeCommand(args ...string) error {
+	if _, err := fmt.Fprintf(c.w, "*%d\r\n", len(args)); err != nil {
+		return err
+	}
+
+	for _, arg := range args {
+		if _, err := fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readReply reads a reply. Simple strings and bulk strings are returned as string,
+// integers as int64, arrays as []any and nil replies as nil.
+// Error replies are returned as redisError.
+func (c *redisConn) readReply() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: invalid reply %q", line)
+	}
+	kind, line := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, redisError(line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, err
+		}
+
+		if n < 0 {
+			return nil, nil
+		}
+
+		bb := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, bb); err != nil {
+			return nil, err
+		}
+		return string(bb[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, err
+		}
+
+		if n < 0 {
+			return nil, nil
+		}
+
+		replies := make([]any, n)
+		for i := range replies {
+			if replies[i], err = c.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return replies, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+	}
+}
+
+// do sends the commands at once and returns their replies.
+func (c *redisConn) do(cmds ...[]string) ([]any, error) {
+	for _, cmd := range cmds {
+		if err := c.writeCommand(cmd...); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]any, len(cmds))
+	for i := range replies {
+		reply, err := c.readReply()
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+
+	return replies, nil
+}
+
+// redisCounter counts the requests in Redis,
+// so that multiple Infrared instances share their counts.
+type redisCounter struct {
+	cfg RedisLimitCounterConfig
+	// expiry is how long a count is kept. Counts are used for two windows.
+	expiry time.Duration
+	idle   chan *redisConn
+}
+
+// NewRedisLimitCounter returns a LimitCounter that counts in Redis or any server
+// that is compatible with the Redis protocol. The server is dialed on first use.
+func NewRedisLimitCounter(cfg RedisLimitCounterConfig, windowLength time.Duration) (LimitCounter, error) {
+	if cfg.Addr == "" {
+		return nil, ErrNoRedisAddr
+	}
+
+	if cfg.KeyPrefix == "" {
+		cfg.KeyPrefix = defaultRedisKeyPrefix
+	}
+
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultRedisTimeout
+	}
+
+	return &redisCounter{
+		cfg:    cfg,
+		expiry: max(2*windowLength, time.Millisecond),
+		idle:   make(chan *redisConn, redisMaxIdleConns),
+	}, nil
+}
+
+func (rc *redisCounter) dial() (*redisConn, error) {
+	c, err := net.DialTimeout("tcp", rc.cfg.Addr, rc.cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &redisConn{
+		Conn: c,
+		r:    bufio.NewReader(c),
+		w:    bufio.NewWriter(c),
+	}
+
+	var cmds [][]string
+	if rc.cfg.Password != "" {
+		if rc.cfg.Username != "" {
+			cmds = append(cmds, []string{"AUTH", rc.cfg.Username, rc.cfg.Password})
+		} else {
+			cmds = append(cmds, []string{"AUTH", rc.cfg.Password})
+		}
+	}
+
+	if rc.cfg.DB != 0 {
+		cmds = append(cmds, []string{"SELECT", strconv.Itoa(rc.cfg.DB)})
+	}
+
+	if len(cmds) > 0 {
+		_ = conn.SetDeadline(time.Now().Add(rc.cfg.Timeout))
+		if _, err := conn.do(cmds...); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// conn returns an idle connection or dials a new one.
+func (rc *redisCounter) conn() (*redisConn, error) {
+	select {
+	case c := <-rc.idle:
+		return c, nil
+	default:
+		return rc.dial()
+	}
+}
+
+// release keeps c for reuse unless enough connections are idle.
+func (rc *redisCounter) release(c *redisConn) {
+	select {
+	case rc.idle <- c:
+	default:
+		_ = c.Close()
+	}
+}
+
+func (rc *redisCounter) key(key string, window time.Time) string {
+	return fmt.Sprintf("%s%016x", rc.cfg.KeyPrefix, limitCounterKey(key, window))
+}
+
+// do sends the commands on an idle connection and returns their replies.
+func (rc *redisCounter) do(cmds ...[]string) ([]any, error) {
+	c, err := rc.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	_ = c.SetDeadline(time.Now().Add(rc.cfg.Timeout))
+	replies, err := c.do(cmds...)
+	if err != nil {
+		// The connection might have unread replies
+		_ = c.Close()
+		return nil, err
+	}
+	rc.release(c)
+
+	return replies, nil
+}
+
+// Increment increments the count of the current window, sets its expiry and
+// reads the count of the previous window in one transaction.
+func (rc *redisCounter) Increment(key string, currentWindow, previousWindow time.Time) (int, int, error) {
+	currKey := rc.key(key, currentWindow)
+	replies, err := rc.do(
+		[]string{"MULTI"},
+		[]string{"INCR", currKey},
+		[]string{"PEXPIRE", currKey, strconv.FormatInt(rc.expiry.Milliseconds(), 10)},
+		[]string{"GET", rc.key(key, previousWindow)},
+		[]string{"EXEC"},
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	results, ok := replies[len(replies)-1].([]any)
+	if !ok || len(results) != 3 {
+		return 0, 0, fmt.Errorf("redis: invalid transaction reply %v", replies[len(replies)-1])
+	}
+
+	currCount, ok := results[0].(int64)
+	if !ok {
+		return 0, 0, fmt.Errorf("redis: invalid INCR reply %v", results[0])
+	}
+
+	var prevCount int
+	// Keys that do not exist have a nil reply
+	if s, ok := results[2].(string); ok {
+		if prevCount, err = strconv.Atoi(s); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return int(currCount), prevCount, nil
+}
+
+// Decrement decrements the count of the current window.
+// The count keeps the expiry that was set by Increment.
+func (rc *redisCounter) Decrement(key string, currentWindow time.Time) error {
+	replies, err := rc.do([]string{"DECR", rc.key(key, currentWindow)})
+	if err != nil {
+		return err
+	}
+
+	if _, ok := replies[0].(int64); !ok {
+		return fmt.Errorf("redis: invalid DECR reply %v", replies[0])
+	}
+
+	return nil
+}
@@ -0,0 +1,404 @@
+package infrared
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/haveachin/infrared/pkg/infrared/protocol"
+	"github.com/haveachin/infrared/pkg/infrared/protocol/configuration"
+	"github.com/haveachin/infrared/pkg/infrared/protocol/login"
+	"github.com/haveachin/infrared/pkg/infrared/protocol/play"
+)
+
+// errOpaqueStream is returned if a packet cannot be sent to the client,
+// because the stream of the server cannot be followed.
+var errOpaqueStream = errors.New("cannot send packets on an opaque stream")
+
+// errBrokenStream is returned if a packet cannot be sent to the client,
+// because writing a previous packet failed.
+var errBrokenStream = errors.New("cannot send packets on a broken stream")
+
+type relayState int
+
+const (
+	relayStateLogin relayState = iota
+	relayStateConfiguration
+	relayStatePlay
+)
+
+// serverRelay copies the packets of the server to the client.
+// It follows the state of the connection through the login, so that Infrared can
+// send a disconnect packet to the client in between two packets of the server.
+// Packets are buffered and sent to the client once no more packets of the server are buffered.
+//
+// If the player info for Velocity modern forwarding is set, the relay answers the
+// player info request of the server instead of relaying it to the client.
+//
+// In 1.20.2 and newer the server can switch back to the configuration state while playing.
+// This is not tracked, because it would require decoding every packet.
+type serverRelay struct {
+	src     *bufio.Reader
+	dst     io.Writer
+	version protocol.Version
+	// disconnectDst writes to the client like dst, but keeps the write deadline
+	// that limits how long sending the disconnect packet may take.
+	// If it is nil, dst is used.
+	disconnectDst io.Writer
+	// upstream is the writer to the server that the player info is sent to
+	upstream io.Writer
+	// velocityPlayerInfo is nil if Velocity modern forwarding is disabled
+	velocityPlayerInfo []byte
+
+	mu    sync.Mutex
+	state relayState
+	// compressionThreshold is negative if compression is disabled
+	compressionThreshold int
+	// opaque is set once the stream cannot be followed anymore,
+	// because it is encrypted or the client logged in with a version
+	// whose packets after the login are unknown.
+	// Opaque streams are copied as they are.
+	opaque bool
+	// disconnected is set once a disconnect packet was sent to the client.
+	// All following packets of the server are dropped.
+	disconnected bool
+	// broken is set once writing to the client failed.
+	// The client might have received a part of a packet, so nothing can be sent anymore.
+	broken bool
+
+	// w buffers the packets for the client
+	w   *bufio.Writer
+	buf []byte
+}
+
+func newServerRelay(src io.Reader, dst io.Writer, version protocol.Version) *serverRelay {
+	r := &serverRelay{
+		src:                  bufio.NewReader(src),
+		dst:                  dst,
+		version:              version,
+		compressionThreshold: -1,
+	}
+	r.w = bufio.NewWriter(relayWriter{r})
+	return r
+}
+
+// relayWriter writes the buffered packets of r to the client.
+// It is only written to while holding r.mu.
+type relayWriter struct {
+	r *serverRelay
+}
+
+func (w relayWriter) Write(b []byte) (int, error) {
+	if w.r.disconnected && w.r.disconnectDst != nil {
+		return w.r.disconnectDst.Write(b)
+	}
+	return w.r.dst.Write(b)
+}
+
+// run copies packets until reading from the server or writing to the client fails.
+func (r *serverRelay) run() error {
+	for {
+		r.mu.Lock()
+		opaque := r.opaque
+		// Buffered packets are sent before waiting for the server
+		var err error
+		if opaque || r.src.Buffered() == 0 {
+			err = r.flush()
+		}
+		r.mu.Unlock()
+		if err != nil {
+			return err
+		}
+
+		if opaque {
+			_, err := io.Copy(r.dst, r.src)
+			return err
+		}
+
+		frame, body, err := r.readFrame()
+		if err != nil {
+			return err
+		}
+
+		if err := r.relayFrame(frame, body); err != nil {
+			return err
+		}
+	}
+}
+
+// readFrame reads the next packet of the server without decoding it.
+// The returned frame includes the length prefix; body does not.
+func (r *serverRelay) readFrame() ([]byte, []byte, error) {
+	var frameLen protocol.VarInt
+	if _, err := frameLen.ReadFrom(r.src); err != nil {
+		return nil, nil, err
+	}
+
+	if frameLen < 0 || frameLen > protocol.MaxDataLength {
+		return nil, nil, fmt.Errorf("invalid packet length of %d", frameLen)
+	}
+
+	prefixLen := frameLen.Len()
+	n := prefixLen + int(frameLen)
+	if cap(r.buf) < n {
+		r.buf = make([]byte, n)
+	}
+	frame := r.buf[:n]
+	frameLen.WriteToBytes(frame)
+
+	body := frame[prefixLen:]
+	if _, err := io.ReadFull(r.src, body); err != nil {
+		return nil, nil, err
+	}
+
+	return frame, body, nil
+}
+
+func (r *serverRelay) relayFrame(frame, body []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.disconnected {
+		return nil
+	}
+
+	if r.state == relayStateLogin && r.velocityPlayerInfo != nil {
+		pk, err := r.decodePacket(body)
+		if err == nil && pk.ID == login.ClientBoundLoginPluginRequestID {
+			answered, err := r.answerPlayerInfoRequest(pk)
+			if answered || err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := r.w.Write(frame); err != nil {
+		r.broken = true
+		return err
+	}
+
+	if r.state == relayStatePlay {
+		return nil
+	}
+
+	pk, err := r.decodePacket(body)
+	if err != nil {
+		// The packet was already relayed, so only the state is lost
+		r.opaque = true
+		return nil
+	}
+	r.trackState(pk)
+
+	return nil
+}
+
+// answerPlayerInfoRequest sends the Velocity player info to the server
+// if pk requests it. It reports whether pk was answered.
+// It has to be called while holding r.mu.
+func (r *serverRelay) answerPlayerInfoRequest(pk protocol.Packet) (bool, error) {
+	var req login.ClientBoundLoginPluginRequest
+	if err := req.Unmarshal(pk); err != nil {
+		return false, nil //nolint:nilerr // Packets that cannot be decoded are relayed to the client
+	}
+
+	if req.Channel != velocityPlayerInfoChannel {
+		return false, nil
+	}
+
+	var respPk protocol.Packet
+	if err := (login.ServerBoundLoginPluginResponse{
+		MessageID:  req.MessageID,
+		Successful: true,
+		Data:       r.velocityPlayerInfo,
+	}).Marshal(&respPk); err != nil {
+		return true, err
+	}
+
+	frame, err := r.encodePacket(respPk)
+	if err != nil {
+		return true, err
+	}
+
+	_, err = r.upstream.Write(frame)
+	return true, err
+}
+
+// trackState has to be called while holding r.mu.
+func (r *serverRelay) trackState(pk protocol.Packet) {
+	switch r.state {
+	case relayStateLogin:
+		switch pk.ID {
+		case login.ClientBoundSetCompressionID:
+			var setCompression login.ClientBoundSetCompression
+			if err := setCompression.Unmarshal(pk); err != nil {
+				r.opaque = true
+				return
+			}
+			r.compressionThreshold = int(setCompression.Threshold)
+		case login.ClientBoundEncryptionRequestID:
+			// The client enables encryption right after it answers this request
+			r.opaque = true
+		case login.ClientBoundLoginSuccessID:
+			// The login packets are the same in all versions, but the following ones are not
+			if _, ok := play.ClientBoundDisconnectID(r.version); !ok {
+				r.opaque = true
+				return
+			}
+
+			if r.version >= protocol.Version1_20_2 {
+				r.state = relayStateConfiguration
+			} else {
+				r.state = relayStatePlay
+			}
+		}
+	case relayStateConfiguration:
+		if pk.ID == configuration.ClientBoundFinishConfigurationID {
+			r.state = relayStatePlay
+		}
+	case relayStatePlay:
+	}
+}
+
+// decodePacket decodes the body of a frame. If compression is enabled, the body is decompressed.
+func (r *serverRelay) decodePacket(body []byte) (protocol.Packet, error) {
+	var br io.Reader = bytes.NewReader(body)
+	if r.compressionThreshold >= 0 {
+		var dataLen protocol.VarInt
+		if _, err := dataLen.ReadFrom(br); err != nil {
+			return protocol.Packet{}, err
+		}
+
+		if dataLen < 0 || dataLen > protocol.MaxDataLength {
+			return protocol.Packet{}, fmt.Errorf("invalid uncompressed packet length of %d", dataLen)
+		}
+
+		if dataLen != 0 {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return protocol.Packet{}, err
+			}
+			defer zr.Close()
+
+			data := make([]byte, dataLen)
+			if _, err := io.ReadFull(zr, data); err != nil {
+				return protocol.Packet{}, err
+			}
+			br = bytes.NewReader(data)
+		}
+	}
+
+	var pkID protocol.VarInt
+	if _, err := pkID.ReadFrom(br); err != nil {
+		return protocol.Packet{}, err
+	}
+
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return protocol.Packet{}, err
+	}
+
+	return protocol.Packet{
+		ID:   int32(pkID),
+		Data: data,
+	}, nil
+}
+
+// encodePacket encodes pk as a frame and compresses it if compression is enabled.
+// It has to be called while holding r.mu.
+func (r *serverRelay) encodePacket(pk protocol.Packet) ([]byte, error) {
+	var frame bytes.Buffer
+	if r.compressionThreshold < 0 {
+		if _, err := pk.WriteTo(&frame); err != nil {
+			return nil, err
+		}
+		return frame.Bytes(), nil
+	}
+
+	var raw bytes.Buffer
+	_, _ = protocol.VarInt(pk.ID).WriteTo(&raw)
+	_, _ = raw.Write(pk.Data)
+
+	var body bytes.Buffer
+	if raw.Len() < r.compressionThreshold {
+		_, _ = protocol.VarInt(0).WriteTo(&body)
+		_, _ = body.Write(raw.Bytes())
+	} else {
+		_, _ = protocol.VarInt(raw.Len()).WriteTo(&body)
+		zw := zlib.NewWriter(&body)
+		if _, err := zw.Write(raw.Bytes()); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	_, _ = protocol.VarInt(body.Len()).WriteTo(&frame)
+	_, _ = frame.Write(body.Bytes())
+	return frame.Bytes(), nil
+}
+
+// disconnect sends a disconnect packet with the given reason to the client.
+// All following packets of the server are dropped.
+// If the stream is opaque, errOpaqueStream is returned.
+// If a previous write failed, errBrokenStream is returned.
+func (r *serverRelay) disconnect(reason any) error {
+	chat, err := marshalChat(reason)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.opaque {
+		return errOpaqueStream
+	}
+
+	if r.broken {
+		return errBrokenStream
+	}
+
+	if r.disconnected {
+		return nil
+	}
+
+	var pk protocol.Packet
+	switch r.state {
+	case relayStateLogin:
+		err = login.ClientBoundDisconnect{Reason: chat}.Marshal(&pk)
+	case relayStateConfiguration:
+		err = configuration.ClientBoundDisconnect{Reason: chat}.Marshal(&pk)
+	case relayStatePlay:
+		err = play.ClientBoundDisconnect{Reason: chat}.Marshal(&pk, r.version)
+	}
+	if err != nil {
+		return err
+	}
+
+	frame, err := r.encodePacket(pk)
+	if err != nil {
+		return err
+	}
+
+	r.disconnected = true
+	if _, err := r.w.Write(frame); err != nil {
+		r.broken = true
+		return err
+	}
+	return r.flush()
+}
+
+// flush sends the buffered packets to the client.
+// It has to be called while holding r.mu.
+func (r *serverRelay) flush() error {
+	if err := r.w.Flush(); err != nil {
+		r.broken = true
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package infrared
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/IGLOU-EU/go-wildcard"
+	"github.com/rs/zerolog"
+)
+
+var (
+	ErrRequestFiltered = errors.New("request filtered")
+	ErrInvalidName     = errors.New("invalid player name")
+)
+
+// RequestFilterer filters requests once the handshake of the client is read.
+// Unlike a Filterer, it knows the domain, the protocol version and if the client
+// wants to log in. For logins it also knows the name and the UUID of the player.
+// A returned error rejects the request. Errors that are a DisconnectError
+// carry the message for the player.
+type RequestFilterer interface {
+	FilterRequest(req ServerRequest) error
+}
+
+type RequestFilterFunc func(req ServerRequest) error
+
+func (f RequestFilterFunc) FilterRequest(req ServerRequest) error {
+	return f(req)
+}
+
+// RequestFiltersConfig are the built-in filters of a server.
+// The filters that also exist for connections filter by the address of the client.
+type RequestFiltersConfig struct {
+	RateLimiter *RateLimiterConfig      `yaml:"rateLimiter"`
+	IPFilter    *IPFilterConfig         `yaml:"ipFilter"`
+	GeoIP       *GeoIPFilterConfig      `yaml:"geoIP"`
+	PlayerName  *PlayerNameFilterConfig `yaml:"playerName"`
+
+	// onCounterError is called if the rate limiter cannot count requests
+	onCounterError func(err error)
+	// logger logs the files of filters that cannot be reloaded if set
+	logger *zerolog.Logger
+}
+
+type PlayerNameFilterConfig struct {
+	// Pattern is a regular expression that the names of players have to match if set
+	Pattern string `yaml:"pattern"`
+	// Deny are case-insensitive wildcard patterns of names that are denied
+	Deny []string `yaml:"deny"`
+}
+
+// PlayerNameFilter returns a RequestFilterer that denies logins by the name of the player.
+// Status requests are not filtered.
+func PlayerNameFilter(cfg PlayerNameFilterConfig) (RequestFilterer, error) {
+	var pattern *regexp.Regexp
+	if cfg.Pattern != "" {
+		var err error
+		pattern, err = regexp.Compile(cfg.Pattern)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	deny := upperAll(cfg.Deny)
+
+	return RequestFilterFunc(func(req ServerRequest) error {
+		if !req.IsLogin {
+			return nil
+		}
+
+		if pattern != nil && !pattern.MatchString(req.PlayerName) {
+			return fmt.Errorf("%w: %q", ErrInvalidName, req.PlayerName)
+		}
+
+		name := strings.ToUpper(req.PlayerName)
+		for _, p := range deny {
+			if wildcard.Match(p, name) {
+				return fmt.Errorf("%w: %q", ErrInvalidName, req.PlayerName)
+			}
+		}
+
+		return nil
+	}), nil
+}
+
+// addrConn is the connection that filters of connections see when they filter requests.
+// Only its remote address is known. Reading and writing fail and closing does nothing,
+// because the connection of the client is handled by Infrared.
+type addrConn struct {
+	addr net.Addr
+}
+
+func (c addrConn) Read([]byte) (int, error) {
+	return 0, net.ErrClosed
+}
+
+func (c addrConn) Write([]byte) (int, error) {
+	return 0, net.ErrClosed
+}
+
+func (c addrConn) Close() error {
+	return nil
+}
+
+func (c addrConn) LocalAddr() net.Addr {
+	return nil
+}
+
+func (c addrConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func (c addrConn) SetDeadline(time.Time) error {
+	return nil
+}
+
+func (c addrConn) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+func (c addrConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// ConnRequestFilter returns a RequestFilterer that filters
+// requests by the address of the client with f.
+// The filter can only use the remote address of the connection.
+func ConnRequestFilter(f Filterer) RequestFilterer {
+	return RequestFilterFunc(func(req ServerRequest) error {
+		return f.Filter(addrConn{
+			addr: req.ClientAddr,
+		})
+	})
+}
+
+// RequestFilter runs multiple request filters in order.
+// The zero value allows all requests.
+type RequestFilter struct {
+	filterers []RequestFilterer
+}
+
+// NewRequestFilter creates a filter from the built-in filters of cfg followed by filterers.
+func NewRequestFilter(cfg RequestFiltersConfig, filterers ...RequestFilterer) (RequestFilter, error) {
+	ff := make([]RequestFilterer, 0, len(filterers)+4)
+
+	if cfg.IPFilter != nil {
+		f, err := newIPFilter(*cfg.IPFilter, cfg.logger)
+		if err != nil {
+			return RequestFilter{}, fmt.Errorf("ip filter: %w", err)
+		}
+		ff = append(ff, ConnRequestFilter(f))
+	}
+
+	if cfg.GeoIP != nil {
+		f, err := newGeoIPFilter(*cfg.GeoIP, cfg.logger)
+		if err != nil {
+			return RequestFilter{}, fmt.Errorf("geoip filter: %w", err)
+		}
+		ff = append(ff, ConnRequestFilter(f))
+	}
+
+	if cfg.PlayerName != nil {
+		f, err := PlayerNameFilter(*cfg.PlayerName)
+		if err != nil {
+			return RequestFilter{}, fmt.Errorf("player name filter: %w", err)
+		}
+		ff = append(ff, f)
+	}
+
+	// The rate limiter is last, so that requests that other filters deny do not count
+	if cfg.RateLimiter != nil {
+		f, err := rateLimitFilter(*cfg.RateLimiter, cfg.onCounterError)
+		if err != nil {
+			return RequestFilter{}, fmt.Errorf("rate limiter: %w", err)
+		}
+		ff = append(ff, ConnRequestFilter(f))
+	}
+
+	return RequestFilter{
+		filterers: append(ff, filterers...),
+	}, nil
+}
+
+// FilterRequest returns the error of the first filter that rejects req wrapped in ErrRequestFiltered.
+func (f RequestFilter) FilterRequest(req ServerRequest) error {
+	for _, ff := range f.filterers {
+		if err := ff.FilterRequest(req); err != nil {
+			return fmt.Errorf("%w: %w", ErrRequestFiltered, err)
+		}
+	}
+	return nil
+}
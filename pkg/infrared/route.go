@@ -0,0 +1,72 @@
+package infrared
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/IGLOU-EU/go-wildcard"
+	"github.com/google/uuid"
+)
+
+var ErrRouteWithoutAddresses = errors.New("route has no addresses")
+
+// RouteConfig sends matching players to other addresses than the default addresses of a server.
+type RouteConfig struct {
+	// Players are case-insensitive wildcard patterns of player names
+	Players []string `yaml:"players"`
+	// UUIDs are wildcard patterns of player UUIDs like "069a79f4-44e9-4726-a5be-fca90e38aaf5".
+	// Only clients since 1.19 send their UUID.
+	UUIDs []string `yaml:"uuids"`
+	// ProtocolVersions is the range of protocol versions of the clients that match.
+	// Unlike player names and UUIDs it also applies to status requests.
+	ProtocolVersions *ProtocolVersionRange `yaml:"protocolVersions"`
+	Addresses        []ServerAddress       `yaml:"addresses"`
+}
+
+type route struct {
+	cfg      RouteConfig
+	backends []*backend
+}
+
+// matches reports whether the route applies to the request.
+// If players or UUIDs are configured, the player has to match one of them.
+// If protocol versions are configured, the version of the client has to be in range as well.
+func (r route) matches(req ServerRequest) bool {
+	if r.cfg.ProtocolVersions != nil && !r.cfg.ProtocolVersions.contains(req.ProtocolVersion) {
+		return false
+	}
+
+	if len(r.cfg.Players) == 0 && len(r.cfg.UUIDs) == 0 {
+		return true
+	}
+
+	return r.matchesPlayerName(req.PlayerName) || r.matchesPlayerUUID(req.PlayerUUID)
+}
+
+func (r route) matchesPlayerName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	name = strings.ToLower(name)
+	for _, pattern := range r.cfg.Players {
+		if wildcard.Match(strings.ToLower(pattern), name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r route) matchesPlayerUUID(id uuid.UUID) bool {
+	if id == uuid.Nil {
+		return false
+	}
+
+	idStr := id.String()
+	for _, pattern := range r.cfg.UUIDs {
+		if wildcard.Match(strings.ToLower(pattern), idStr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package infrared
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/IGLOU-EU/go-wildcard"
+)
+
+var (
+	ErrDuplicateDomain  = errors.New("duplicate domain")
+	ErrDuplicateDefault = errors.New("duplicate default server")
+)
+
+// regexpDomainPrefix marks a domain as a regular expression.
+const regexpDomainPrefix = "~"
+
+type wildcardRoute struct {
+	pattern string
+	// specificity is the number of characters that are not wildcards
+	specificity int
+	server      *Server
+}
+
+type regexpRoute struct {
+	re     *regexp.Regexp
+	server *Server
+}
+
+// router finds the server for a domain. Domains are matched in this order:
+//  1. exact domains like "play.example.com"
+//  2. wildcard domains like "*.example.com"; the most specific pattern wins
+//  3. regular expressions like "~^(eu|us)\.example\.com$" in config order
+//  4. the default server
+type router struct {
+	exact     map[string]*Server
+	wildcards []wildcardRoute
+	regexps   []regexpRoute
+	fallback  *Server
+}
+
+// newRouter builds a router for the domains of srvs. It returns an error
+// if two servers share a domain or if more than one server is the default.
+func newRouter(srvs []*Server) (*router, error) {
+	r := &router{
+		exact: make(map[string]*Server),
+	}
+
+	// owners maps every domain to the server that uses it to detect duplicates
+	owners := make(map[string]*Server)
+	for _, srv := range srvs {
+		if srv.cfg.Default {
+			if r.fallback != nil {
+				return nil, fmt.Errorf("%w: %q and %q", ErrDuplicateDefault, r.fallback.Name(), srv.Name())
+			}
+			r.fallback = srv
+		}
+
+		for _, d := range srv.cfg.Domains {
+			domain := strings.ToLower(string(d))
+			if owner, ok := owners[domain]; ok {
+				if owner == srv {
+					continue
+				}
+				return nil, fmt.Errorf("%w: %q is used by %q and %q", ErrDuplicateDomain, d, owner.Name(), srv.Name())
+			}
+			owners[domain] = srv
+
+			if err := r.add(string(d), srv); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sort.SliceStable(r.wildcards, func(i, j int) bool {
+		a, b := r.wildcards[i], r.wildcards[j]
+		if a.specificity != b.specificity {
+			return a.specificity > b.specificity
+		}
+		return len(a.pattern) > len(b.pattern)
+	})
+
+	return r, nil
+}
+
+func (r *router) add(domain string, srv *Server) error {
+	if expr, ok := strings.CutPrefix(domain, regexpDomainPrefix); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("invalid domain regexp of %q: %w", srv.Name(), err)
+		}
+
+		r.regexps = append(r.regexps, regexpRoute{
+			re:     re,
+			server: srv,
+		})
+		return nil
+	}
+
+	domain = strings.ToLower(domain)
+	if !strings.ContainsAny(domain, "*?") {
+		r.exact[domain] = srv
+		return nil
+	}
+
+	r.wildcards = append(r.wildcards, wildcardRoute{
+		pattern:     domain,
+		specificity: len(domain) - strings.Count(domain, "*") - strings.Count(domain, "?"),
+		server:      srv,
+	})
+	return nil
+}
+
+// route returns the server for the domain or nil if no server matches.
+func (r *router) route(domain ServerDomain) *Server {
+	dm := strings.ToLower(string(domain))
+
+	if srv, ok := r.exact[dm]; ok {
+		return srv
+	}
+
+	for _, route := range r.wildcards {
+		if wildcard.Match(route.pattern, dm) {
+			return route.server
+		}
+	}
+
+	for _, route := range r.regexps {
+		if route.re.MatchString(dm) {
+			return route.server
+		}
+	}
+
+	return r.fallback
+}
@@ -0,0 +1,164 @@
+package infrared_test
+
+import (
+	"errors"
+	"testing"
+
+	ir "github.com/haveachin/infrared/pkg/infrared"
+)
+
+type nopResponder struct{}
+
+func (nopResponder) RespondeToServerRequest(ir.ServerRequest, *ir.Server) (ir.ServerResponse, error) {
+	return ir.ServerResponse{}, nil
+}
+
+func newTestServer(t *testing.T, name string, fns ...ir.ServerConfigFunc) *ir.Server {
+	t.Helper()
+
+	fns = append([]ir.ServerConfigFunc{
+		ir.WithServerName(name),
+		ir.WithServerAddresses("localhost:25565"),
+	}, fns...)
+
+	srv, err := ir.NewServer(fns...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func TestServerGateway_RequestServer_Routing(t *testing.T) {
+	srvs := []*ir.Server{
+		newTestServer(t, "catch-all", ir.WithServerDomains("*")),
+		newTestServer(t, "subdomains", ir.WithServerDomains("*.example.com")),
+		newTestServer(t, "play", ir.WithServerDomains("play.example.com")),
+		newTestServer(t, "regions", ir.WithServerDomains(`~^(eu|us)\.example\.org$`)),
+		newTestServer(t, "lobby", ir.WithServerDomains("lobby.example.org"), ir.WithServerDefault(true)),
+	}
+
+	sg, err := ir.NewServerGateway(srvs, nopResponder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		domain ir.ServerDomain
+		want   string
+	}{
+		{
+			domain: "play.example.com",
+			want:   "play",
+		},
+		{
+			domain: "PLAY.Example.com",
+			want:   "play",
+		},
+		{
+			domain: "survival.example.com",
+			want:   "subdomains",
+		},
+		{
+			domain: "eu.example.org",
+			want:   "catch-all",
+		},
+		{
+			domain: "other.net",
+			want:   "catch-all",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(string(tc.domain), func(t *testing.T) {
+			for i := 0; i < 10; i++ {
+				resp, err := sg.RequestServer(ir.ServerRequest{Domain: tc.domain})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if resp.ServerName != tc.want {
+					t.Fatalf("got: %s; want: %s", resp.ServerName, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestServerGateway_RequestServer_RegexpAndDefault(t *testing.T) {
+	srvs := []*ir.Server{
+		newTestServer(t, "regions", ir.WithServerDomains(`~^(eu|us)\.example\.org$`)),
+		newTestServer(t, "lobby", ir.WithServerDomains("lobby.example.org"), ir.WithServerDefault(true)),
+	}
+
+	sg, err := ir.NewServerGateway(srvs, nopResponder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		domain ir.ServerDomain
+		want   string
+	}{
+		{
+			domain: "us.example.org",
+			want:   "regions",
+		},
+		{
+			domain: "asia.example.org",
+			want:   "lobby",
+		},
+	}
+
+	for _, tc := range tt {
+		resp, err := sg.RequestServer(ir.ServerRequest{Domain: tc.domain})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.ServerName != tc.want {
+			t.Fatalf("got: %s; want: %s", resp.ServerName, tc.want)
+		}
+	}
+}
+
+func TestNewServerGateway_Conflicts(t *testing.T) {
+	tt := []struct {
+		name    string
+		srvs    []*ir.Server
+		wantErr error
+	}{
+		{
+			name: "duplicate exact domain",
+			srvs: []*ir.Server{
+				newTestServer(t, "a", ir.WithServerDomains("play.example.com")),
+				newTestServer(t, "b", ir.WithServerDomains("Play.Example.com")),
+			},
+			wantErr: ir.ErrDuplicateDomain,
+		},
+		{
+			name: "duplicate wildcard",
+			srvs: []*ir.Server{
+				newTestServer(t, "a", ir.WithServerDomains("*")),
+				newTestServer(t, "b", ir.WithServerDomains("*")),
+			},
+			wantErr: ir.ErrDuplicateDomain,
+		},
+		{
+			name: "duplicate default",
+			srvs: []*ir.Server{
+				newTestServer(t, "a", ir.WithServerDefault(true)),
+				newTestServer(t, "b", ir.WithServerDefault(true)),
+			},
+			wantErr: ir.ErrDuplicateDefault,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ir.NewServerGateway(tc.srvs, nopResponder{})
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got: %v; want: %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -1,23 +1,33 @@
 package infrared
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/IGLOU-EU/go-wildcard"
-	"github.com/cespare/xxhash/v2"
+	"github.com/google/uuid"
 	"github.com/haveachin/infrared/pkg/infrared/protocol"
+	"github.com/haveachin/infrared/pkg/infrared/protocol/handshaking"
 	"github.com/haveachin/infrared/pkg/infrared/protocol/status"
+	"github.com/rs/zerolog"
+	"golang.org/x/sync/singleflight"
 )
 
 var (
 	ErrNoServers = errors.New("no servers to route to")
 )
 
+const (
+	defaultDialTimeout    = 5 * time.Second
+	defaultStatusCacheTTL = 30 * time.Second
+)
+
 type (
 	ServerAddress string
 	ServerDomain  string
@@ -31,26 +41,240 @@ func WithServerConfig(c ServerConfig) ServerConfigFunc {
 	}
 }
 
+func WithServerName(name string) ServerConfigFunc {
+	return func(cfg *ServerConfig) {
+		cfg.Name = name
+	}
+}
+
 func WithServerDomains(sd ...ServerDomain) ServerConfigFunc {
 	return func(cfg *ServerConfig) {
 		cfg.Domains = sd
 	}
 }
 
+func WithServerDefault(isDefault bool) ServerConfigFunc {
+	return func(cfg *ServerConfig) {
+		cfg.Default = isDefault
+	}
+}
+
+func WithServerListeners(names ...string) ServerConfigFunc {
+	return func(cfg *ServerConfig) {
+		cfg.Listeners = names
+	}
+}
+
 func WithServerAddresses(addr ...ServerAddress) ServerConfigFunc {
 	return func(cfg *ServerConfig) {
 		cfg.Addresses = addr
 	}
 }
 
+func WithServerRoutes(routes ...RouteConfig) ServerConfigFunc {
+	return func(cfg *ServerConfig) {
+		cfg.Routes = routes
+	}
+}
+
+func WithServerSupportedVersions(c SupportedVersionsConfig) ServerConfigFunc {
+	return func(cfg *ServerConfig) {
+		cfg.SupportedVersions = &c
+	}
+}
+
+func WithServerDisconnectMessages(msgs DisconnectMessagesConfig) ServerConfigFunc {
+	return func(cfg *ServerConfig) {
+		cfg.DisconnectMessages = msgs
+	}
+}
+
+func WithServerOfflineStatus(c StatusResponseConfig) ServerConfigFunc {
+	return func(cfg *ServerConfig) {
+		cfg.OfflineStatus = &c
+	}
+}
+
+func WithServerStatusOverride(c StatusResponseConfig) ServerConfigFunc {
+	return func(cfg *ServerConfig) {
+		cfg.StatusOverride = &c
+	}
+}
+
+func WithServerStatusCache(ttl time.Duration, backgroundRefresh bool) ServerConfigFunc {
+	return func(cfg *ServerConfig) {
+		cfg.StatusCacheTTL = &ttl
+		cfg.StatusCacheBackgroundRefresh = backgroundRefresh
+	}
+}
+
+func WithServerHandshake(c HandshakeConfig) ServerConfigFunc {
+	return func(cfg *ServerConfig) {
+		cfg.Handshake = c
+	}
+}
+
+func WithServerSendRealIP(c RealIPConfig) ServerConfigFunc {
+	return func(cfg *ServerConfig) {
+		cfg.SendRealIP = &c
+	}
+}
+
+func WithServerBungeeCordForwarding(c BungeeCordForwardingConfig) ServerConfigFunc {
+	return func(cfg *ServerConfig) {
+		cfg.BungeeCordForwarding = &c
+	}
+}
+
+func WithServerVelocityForwarding(c VelocityForwardingConfig) ServerConfigFunc {
+	return func(cfg *ServerConfig) {
+		cfg.VelocityForwarding = &c
+	}
+}
+
+func WithServerPlayerLists(c PlayerListsConfig) ServerConfigFunc {
+	return func(cfg *ServerConfig) {
+		cfg.PlayerLists = c
+	}
+}
+
+func WithServerFilters(c RequestFiltersConfig) ServerConfigFunc {
+	return func(cfg *ServerConfig) {
+		cfg.Filters = c
+	}
+}
+
+// withServerOnCounterError reports the errors of the request counter of the rate limiter to fn.
+func withServerOnCounterError(fn func(err error)) ServerConfigFunc {
+	return func(cfg *ServerConfig) {
+		cfg.Filters.onCounterError = fn
+	}
+}
+
+// withServerLogger logs the files of the filters of the server that cannot be reloaded to logger.
+func withServerLogger(logger *zerolog.Logger) ServerConfigFunc {
+	return func(cfg *ServerConfig) {
+		cfg.Filters.logger = logger
+	}
+}
+
+func WithServerRequestFilterers(filterers ...RequestFilterer) ServerConfigFunc {
+	return func(cfg *ServerConfig) {
+		cfg.RequestFilterers = append(cfg.RequestFilterers, filterers...)
+	}
+}
+
+func WithServerLoadBalancerStrategy(strategy LoadBalancerStrategy) ServerConfigFunc {
+	return func(cfg *ServerConfig) {
+		cfg.LoadBalancer.Strategy = strategy
+	}
+}
+
 type ServerConfig struct {
-	Domains           []ServerDomain  `yaml:"domains"`
-	Addresses         []ServerAddress `yaml:"addresses"`
-	SendProxyProtocol bool            `yaml:"sendProxyProtocol"`
+	// Name identifies the server in logs and metrics.
+	// Defaults to the first domain of the server.
+	Name string `yaml:"name"`
+	// Domains can be exact domains, wildcard patterns with '*' and '?'
+	// or regular expressions that are prefixed with '~'
+	Domains []ServerDomain `yaml:"domains"`
+	// Default makes this server the fallback for domains that match no server
+	Default bool `yaml:"default"`
+	// Listeners limits the server to the listeners with these names.
+	// The server is available on all listeners if it is empty.
+	Listeners []string        `yaml:"listeners"`
+	Addresses []ServerAddress `yaml:"addresses"`
+	// Routes send matching players to other addresses.
+	// The first matching route is used; if none matches, the addresses are used.
+	Routes            []RouteConfig      `yaml:"routes"`
+	SendProxyProtocol bool               `yaml:"sendProxyProtocol"`
+	LoadBalancer      LoadBalancerConfig `yaml:"loadBalancer"`
+	DialTimeout       time.Duration      `yaml:"dialTimeout"`
+	HealthCheck       *HealthCheckConfig `yaml:"healthCheck"`
+	// Handshake rewrites the handshake that is forwarded to the server
+	Handshake HandshakeConfig `yaml:"handshake"`
+	// SendRealIP forwards the address of the client in the handshake
+	// in the RealIP format if set
+	SendRealIP *RealIPConfig `yaml:"sendRealIP"`
+	// BungeeCordForwarding forwards the address and UUID of the player
+	// like BungeeCord with IP forwarding if set
+	BungeeCordForwarding *BungeeCordForwardingConfig `yaml:"bungeeCordForwarding"`
+	// VelocityForwarding answers the player info request of servers
+	// with Velocity modern forwarding if set
+	VelocityForwarding *VelocityForwardingConfig `yaml:"velocityForwarding"`
+	// DisconnectMessages overrides the global disconnect messages for this server
+	DisconnectMessages DisconnectMessagesConfig `yaml:"disconnectMessages"`
+	// OfflineStatus is sent as the status response if the server is unreachable
+	OfflineStatus *StatusResponseConfig `yaml:"offlineStatus"`
+	// StatusOverride replaces the fields of the status response of the server that are set
+	StatusOverride *StatusResponseConfig `yaml:"statusOverride"`
+	// StatusCacheTTL is the duration a status response is cached for each
+	// domain and protocol version. Set it to zero to disable the cache.
+	StatusCacheTTL *time.Duration `yaml:"statusCacheTTL"`
+	// StatusCacheBackgroundRefresh refreshes expired status responses in the background
+	// while still serving the expired ones.
+	StatusCacheBackgroundRefresh bool `yaml:"statusCacheBackgroundRefresh"`
+	// SupportedVersions rejects logins of clients with other protocol versions
+	SupportedVersions *SupportedVersionsConfig `yaml:"supportedVersions"`
+	// PlayerLists are checked in addition to the global player lists
+	PlayerLists PlayerListsConfig `yaml:"playerLists"`
+	// Filters are the built-in request filters of the server.
+	// They run in addition to the global filters.
+	Filters RequestFiltersConfig `yaml:"filters"`
+	// RequestFilterers run after the built-in filters
+	RequestFilterers []RequestFilterer `yaml:"-"`
+}
+
+// referencesFiles reports if the server loads files like keys or icons.
+// Servers that do are built again on every reload, so that changed files are read.
+func (cfg ServerConfig) referencesFiles() bool {
+	for _, status := range []*StatusResponseConfig{cfg.OfflineStatus, cfg.StatusOverride} {
+		if status != nil && status.IconPath != "" {
+			return true
+		}
+	}
+
+	if cfg.Filters.IPFilter != nil && cfg.Filters.IPFilter.DenyFile != "" {
+		return true
+	}
+
+	return cfg.SendRealIP != nil && cfg.SendRealIP.PrivateKeyFile != "" ||
+		cfg.VelocityForwarding != nil && cfg.VelocityForwarding.SecretFile != "" ||
+		cfg.PlayerLists.Whitelist != "" || cfg.PlayerLists.BannedPlayers != "" ||
+		cfg.Filters.GeoIP != nil
+}
+
+func (cfg ServerConfig) name() string {
+	if cfg.Name != "" {
+		return cfg.Name
+	}
+
+	if len(cfg.Domains) > 0 {
+		return string(cfg.Domains[0])
+	}
+
+	return ""
 }
 
 type Server struct {
 	cfg ServerConfig
+	// backends are all distinct addresses of the server including the ones of routes
+	backends []*backend
+	// defaultBackends are the backends of the addresses of the server
+	defaultBackends []*backend
+	routes          []route
+	lb              loadBalancer
+	offlineStatus   *statusResponse
+	statusOverride  *statusResponse
+	// realIPSigner is nil if RealIP is not sent
+	realIPSigner *realIPSigner
+	// velocityForwarder is nil if Velocity modern forwarding is disabled
+	velocityForwarder *velocityForwarder
+	// playerLists is nil if the server has no player lists
+	playerLists *playerLists
+	filter      RequestFilter
+	metrics     *metrics
+	// statusProvider caches the status responses of the server
+	statusProvider *statusResponseProvider
 }
 
 func NewServer(fns ...ServerConfigFunc) (*Server, error) {
@@ -63,18 +287,257 @@ func NewServer(fns ...ServerConfigFunc) (*Server, error) {
 		return nil, errors.New("no addresses")
 	}
 
-	return &Server{
-		cfg: cfg,
-	}, nil
-}
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = defaultDialTimeout
+	}
+
+	lb, err := newLoadBalancer(cfg.LoadBalancer)
+	if err != nil {
+		return nil, err
+	}
+
+	// Addresses that are used multiple times share their state like health
+	backendsByAddr := make(map[ServerAddress]*backend)
+	backends := make([]*backend, 0, len(cfg.Addresses))
+	newBackends := func(addrs []ServerAddress) []*backend {
+		bb := make([]*backend, len(addrs))
+		for i, addr := range addrs {
+			b, ok := backendsByAddr[addr]
+			if !ok {
+				b = newBackend(addr)
+				backendsByAddr[addr] = b
+				backends = append(backends, b)
+			}
+			bb[i] = b
+		}
+		return bb
+	}
+
+	defaultBackends := newBackends(cfg.Addresses)
+	routes := make([]route, len(cfg.Routes))
+	for i, rCfg := range cfg.Routes {
+		if len(rCfg.Addresses) == 0 {
+			return nil, ErrRouteWithoutAddresses
+		}
+
+		routes[i] = route{
+			cfg:      rCfg,
+			backends: newBackends(rCfg.Addresses),
+		}
+	}
+
+	if cfg.HealthCheck != nil {
+		hcCfg := cfg.HealthCheck.withDefaults()
+		cfg.HealthCheck = &hcCfg
+	}
+
+	offlineStatus, err := newStatusResponse(cfg.OfflineStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	statusOverride, err := newStatusResponse(cfg.StatusOverride)
+	if err != nil {
+		return nil, err
+	}
+
+	forwardings := 0
+	for _, enabled := range []bool{
+		cfg.SendRealIP != nil,
+		cfg.BungeeCordForwarding != nil,
+		cfg.VelocityForwarding != nil,
+	} {
+		if enabled {
+			forwardings++
+		}
+	}
+	if forwardings > 1 {
+		return nil, ErrConflictingForwarding
+	}
 
-func (s Server) Dial() (*ServerConn, error) {
-	c, err := net.Dial("tcp", string(s.cfg.Addresses[0]))
+	var signer *realIPSigner
+	if cfg.SendRealIP != nil {
+		signer, err = loadRealIPSigner(cfg.SendRealIP.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var velocity *velocityForwarder
+	if cfg.VelocityForwarding != nil {
+		velocity, err = newVelocityForwarder(*cfg.VelocityForwarding)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	lists, err := newPlayerLists(cfg.PlayerLists)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewServerConn(c), nil
+	filter, err := NewRequestFilter(cfg.Filters, cfg.RequestFilterers...)
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &Server{
+		cfg:               cfg,
+		backends:          backends,
+		defaultBackends:   defaultBackends,
+		routes:            routes,
+		lb:                lb,
+		offlineStatus:     offlineStatus,
+		statusOverride:    statusOverride,
+		realIPSigner:      signer,
+		velocityForwarder: velocity,
+		playerLists:       lists,
+		filter:            filter,
+	}
+	srv.statusProvider = newStatusResponseProvider(srv)
+
+	return srv, nil
+}
+
+// takeOverBackends replaces the backends of s with the backends of old that have
+// the same address, so that their health and active connections are kept.
+// If s has no health checks, the backends are healthy again, because nothing
+// would put them back into rotation.
+func (s *Server) takeOverBackends(old *Server) {
+	oldByAddr := make(map[ServerAddress]*backend, len(old.backends))
+	for _, b := range old.backends {
+		if s.cfg.HealthCheck == nil {
+			b.resetHealth()
+		}
+		oldByAddr[b.addr] = b
+	}
+
+	replace := func(bb []*backend) {
+		for i, b := range bb {
+			if ob, ok := oldByAddr[b.addr]; ok {
+				bb[i] = ob
+			}
+		}
+	}
+
+	replace(s.backends)
+	replace(s.defaultBackends)
+	for _, r := range s.routes {
+		replace(r.backends)
+	}
+}
+
+// Name returns the name of the server or its first domain if no name is configured.
+func (s *Server) Name() string {
+	return s.cfg.name()
+}
+
+// Dial connects to one of the server addresses chosen by the load balancer.
+// If a route matches the request, one of the addresses of the route is chosen instead.
+// If dialing an address fails, the next address in line is tried.
+func (s *Server) Dial(req ServerRequest) (*ServerConn, error) {
+	backends := healthyBackends(s.routeBackends(req))
+	if len(backends) == 0 {
+		return nil, ErrNoHealthyAddresses
+	}
+
+	var errs []error
+	for _, b := range s.lb.order(req, backends) {
+		start := time.Now()
+		c, err := net.DialTimeout("tcp", string(b.addr), s.cfg.DialTimeout)
+		s.metrics.dialed(s.Name(), b.addr, time.Since(start), err)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		b.activeConns.Add(1)
+		rc := NewServerConn(c)
+		rc.onClose = func() {
+			b.activeConns.Add(-1)
+		}
+		return rc, nil
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// routeBackends returns the backends of the first route that matches the request
+// or the default backends if no route matches.
+func (s *Server) routeBackends(req ServerRequest) []*backend {
+	for _, r := range s.routes {
+		if r.matches(req) {
+			return r.backends
+		}
+	}
+	return s.defaultBackends
+}
+
+func (s *Server) supportsVersion(v protocol.Version) bool {
+	return s.cfg.SupportedVersions == nil || s.cfg.SupportedVersions.contains(v)
+}
+
+// handshake returns the handshake packet of the request that is forwarded to the server.
+func (s *Server) handshake(req ServerRequest) (protocol.Packet, error) {
+	pk := req.ReadPackets[0]
+	if s.cfg.Handshake.isZero() && s.realIPSigner == nil && s.cfg.BungeeCordForwarding == nil {
+		return pk, nil
+	}
+
+	var hs handshaking.ServerBoundHandshake
+	if err := hs.Unmarshal(pk); err != nil {
+		return protocol.Packet{}, err
+	}
+
+	s.cfg.Handshake.rewrite(&hs)
+
+	if s.realIPSigner != nil {
+		if err := hs.UpgradeToSignedRealIP(req.ClientAddr, time.Now(), s.realIPSigner.sign); err != nil {
+			return protocol.Packet{}, err
+		}
+	}
+
+	// Servers only expect the forwarded player in the handshake of a login
+	if s.cfg.BungeeCordForwarding != nil && req.IsLogin {
+		if err := s.cfg.BungeeCordForwarding.forward(&hs, req); err != nil {
+			return protocol.Packet{}, err
+		}
+	}
+
+	var rewritten protocol.Packet
+	if err := hs.Marshal(&rewritten); err != nil {
+		return protocol.Packet{}, err
+	}
+	return rewritten, nil
+}
+
+func healthyBackends(bb []*backend) []*backend {
+	backends := make([]*backend, 0, len(bb))
+	for _, b := range bb {
+		if b.healthy.Load() {
+			backends = append(backends, b)
+		}
+	}
+	return backends
+}
+
+// RunHealthChecks blocks and checks the health of all addresses until ctx is done.
+// If health checks are disabled, it returns immediately.
+func (s *Server) RunHealthChecks(ctx context.Context, logger zerolog.Logger) {
+	if s.cfg.HealthCheck == nil {
+		return
+	}
+
+	domains := make([]string, len(s.cfg.Domains))
+	for i, d := range s.cfg.Domains {
+		domains[i] = string(d)
+	}
+
+	healthChecker{
+		cfg:    *s.cfg.HealthCheck,
+		server: s,
+		logger: logger.With().Strs("domains", domains).Logger(),
+	}.Run(ctx)
 }
 
 type ServerRequest struct {
@@ -83,12 +546,28 @@ type ServerRequest struct {
 	IsLogin         bool
 	ProtocolVersion protocol.Version
 	ReadPackets     [2]protocol.Packet
+	// PlayerName is only set for login requests
+	PlayerName string
+	// PlayerUUID is only set for login requests of clients that send it (1.19 and newer)
+	PlayerUUID uuid.UUID
+	// IsAuthenticated is set if the player was authenticated in online mode.
+	// Otherwise the name and the UUID of the player are not verified.
+	IsAuthenticated bool
+	// PlayerProperties are the properties of the profile of the player like the skin.
+	// They are only set for players that were authenticated in online mode.
+	PlayerProperties []ProfileProperty
 }
 
 type ServerResponse struct {
+	// ServerName is the name of the server that responded to the request
+	ServerName        string
 	ServerConn        *ServerConn
 	StatusResponse    protocol.Packet
 	SendProxyProtocol bool
+	// Handshake replaces the handshake of the client that is forwarded to the server if set
+	Handshake *protocol.Packet
+	// VelocityPlayerInfo answers the Velocity player info request of the server if set
+	VelocityPlayerInfo []byte
 }
 
 type ServerRequester interface {
@@ -103,65 +582,60 @@ func (fn ServerRequesterFunc) RequestServer(req ServerRequest) (ServerResponse,
 
 type ServerGateway struct {
 	responder ServerRequestResponder
-	servers   map[ServerDomain]*Server
+	router    *router
 }
 
+// NewServerGateway creates a gateway that routes requests to servers by their domain.
+// It returns an error if domains are used by more than one server.
 func NewServerGateway(servers []*Server, responder ServerRequestResponder) (*ServerGateway, error) {
 	if len(servers) == 0 {
 		return nil, ErrNoServers
 	}
 
-	srvs := make(map[ServerDomain]*Server)
-	for _, srv := range servers {
-		for _, d := range srv.cfg.Domains {
-			dStr := string(d)
-			dStr = strings.ToLower(dStr)
-			dmn := ServerDomain(dStr)
-			srvs[dmn] = srv
-		}
+	r, err := newRouter(servers)
+	if err != nil {
+		return nil, err
 	}
 
 	if responder == nil {
-		responder = DialServerResponder{
-			respProvs: make(map[*Server]StatusResponseProvider),
-		}
+		responder = &DialServerResponder{}
 	}
 
 	return &ServerGateway{
-		servers:   srvs,
+		router:    r,
 		responder: responder,
 	}, nil
 }
 
-func (sg *ServerGateway) findServer(domain ServerDomain) *Server {
-	dm := string(domain)
-	dm = strings.ToLower(dm)
-	for d, srv := range sg.servers {
-		if wildcard.Match(string(d), dm) {
-			return srv
-		}
-	}
-	return nil
-}
-
 func (sg *ServerGateway) RequestServer(req ServerRequest) (ServerResponse, error) {
-	srv := sg.findServer(req.Domain)
+	srv := sg.router.route(req.Domain)
 	if srv == nil {
-		return ServerResponse{}, errors.New("server not found")
+		return ServerResponse{}, ErrServerNotFound
 	}
 
-	return sg.responder.RespondeToServerRequest(req, srv)
+	resp, err := sg.responder.RespondeToServerRequest(req, srv)
+	if err != nil {
+		return ServerResponse{}, err
+	}
+	resp.ServerName = srv.Name()
+
+	return resp, nil
 }
 
 type ServerRequestResponder interface {
 	RespondeToServerRequest(ServerRequest, *Server) (ServerResponse, error)
 }
 
-type DialServerResponder struct {
-	respProvs map[*Server]StatusResponseProvider
-}
+type DialServerResponder struct{}
+
+func (r *DialServerResponder) RespondeToServerRequest(req ServerRequest, srv *Server) (ServerResponse, error) {
+	if err := srv.filter.FilterRequest(req); err != nil {
+		return ServerResponse{}, DisconnectError{
+			Reason: firstNonNil(filterMessage(err, srv.cfg.DisconnectMessages), srv.cfg.DisconnectMessages.Filtered),
+			Err:    err,
+		}
+	}
 
-func (r DialServerResponder) RespondeToServerRequest(req ServerRequest, srv *Server) (ServerResponse, error) {
 	if req.IsLogin {
 		return r.respondeToLoginRequest(req, srv)
 	}
@@ -169,42 +643,78 @@ func (r DialServerResponder) RespondeToServerRequest(req ServerRequest, srv *Ser
 	return r.respondeToStatusRequest(req, srv)
 }
 
-func (r DialServerResponder) respondeToLoginRequest(_ ServerRequest, srv *Server) (ServerResponse, error) {
-	rc, err := srv.Dial()
+func (r *DialServerResponder) respondeToLoginRequest(req ServerRequest, srv *Server) (ServerResponse, error) {
+	if !srv.supportsVersion(req.ProtocolVersion) {
+		return ServerResponse{}, DisconnectError{
+			Reason: srv.cfg.DisconnectMessages.UnsupportedVersion,
+			Err:    ErrUnsupportedClientVersion,
+		}
+	}
+
+	if err := srv.playerLists.check(req); err != nil {
+		reason := srv.cfg.DisconnectMessages.Banned
+		if errors.Is(err, ErrNotWhitelisted) {
+			reason = srv.cfg.DisconnectMessages.NotWhitelisted
+		}
+
+		return ServerResponse{}, DisconnectError{
+			Reason: reason,
+			Err:    err,
+		}
+	}
+
+	rc, err := srv.Dial(req)
 	if err != nil {
+		return ServerResponse{}, DisconnectError{
+			Reason: srv.cfg.DisconnectMessages.ServerUnreachable,
+			Err:    fmt.Errorf("%w: %w", ErrServerUnreachable, err),
+		}
+	}
+
+	hsPk, err := srv.handshake(req)
+	if err != nil {
+		_ = rc.Close()
 		return ServerResponse{}, err
 	}
 
+	var playerInfo []byte
+	if srv.velocityForwarder != nil {
+		playerInfo, err = srv.velocityForwarder.playerInfo(req)
+		if err != nil {
+			_ = rc.Close()
+			return ServerResponse{}, err
+		}
+	}
+
 	return ServerResponse{
-		ServerConn:        rc,
-		SendProxyProtocol: srv.cfg.SendProxyProtocol,
+		ServerConn:         rc,
+		SendProxyProtocol:  srv.cfg.SendProxyProtocol,
+		Handshake:          &hsPk,
+		VelocityPlayerInfo: playerInfo,
 	}, nil
 }
 
-func (r DialServerResponder) respondeToStatusRequest(req ServerRequest, srv *Server) (ServerResponse, error) {
-	respProv, ok := r.respProvs[srv]
-	if !ok {
-		respProv = &statusResponseProvider{
-			server:              srv,
-			cacheTTL:            30 * time.Second,
-			statusHash:          make(map[protocol.Version]uint64),
-			statusResponseCache: make(map[uint64]*statusCacheEntry),
-		}
-		r.respProvs[srv] = respProv
-	}
-
-	_, pk, err := respProv.StatusResponse(req.ClientAddr, req.ProtocolVersion, req.ReadPackets)
+func (r *DialServerResponder) respondeToStatusRequest(req ServerRequest, srv *Server) (ServerResponse, error) {
+	respJSON, pk, err := srv.statusProvider.StatusResponse(req)
 	if err != nil {
 		return ServerResponse{}, err
 	}
 
+	if !srv.supportsVersion(req.ProtocolVersion) {
+		respJSON = srv.cfg.SupportedVersions.unsupportedVersionStatus(respJSON)
+		pk, err = marshalStatusResponse(respJSON)
+		if err != nil {
+			return ServerResponse{}, err
+		}
+	}
+
 	return ServerResponse{
 		StatusResponse: pk,
 	}, nil
 }
 
 type StatusResponseProvider interface {
-	StatusResponse(net.Addr, protocol.Version, [2]protocol.Packet) (status.ResponseJSON, protocol.Packet, error)
+	StatusResponse(ServerRequest) (status.ResponseJSON, protocol.Packet, error)
 }
 
 type statusCacheEntry struct {
@@ -217,31 +727,76 @@ func (e statusCacheEntry) isExpired() bool {
 	return e.expiresAt.Before(time.Now())
 }
 
+type statusCacheKey struct {
+	domain  ServerDomain
+	version protocol.Version
+}
+
+func (k statusCacheKey) String() string {
+	return fmt.Sprintf("%d:%s", k.version, k.domain)
+}
+
 type statusResponseProvider struct {
-	server *Server
+	server            *Server
+	cacheTTL          time.Duration
+	backgroundRefresh bool
+	// domains holds the lower-cased exact domains of the server that get their own cache entry
+	domains map[ServerDomain]struct{}
+
+	mu    sync.Mutex
+	cache map[statusCacheKey]*statusCacheEntry
+	// fetches collapses concurrent requests for the same cache entry into one
+	fetches singleflight.Group
+}
 
-	mu                  sync.Mutex
-	cacheTTL            time.Duration
-	statusHash          map[protocol.Version]uint64
-	statusResponseCache map[uint64]*statusCacheEntry
+func newStatusResponseProvider(srv *Server) *statusResponseProvider {
+	cacheTTL := defaultStatusCacheTTL
+	if srv.cfg.StatusCacheTTL != nil {
+		cacheTTL = *srv.cfg.StatusCacheTTL
+	}
+
+	// The server gets the same handshake for every domain if the server address is replaced
+	domains := make(map[ServerDomain]struct{})
+	if srv.cfg.Handshake.ServerAddress == "" {
+		for _, d := range srv.cfg.Domains {
+			if strings.HasPrefix(string(d), regexpDomainPrefix) || strings.ContainsAny(string(d), "*?") {
+				continue
+			}
+			domains[ServerDomain(strings.ToLower(string(d)))] = struct{}{}
+		}
+	}
+
+	return &statusResponseProvider{
+		server:            srv,
+		cacheTTL:          cacheTTL,
+		backgroundRefresh: srv.cfg.StatusCacheBackgroundRefresh,
+		domains:           domains,
+		cache:             make(map[statusCacheKey]*statusCacheEntry),
+	}
 }
 
-func (s *statusResponseProvider) requestNewStatusResponseJSON(
-	cliAddr net.Addr,
-	readPks [2]protocol.Packet,
-) (status.ResponseJSON, protocol.Packet, error) {
-	rc, err := s.server.Dial()
+func (s *statusResponseProvider) requestNewStatusResponseJSON(req ServerRequest) (status.ResponseJSON, protocol.Packet, error) {
+	rc, err := s.server.Dial(ServerRequest{
+		ClientAddr:      req.ClientAddr,
+		ProtocolVersion: req.ProtocolVersion,
+	})
 	if err != nil {
 		return status.ResponseJSON{}, protocol.Packet{}, err
 	}
+	defer rc.Close()
 
 	if s.server.cfg.SendProxyProtocol {
-		if err := writeProxyProtocolHeader(cliAddr, rc); err != nil {
+		if err := writeProxyProtocolHeader(req.ClientAddr, rc); err != nil {
 			return status.ResponseJSON{}, protocol.Packet{}, err
 		}
 	}
 
-	if err := rc.WritePackets(readPks[0], readPks[1]); err != nil {
+	hsPk, err := s.server.handshake(req)
+	if err != nil {
+		return status.ResponseJSON{}, protocol.Packet{}, err
+	}
+
+	if err := rc.WritePackets(hsPk, req.ReadPackets[1]); err != nil {
 		return status.ResponseJSON{}, protocol.Packet{}, err
 	}
 
@@ -249,7 +804,6 @@ func (s *statusResponseProvider) requestNewStatusResponseJSON(
 	if err := rc.ReadPacket(&pk); err != nil {
 		return status.ResponseJSON{}, protocol.Packet{}, err
 	}
-	rc.Close()
 
 	var respPk status.ClientBoundResponse
 	if err := respPk.Unmarshal(pk); err != nil {
@@ -261,72 +815,128 @@ func (s *statusResponseProvider) requestNewStatusResponseJSON(
 		return status.ResponseJSON{}, protocol.Packet{}, err
 	}
 
+	if s.server.statusOverride != nil {
+		respJSON = s.server.statusOverride.override(respJSON)
+		pk, err = marshalStatusResponse(respJSON)
+		if err != nil {
+			return status.ResponseJSON{}, protocol.Packet{}, err
+		}
+	}
+
 	return respJSON, pk, nil
 }
 
-func (s *statusResponseProvider) StatusResponse(
-	cliAddr net.Addr,
-	protVer protocol.Version,
-	readPks [2]protocol.Packet,
-) (status.ResponseJSON, protocol.Packet, error) {
+func (s *statusResponseProvider) StatusResponse(req ServerRequest) (status.ResponseJSON, protocol.Packet, error) {
+	respJSON, pk, err := s.cachedStatusResponse(req)
+	if err != nil && s.server.offlineStatus != nil {
+		respJSON = s.server.offlineStatus.responseJSON(req.ProtocolVersion)
+		pk, err = marshalStatusResponse(respJSON)
+	}
+
+	return respJSON, pk, err
+}
+
+// cachedStatusResponse returns the cached status response for the domain and protocol version
+// of the request. With background refresh enabled, expired responses are returned
+// while they are refreshed in the background.
+func (s *statusResponseProvider) cachedStatusResponse(req ServerRequest) (status.ResponseJSON, protocol.Packet, error) {
 	if s.cacheTTL <= 0 {
-		return s.requestNewStatusResponseJSON(cliAddr, readPks)
+		return s.requestNewStatusResponseJSON(req)
 	}
 
+	key := s.cacheKey(req)
+
+	s.mu.Lock()
 	// Prunes all expired status reponses
 	s.prune()
+	entry, ok := s.cache[key]
+	fresh := ok && !entry.isExpired()
+	s.server.metrics.statusCacheLookup(s.server.Name(), fresh)
+	s.mu.Unlock()
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	if fresh {
+		return entry.responseJSON, entry.responsePk, nil
+	}
+
+	// Without a cached status response there is nothing that could be sent
+	// while refreshing, so the client has to wait for the server.
+	if ok && s.backgroundRefresh {
+		s.refreshInBackground(key, req)
+		return entry.responseJSON, entry.responsePk, nil
+	}
 
-	hash, okHash := s.statusHash[protVer]
-	entry, okCache := s.statusResponseCache[hash]
-	if !okHash || !okCache {
-		return s.cacheResponse(cliAddr, protVer, readPks)
+	v, err, _ := s.fetches.Do(key.String(), func() (any, error) {
+		return s.cacheResponse(key, req)
+	})
+	if err != nil {
+		return status.ResponseJSON{}, protocol.Packet{}, err
 	}
+	entry = v.(*statusCacheEntry)
 
 	return entry.responseJSON, entry.responsePk, nil
 }
 
-func (s *statusResponseProvider) cacheResponse(
-	cliAddr net.Addr,
-	protVer protocol.Version,
-	readPks [2]protocol.Packet,
-) (status.ResponseJSON, protocol.Packet, error) {
-	newStatusResp, pk, err := s.requestNewStatusResponseJSON(cliAddr, readPks)
-	if err != nil {
-		return status.ResponseJSON{}, protocol.Packet{}, err
+// cacheKey returns the cache key of the request. Domains that are not exact domains
+// of the server, like the ones matched by wildcards or the default server, share
+// one entry, so clients cannot grow the cache by making up domains.
+func (s *statusResponseProvider) cacheKey(req ServerRequest) statusCacheKey {
+	domain := ServerDomain(strings.ToLower(string(req.Domain)))
+	if _, ok := s.domains[domain]; !ok {
+		domain = ""
 	}
 
-	hash := xxhash.New().Sum64()
-	s.statusHash[protVer] = hash
+	return statusCacheKey{
+		domain:  domain,
+		version: req.ProtocolVersion,
+	}
+}
+
+func (s *statusResponseProvider) refreshInBackground(key statusCacheKey, req ServerRequest) {
+	// The packets of the request are reused after the request is done
+	for i, pk := range req.ReadPackets {
+		req.ReadPackets[i] = protocol.Packet{
+			ID:   pk.ID,
+			Data: bytes.Clone(pk.Data),
+		}
+	}
 
-	s.statusResponseCache[hash] = &statusCacheEntry{
+	// The result is buffered, so it is fine to not receive it
+	s.fetches.DoChan(key.String(), func() (any, error) {
+		return s.cacheResponse(key, req)
+	})
+}
+
+func (s *statusResponseProvider) cacheResponse(key statusCacheKey, req ServerRequest) (*statusCacheEntry, error) {
+	newStatusResp, pk, err := s.requestNewStatusResponseJSON(req)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &statusCacheEntry{
 		expiresAt:    time.Now().Add(s.cacheTTL),
 		responseJSON: newStatusResp,
 		responsePk:   pk,
 	}
 
-	return newStatusResp, pk, nil
+	s.mu.Lock()
+	s.cache[key] = entry
+	s.mu.Unlock()
+
+	return entry, nil
 }
 
+// prune deletes all expired entries. With background refresh enabled,
+// entries are kept for one more TTL so they can be served while refreshing.
+// prune has to be called while holding s.mu.
 func (s *statusResponseProvider) prune() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	expiredHashes := []uint64{}
-	for hash, entry := range s.statusResponseCache {
-		if entry.isExpired() {
-			delete(s.statusResponseCache, hash)
-			expiredHashes = append(expiredHashes, hash)
-		}
+	maxAge := time.Duration(0)
+	if s.backgroundRefresh {
+		maxAge = s.cacheTTL
 	}
 
-	for protVer, hash := range s.statusHash {
-		for _, expiredHash := range expiredHashes {
-			if hash == expiredHash {
-				delete(s.statusHash, protVer)
-			}
+	for key, entry := range s.cache {
+		if time.Since(entry.expiresAt) > maxAge {
+			delete(s.cache, key)
 		}
 	}
 }
@@ -0,0 +1,457 @@
+package infrared_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	ir "github.com/haveachin/infrared/pkg/infrared"
+	"github.com/haveachin/infrared/pkg/infrared/protocol"
+	"github.com/haveachin/infrared/pkg/infrared/protocol/handshaking"
+	"github.com/haveachin/infrared/pkg/infrared/protocol/status"
+	"github.com/rs/zerolog"
+)
+
+func listenTCP(t *testing.T) net.Listener {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_ = c.Close()
+		}
+	}()
+
+	return l
+}
+
+func closedAddr(t *testing.T) ir.ServerAddress {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+	return ir.ServerAddress(addr)
+}
+
+func TestServer_Dial_RoundRobin(t *testing.T) {
+	l1 := listenTCP(t)
+	l2 := listenTCP(t)
+
+	srv, err := ir.NewServer(
+		ir.WithServerAddresses(
+			ir.ServerAddress(l1.Addr().String()),
+			ir.ServerAddress(l2.Addr().String()),
+		),
+		ir.WithServerLoadBalancerStrategy(ir.RoundRobinStrategy),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		l1.Addr().String(),
+		l2.Addr().String(),
+		l1.Addr().String(),
+	}
+	for _, addr := range want {
+		rc, err := srv.Dial(ir.ServerRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = rc.Close()
+
+		if rc.RemoteAddr().String() != addr {
+			t.Errorf("got: %s; want: %s", rc.RemoteAddr(), addr)
+		}
+	}
+}
+
+func TestServer_Dial_Failover(t *testing.T) {
+	l := listenTCP(t)
+
+	srv, err := ir.NewServer(
+		ir.WithServerAddresses(
+			closedAddr(t),
+			ir.ServerAddress(l.Addr().String()),
+		),
+		ir.WithServerLoadBalancerStrategy(ir.RoundRobinStrategy),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		rc, err := srv.Dial(ir.ServerRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = rc.Close()
+
+		if rc.RemoteAddr().String() != l.Addr().String() {
+			t.Errorf("got: %s; want: %s", rc.RemoteAddr(), l.Addr())
+		}
+	}
+}
+
+func TestServer_Dial_PlayerNameHash(t *testing.T) {
+	l1 := listenTCP(t)
+	l2 := listenTCP(t)
+
+	srv, err := ir.NewServer(
+		ir.WithServerAddresses(
+			ir.ServerAddress(l1.Addr().String()),
+			ir.ServerAddress(l2.Addr().String()),
+		),
+		ir.WithServerLoadBalancerStrategy(ir.PlayerNameHashStrategy),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := ir.ServerRequest{
+		PlayerName: "Steve",
+	}
+
+	var addr string
+	for i := 0; i < 5; i++ {
+		rc, err := srv.Dial(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = rc.Close()
+
+		if addr == "" {
+			addr = rc.RemoteAddr().String()
+		} else if rc.RemoteAddr().String() != addr {
+			t.Fatalf("got: %s; want: %s", rc.RemoteAddr(), addr)
+		}
+	}
+}
+
+func TestServer_Dial_Routes(t *testing.T) {
+	lDefault := listenTCP(t)
+	lStaging := listenTCP(t)
+	lCanary := listenTCP(t)
+	lLegacy := listenTCP(t)
+
+	testerUUID := uuid.MustParse("069a79f4-44e9-4726-a5be-fca90e38aaf5")
+	srv, err := ir.NewServer(
+		ir.WithServerAddresses(ir.ServerAddress(lDefault.Addr().String())),
+		ir.WithServerRoutes(
+			ir.RouteConfig{
+				Players:   []string{"admin_*", "Notch"},
+				Addresses: []ir.ServerAddress{ir.ServerAddress(lStaging.Addr().String())},
+			},
+			ir.RouteConfig{
+				UUIDs:     []string{testerUUID.String()},
+				Addresses: []ir.ServerAddress{ir.ServerAddress(lCanary.Addr().String())},
+			},
+			ir.RouteConfig{
+				ProtocolVersions: &ir.ProtocolVersionRange{
+					Max: protocol.Version1_18_2,
+				},
+				Addresses: []ir.ServerAddress{ir.ServerAddress(lLegacy.Addr().String())},
+			},
+		),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name string
+		req  ir.ServerRequest
+		want net.Addr
+	}{
+		{
+			name: "player name pattern",
+			req:  ir.ServerRequest{PlayerName: "Admin_Steve"},
+			want: lStaging.Addr(),
+		},
+		{
+			name: "player name",
+			req:  ir.ServerRequest{PlayerName: "notch"},
+			want: lStaging.Addr(),
+		},
+		{
+			name: "player UUID",
+			req:  ir.ServerRequest{PlayerName: "Tester", PlayerUUID: testerUUID},
+			want: lCanary.Addr(),
+		},
+		{
+			name: "no match",
+			req: ir.ServerRequest{
+				PlayerName:      "Steve",
+				PlayerUUID:      uuid.New(),
+				ProtocolVersion: protocol.Version1_20_2,
+			},
+			want: lDefault.Addr(),
+		},
+		{
+			name: "status request",
+			req:  ir.ServerRequest{ProtocolVersion: protocol.Version1_20_2},
+			want: lDefault.Addr(),
+		},
+		{
+			name: "legacy client",
+			req:  ir.ServerRequest{PlayerName: "Steve", ProtocolVersion: protocol.Version1_18_2},
+			want: lLegacy.Addr(),
+		},
+		{
+			name: "legacy status request",
+			req:  ir.ServerRequest{ProtocolVersion: protocol.Version1_18_2},
+			want: lLegacy.Addr(),
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rc, err := srv.Dial(tc.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = rc.Close()
+
+			if rc.RemoteAddr().String() != tc.want.String() {
+				t.Fatalf("got: %s; want: %s", rc.RemoteAddr(), tc.want)
+			}
+		})
+	}
+}
+
+func TestNewServer_RouteWithoutAddresses(t *testing.T) {
+	_, err := ir.NewServer(
+		ir.WithServerAddresses("localhost:25565"),
+		ir.WithServerRoutes(ir.RouteConfig{
+			Players: []string{"Steve"},
+		}),
+	)
+	if !errors.Is(err, ir.ErrRouteWithoutAddresses) {
+		t.Fatalf("got: %v; want: %v", err, ir.ErrRouteWithoutAddresses)
+	}
+}
+
+func TestNewServer_UnknownLoadBalancerStrategy(t *testing.T) {
+	_, err := ir.NewServer(
+		ir.WithServerAddresses("localhost:25565"),
+		ir.WithServerLoadBalancerStrategy("unknown"),
+	)
+	if !errors.Is(err, ir.ErrUnknownLoadBalancerStrategy) {
+		t.Fatalf("got: %v; want: %s", err, ir.ErrUnknownLoadBalancerStrategy)
+	}
+}
+
+func TestNewServer_ConflictingForwarding(t *testing.T) {
+	_, err := ir.NewServer(
+		ir.WithServerAddresses("localhost:25565"),
+		ir.WithServerBungeeCordForwarding(ir.BungeeCordForwardingConfig{}),
+		ir.WithServerVelocityForwarding(ir.VelocityForwardingConfig{
+			Secret: "secret",
+		}),
+	)
+	if !errors.Is(err, ir.ErrConflictingForwarding) {
+		t.Fatalf("got: %v; want: %v", err, ir.ErrConflictingForwarding)
+	}
+}
+
+func TestNewServer_NoVelocitySecret(t *testing.T) {
+	_, err := ir.NewServer(
+		ir.WithServerAddresses("localhost:25565"),
+		ir.WithServerVelocityForwarding(ir.VelocityForwardingConfig{}),
+	)
+	if !errors.Is(err, ir.ErrNoVelocitySecret) {
+		t.Fatalf("got: %v; want: %v", err, ir.ErrNoVelocitySecret)
+	}
+}
+
+func TestNewServer_InvalidFilters(t *testing.T) {
+	_, err := ir.NewServer(
+		ir.WithServerAddresses("localhost:25565"),
+		ir.WithServerFilters(ir.RequestFiltersConfig{
+			PlayerName: &ir.PlayerNameFilterConfig{
+				Pattern: "[",
+			},
+		}),
+	)
+	if err == nil {
+		t.Fatal("got: nil; want: error")
+	}
+}
+
+func listenStatusServer(t *testing.T) net.Listener {
+	t.Helper()
+
+	return listenStatusServerFunc(t, func(hs handshaking.ServerBoundHandshake) status.ResponseJSON {
+		return status.ResponseJSON{
+			Version: status.VersionJSON{
+				Name:     "1.20.2",
+				Protocol: int(protocol.Version1_20_2),
+			},
+			Description: "Hello",
+		}
+	})
+}
+
+// listenStatusServerFunc starts a server that responds to every status request
+// with the status response returned by fn.
+func listenStatusServerFunc(
+	t *testing.T,
+	fn func(hs handshaking.ServerBoundHandshake) status.ResponseJSON,
+) net.Listener {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(c net.Conn) {
+				defer c.Close()
+				r := bufio.NewReader(c)
+				var pk protocol.Packet
+				if _, err := pk.ReadFrom(r); err != nil {
+					return
+				}
+
+				var hs handshaking.ServerBoundHandshake
+				if err := hs.Unmarshal(pk); err != nil {
+					return
+				}
+
+				// Status request
+				if _, err := pk.ReadFrom(r); err != nil {
+					return
+				}
+
+				bb, err := json.Marshal(fn(hs))
+				if err != nil {
+					return
+				}
+
+				_ = status.ClientBoundResponse{
+					JSONResponse: protocol.String(bb),
+				}.Marshal(&pk)
+				_, _ = pk.WriteTo(c)
+			}(c)
+		}
+	}()
+
+	return l
+}
+
+func TestServer_RunHealthChecks(t *testing.T) {
+	tt := []struct {
+		name     string
+		listenFn func(t *testing.T) net.Listener
+		wantErr  error
+	}{
+		{
+			name:     "status server is healthy",
+			listenFn: listenStatusServer,
+			wantErr:  nil,
+		},
+		{
+			name:     "tcp server without status is unhealthy",
+			listenFn: listenTCP,
+			wantErr:  ir.ErrNoHealthyAddresses,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			l := tc.listenFn(t)
+
+			srv, err := ir.NewServer(
+				ir.WithServerAddresses(ir.ServerAddress(l.Addr().String())),
+				ir.WithServerHealthCheck(ir.HealthCheckConfig{
+					Interval:           10 * time.Millisecond,
+					Timeout:            100 * time.Millisecond,
+					UnhealthyThreshold: 1,
+					HealthyThreshold:   1,
+				}),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go srv.RunHealthChecks(ctx, zerolog.Nop())
+
+			// Wait for a few health checks to complete
+			time.Sleep(100 * time.Millisecond)
+
+			rc, err := srv.Dial(ir.ServerRequest{})
+			if rc != nil {
+				_ = rc.Close()
+			}
+
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got: %v; want: %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestServer_RunHealthChecks_SlowCheck(t *testing.T) {
+	// The server never answers, so every check runs until its timeout
+	var dials atomic.Int32
+	l := listenTCPFunc(t, func(c net.Conn) {
+		dials.Add(1)
+		_, _ = io.Copy(io.Discard, c)
+		_ = c.Close()
+	})
+
+	srv, err := ir.NewServer(
+		ir.WithServerAddresses(ir.ServerAddress(l.Addr().String())),
+		ir.WithServerHealthCheck(ir.HealthCheckConfig{
+			Interval: 10 * time.Millisecond,
+			Timeout:  time.Second,
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.RunHealthChecks(ctx, zerolog.Nop())
+
+	time.Sleep(200 * time.Millisecond)
+
+	if n := dials.Load(); n != 1 {
+		t.Fatalf("got: %d checks; want: 1 check until the first one timed out", n)
+	}
+}
@@ -0,0 +1,165 @@
+package infrared
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+
+	"github.com/haveachin/infrared/pkg/infrared/protocol"
+	"github.com/haveachin/infrared/pkg/infrared/protocol/status"
+)
+
+const emptyPlayerID = "00000000-0000-0000-0000-000000000000"
+
+// StatusResponseConfig describes a status response that is shown
+// in the server list of the client.
+type StatusResponseConfig struct {
+	VersionName string `yaml:"versionName"`
+	// ProtocolNumber defaults to the protocol version of the client
+	ProtocolNumber int  `yaml:"protocolNumber"`
+	MaxPlayerCount *int `yaml:"maxPlayerCount"`
+	PlayerCount    *int `yaml:"playerCount"`
+	// PlayerSample is a list of names that is shown when hovering over the player count
+	PlayerSample []string `yaml:"playerSample"`
+	// IconPath is the path to a 64x64 PNG file
+	IconPath string `yaml:"iconPath"`
+	// MOTD is a chat component; see DisconnectMessagesConfig
+	MOTD any `yaml:"motd"`
+}
+
+// statusResponse is a StatusResponseConfig with its icon already loaded.
+type statusResponse struct {
+	cfg     StatusResponseConfig
+	favicon string
+}
+
+// newStatusResponse loads the status response of cfg.
+// If cfg is nil, nil is returned.
+func newStatusResponse(cfg *StatusResponseConfig) (*statusResponse, error) {
+	if cfg == nil {
+		return nil, nil //nolint:nilnil // No config means no status response
+	}
+
+	resp := &statusResponse{
+		cfg: *cfg,
+	}
+
+	if cfg.IconPath != "" {
+		favicon, err := loadFavicon(cfg.IconPath)
+		if err != nil {
+			return nil, err
+		}
+		resp.favicon = favicon
+	}
+
+	return resp, nil
+}
+
+func loadFavicon(path string) (string, error) {
+	bb, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(bb), nil
+}
+
+func (r statusResponse) packet(protVer protocol.Version) (protocol.Packet, error) {
+	return marshalStatusResponse(r.responseJSON(protVer))
+}
+
+// responseJSON builds the status response for a client with the given protocol version.
+func (r statusResponse) responseJSON(protVer protocol.Version) status.ResponseJSON {
+	protNum := r.cfg.ProtocolNumber
+	if protNum == 0 {
+		protNum = int(protVer)
+	}
+
+	respJSON := status.ResponseJSON{
+		Version: status.VersionJSON{
+			Name:     r.cfg.VersionName,
+			Protocol: protNum,
+		},
+		Players: status.PlayersJSON{
+			Sample: r.playerSample(),
+		},
+		Description: r.cfg.MOTD,
+		Favicon:     r.favicon,
+	}
+
+	if respJSON.Description == nil {
+		respJSON.Description = ""
+	}
+
+	if r.cfg.MaxPlayerCount != nil {
+		respJSON.Players.Max = *r.cfg.MaxPlayerCount
+	}
+
+	if r.cfg.PlayerCount != nil {
+		respJSON.Players.Online = *r.cfg.PlayerCount
+	}
+
+	return respJSON
+}
+
+// override replaces all fields of respJSON that are set in the config.
+// All other fields are kept as they are.
+func (r statusResponse) override(respJSON status.ResponseJSON) status.ResponseJSON {
+	if r.cfg.VersionName != "" {
+		respJSON.Version.Name = r.cfg.VersionName
+	}
+
+	if r.cfg.ProtocolNumber != 0 {
+		respJSON.Version.Protocol = r.cfg.ProtocolNumber
+	}
+
+	if r.cfg.MaxPlayerCount != nil {
+		respJSON.Players.Max = *r.cfg.MaxPlayerCount
+	}
+
+	if r.cfg.PlayerCount != nil {
+		respJSON.Players.Online = *r.cfg.PlayerCount
+	}
+
+	if len(r.cfg.PlayerSample) > 0 {
+		respJSON.Players.Sample = r.playerSample()
+	}
+
+	if r.favicon != "" {
+		respJSON.Favicon = r.favicon
+	}
+
+	if r.cfg.MOTD != nil {
+		respJSON.Description = r.cfg.MOTD
+	}
+
+	return respJSON
+}
+
+func (r statusResponse) playerSample() []status.PlayerSampleJSON {
+	if len(r.cfg.PlayerSample) == 0 {
+		return nil
+	}
+
+	sample := make([]status.PlayerSampleJSON, len(r.cfg.PlayerSample))
+	for i, name := range r.cfg.PlayerSample {
+		sample[i] = status.PlayerSampleJSON{
+			Name: name,
+			ID:   emptyPlayerID,
+		}
+	}
+	return sample
+}
+
+func marshalStatusResponse(respJSON status.ResponseJSON) (protocol.Packet, error) {
+	bb, err := json.Marshal(respJSON)
+	if err != nil {
+		return protocol.Packet{}, err
+	}
+
+	var pk protocol.Packet
+	err = status.ClientBoundResponse{
+		JSONResponse: protocol.String(bb),
+	}.Marshal(&pk)
+	return pk, err
+}
@@ -0,0 +1,71 @@
+package infrared
+
+import (
+	"errors"
+
+	"github.com/haveachin/infrared/pkg/infrared/protocol"
+	"github.com/haveachin/infrared/pkg/infrared/protocol/status"
+)
+
+var ErrUnsupportedClientVersion = errors.New("unsupported client version")
+
+// ProtocolVersionRange is an inclusive range of protocol versions.
+// A zero Min or Max leaves the range open on that side.
+type ProtocolVersionRange struct {
+	Min protocol.Version `yaml:"min"`
+	Max protocol.Version `yaml:"max"`
+}
+
+func (r ProtocolVersionRange) contains(v protocol.Version) bool {
+	if r.Min != 0 && v < r.Min {
+		return false
+	}
+
+	if r.Max != 0 && v > r.Max {
+		return false
+	}
+
+	return true
+}
+
+// SupportedVersionsConfig rejects logins of clients with a protocol version outside the range.
+type SupportedVersionsConfig struct {
+	ProtocolVersionRange `yaml:",inline"`
+	// Name is shown as the version in the server list of clients with an unsupported version.
+	// Defaults to the names of the min and max version like "1.19-1.20.2".
+	Name string `yaml:"name"`
+}
+
+func (cfg SupportedVersionsConfig) name() string {
+	if cfg.Name != "" {
+		return cfg.Name
+	}
+
+	switch {
+	case cfg.Min != 0 && cfg.Min == cfg.Max:
+		return cfg.Min.Name()
+	case cfg.Min != 0 && cfg.Max != 0:
+		return cfg.Min.Name() + "-" + cfg.Max.Name()
+	case cfg.Min != 0:
+		return cfg.Min.Name() + "+"
+	case cfg.Max != 0:
+		return "≤" + cfg.Max.Name()
+	}
+
+	return ""
+}
+
+// unsupportedVersionStatus changes the version of respJSON, so that the client shows
+// the supported versions in red.
+func (cfg SupportedVersionsConfig) unsupportedVersionStatus(respJSON status.ResponseJSON) status.ResponseJSON {
+	protVer := cfg.Max
+	if protVer == 0 {
+		protVer = cfg.Min
+	}
+
+	respJSON.Version = status.VersionJSON{
+		Name:     cfg.name(),
+		Protocol: int(protVer),
+	}
+	return respJSON
+}